@@ -20,6 +20,11 @@ def create(
         True,
         "--compress/--no-compress",
         help="Enable/disable compression",
+    ),
+    check_space: bool = typer.Option(
+        True,
+        "--check-space/--no-check-space",
+        help="Estimate the tarball size and warn when the destination lacks free space",
     )
 ):
     """Create a new tarball from a directory or file"""
@@ -27,11 +32,40 @@ def create(
         source = Path(get_path_input("Enter source path:"))
     if not output:
         output = Path(get_path_input("Enter output tarball path:"))
-    
-    # TODO: Implement Go-powered compression
+
+    from .._binding import bindings
+
+    archiver = bindings.NewArchiver(str(source), str(output), True, "all")
+    if not compress:
+        archiver.SetCodec("none", 0)
+
+    if check_space:
+        with show_spinner("Estimating..."):
+            estimate = archiver.Estimate()
+        typer.echo(
+            f"{estimate.Files} files, about {_size(estimate.OutputSize)} "
+            f"in {estimate.Seconds:.0f}s"
+        )
+        if 0 <= estimate.FreeSpace < estimate.OutputSize:
+            typer.secho(
+                f"Warning: {output.parent} has {_size(estimate.FreeSpace)} free, "
+                f"the tarball needs about {_size(estimate.OutputSize)}",
+                fg=typer.colors.YELLOW,
+            )
+            if not typer.confirm("Continue anyway?"):
+                raise typer.Abort()
+
     with show_spinner("Creating tarball..."):
-        # Placeholder for Go implementation
-        pass
+        archiver.Archive()
+
+
+def _size(n: int) -> str:
+    """Format a byte count for humans"""
+    for unit in ("B", "KiB", "MiB", "GiB"):
+        if n < 1024:
+            return f"{n:.1f} {unit}" if unit != "B" else f"{n} {unit}"
+        n /= 1024
+    return f"{n:.1f} TiB"
 
 @app.command()
 def extract(
@@ -56,3 +90,34 @@ def extract(
     with show_spinner("Extracting tarball..."):
         # Placeholder for Go implementation
         pass
+
+@app.command()
+def diff(
+    tarball: Path = typer.Option(
+        None,
+        "--tarball", "-t",
+        help="Tarball to compare",
+    ),
+    other: Path = typer.Option(
+        None,
+        "--other", "-w",
+        help="Second tarball or directory to compare against",
+    ),
+    as_json: bool = typer.Option(
+        False,
+        "--json",
+        help="Print the report as JSON",
+    )
+):
+    """Show added, removed, modified and renamed entries"""
+    if not tarball:
+        tarball = Path(get_path_input("Enter tarball path:"))
+    if not other:
+        other = Path(get_path_input("Enter tarball or directory to compare against:"))
+
+    from .._binding import bindings
+
+    archiver = bindings.NewArchiver("", str(tarball), True, "all")
+    with show_spinner("Comparing..."):
+        report = archiver.Diff(str(other), as_json)
+    typer.echo(report)
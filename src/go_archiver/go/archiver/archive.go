@@ -0,0 +1,338 @@
+package archiver
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrUnknownFormat is returned for archives in an unrecognised container
+var ErrUnknownFormat = errors.New("unknown archive format")
+
+// OutputFormat selects the archive container
+type OutputFormat string
+
+const (
+	FormatTarGz OutputFormat = "tar.gz"
+	FormatTar   OutputFormat = "tar"
+	FormatZip   OutputFormat = "zip"
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zipMagic  = []byte("PK\x03\x04")
+	// zipEmptyMagic starts the end of central directory record of an
+	// archive without entries
+	zipEmptyMagic = []byte("PK\x05\x06")
+)
+
+// entryReader iterates the entries of an archive container. *tar.Reader
+// satisfies it directly.
+type entryReader interface {
+	Next() (*tar.Header, error)
+	Read(p []byte) (int, error)
+}
+
+// entryWriter writes entries to an archive container. *tar.Writer satisfies
+// it directly.
+type entryWriter interface {
+	WriteHeader(hdr *tar.Header) error
+	Write(p []byte) (int, error)
+}
+
+// archiveLayout describes the layers of an archive, so that a rewrite can
+// keep the container, codec and recipients of the original.
+type archiveLayout struct {
+	format     OutputFormat      // FormatTar or FormatZip
+	codec      Codec             // compression of tar containers
+	enc        *encryptionHeader // nil for plaintext archives
+	volumeSize int64             // zero for single-file archives
+	seekable   bool              // gzip member per entry with a trailing index
+}
+
+// layout returns the layout for new archives. An unset Format is inferred
+// from the OutputPath extension and defaults to tar.gz. Config.Codec
+// overrides the compression implied by the format for tar containers.
+func (a *Archiver) layout() archiveLayout {
+	format := a.config.Format
+	if format == "" {
+		switch strings.ToLower(filepath.Ext(a.config.OutputPath)) {
+		case ".zip":
+			format = FormatZip
+		case ".tar":
+			format = FormatTar
+		default:
+			format = FormatTarGz
+		}
+	}
+
+	switch format {
+	case FormatZip:
+		return archiveLayout{format: FormatZip, codec: CodecNone}
+	case FormatTar:
+		layout := archiveLayout{format: FormatTar, codec: CodecNone}
+		if a.config.Codec != "" {
+			layout.codec = a.config.Codec
+		}
+		return layout
+	case FormatTarGz:
+		layout := archiveLayout{format: FormatTar, codec: CodecGzip, seekable: a.config.Seekable}
+		if a.config.Codec != "" {
+			layout.codec = a.config.Codec
+		}
+		return layout
+	}
+	return archiveLayout{format: format}
+}
+
+// archiveReader exposes the entries of an archive on disk, undoing the
+// encryption and compression layers and detecting the container.
+type archiveReader struct {
+	entryReader
+	layout  archiveLayout
+	closers []io.Closer
+}
+
+// openArchive opens the archive at path for reading.
+func (a *Archiver) openArchive(path string) (*archiveReader, error) {
+	raw, err := openRaw(path)
+	if err != nil {
+		return nil, err
+	}
+
+	ar := &archiveReader{closers: []io.Closer{raw}}
+	ar.layout.volumeSize = raw.volumeSize
+	if raw.file != nil {
+		if info, err := raw.file.Stat(); err == nil {
+			_, ar.layout.seekable = readLocator(raw.file, info.Size())
+		}
+	}
+	if err := a.decodeArchive(ar, raw); err != nil {
+		ar.Close()
+		return nil, err
+	}
+	return ar, nil
+}
+
+// decodeArchive peels the layers off raw and sets up the entry reader.
+func (a *Archiver) decodeArchive(ar *archiveReader, raw *rawArchive) error {
+	br := bufio.NewReader(raw)
+
+	if isEncrypted(br) {
+		hdr, err := readEncryptionHeader(br, a.config.Identities)
+		if err != nil {
+			return err
+		}
+		dr, err := newDecryptReader(br, hdr)
+		if err != nil {
+			return err
+		}
+		ar.layout.enc = hdr
+		br = bufio.NewReader(dr)
+	}
+
+	magic, err := br.Peek(len(zipMagic))
+	if err != nil && err != io.EOF {
+		return err
+	}
+	if bytes.HasPrefix(magic, zipMagic) || bytes.HasPrefix(magic, zipEmptyMagic) {
+		ar.layout.format = FormatZip
+		ar.layout.codec = CodecNone
+		// Zip needs random access. Plaintext single-file archives are read
+		// in place, anything else is spooled to a temporary file first.
+		var ra io.ReaderAt = raw.file
+		if ar.layout.enc != nil || raw.file == nil {
+			spool, err := spoolToTemp(br)
+			if err != nil {
+				return err
+			}
+			ar.closers = append(ar.closers, spool)
+			ra = spool
+		}
+		zr, err := newZipEntryReader(ra)
+		if err != nil {
+			return err
+		}
+		ar.entryReader = zr
+		ar.closers = append(ar.closers, zr)
+		return nil
+	}
+
+	ar.layout.format = FormatTar
+	if ok, err := isTar(br); err != nil || ok {
+		ar.layout.codec = CodecNone
+		ar.entryReader = tar.NewReader(br)
+		return err
+	}
+
+	header, err := br.Peek(16)
+	if err != nil && err != io.EOF {
+		return err
+	}
+	c := detectCompressor(header)
+	rc, err := c.NewReader(br)
+	if err != nil {
+		return err
+	}
+	ar.closers = append(ar.closers, rc)
+
+	dbr := bufio.NewReader(rc)
+	ok, err := isTar(dbr)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrUnknownFormat
+	}
+	ar.layout.codec = c.Codec()
+	ar.entryReader = tar.NewReader(dbr)
+	return nil
+}
+
+// isTar reports whether the buffered stream starts with a tar header.
+func isTar(br *bufio.Reader) (bool, error) {
+	block, err := br.Peek(512)
+	if err == io.EOF {
+		// A truncated block is not tar, an empty stream holds no entries
+		return len(block) == 0, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return string(block[257:262]) == "ustar" || bytes.Equal(block, make([]byte, 512)), nil
+}
+
+// Close releases the archive file and any temporary state, newest first.
+func (r *archiveReader) Close() error {
+	var first error
+	for i := len(r.closers) - 1; i >= 0; i-- {
+		if err := r.closers[i].Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// tempFile is a temporary file removed on Close.
+type tempFile struct {
+	*os.File
+}
+
+func (t tempFile) Close() error {
+	err := t.File.Close()
+	os.Remove(t.Name())
+	return err
+}
+
+// spoolToTemp copies r to a temporary file for random access.
+func spoolToTemp(r io.Reader) (*tempFile, error) {
+	f, err := os.CreateTemp("", "archiver_spool_*")
+	if err != nil {
+		return nil, err
+	}
+	spool := &tempFile{f}
+	if _, err := io.Copy(f, r); err != nil {
+		spool.Close()
+		return nil, err
+	}
+	return spool, nil
+}
+
+// archiveWriter writes entries through the container, compression and, when
+// the archive has recipients, encryption layers.
+type archiveWriter struct {
+	entryWriter
+	// closers are closed last to first, so the entry layer is flushed
+	// before the layers it writes through
+	closers []io.Closer
+	// checkpointer is set for archives written with checkpoints
+	checkpointer checkpointer
+}
+
+// newArchiveWriter layers the archive format over w. A nil layout writes a
+// new archive as configured; otherwise the layout of an existing archive is
+// kept, including its recipients.
+func (a *Archiver) newArchiveWriter(w io.Writer, compression CompressionLevel, layout *archiveLayout) (*archiveWriter, error) {
+	var (
+		enc     *encryptionHeader
+		err     error
+		rewrite = layout != nil
+	)
+	if layout == nil {
+		l := a.layout()
+		layout = &l
+		if len(a.config.Recipients) > 0 {
+			enc, err = newEncryptionHeader(a.config.Recipients)
+		}
+		if a.config.Seekable && (layout.format != FormatTar || layout.codec != CodecGzip ||
+			len(a.config.Recipients) > 0 || a.config.VolumeSize > 0) {
+			return nil, ErrNotSeekable
+		}
+	} else if layout.enc != nil {
+		enc, err = layout.enc.withNewSalt()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	aw := &archiveWriter{}
+	if enc != nil {
+		ew, err := newEncryptWriter(w, enc)
+		if err != nil {
+			return nil, err
+		}
+		aw.closers = append(aw.closers, ew)
+		w = ew
+	}
+
+	switch layout.format {
+	case FormatZip:
+		zw := newZipEntryWriter(w, compression)
+		aw.closers = append(aw.closers, zw)
+		aw.entryWriter = zw
+	case FormatTar:
+		if layout.seekable && enc == nil && layout.volumeSize == 0 {
+			iw, gz, err := newSeekableWriter(w, compression, a.metaTime())
+			if err != nil {
+				return nil, err
+			}
+			aw.closers = append(aw.closers, gz, iw)
+			aw.entryWriter = iw
+			break
+		}
+
+		c, err := compressorFor(layout.codec)
+		if err != nil {
+			return nil, err
+		}
+		cw, err := c.NewWriter(w, compression)
+		if errors.Is(err, ErrReadOnlyCodec) && rewrite {
+			// Archives in a read-only codec are rewritten as gzip
+			cw, err = gzipCompressor{}.NewWriter(w, compression)
+		}
+		if err != nil {
+			return nil, err
+		}
+		tw := tar.NewWriter(cw)
+		aw.closers = append(aw.closers, cw, tw)
+		aw.entryWriter = tw
+	default:
+		return nil, ErrUnknownFormat
+	}
+	return aw, nil
+}
+
+// Close flushes every layer. It does not close the underlying writer.
+func (w *archiveWriter) Close() error {
+	for i := len(w.closers) - 1; i >= 0; i-- {
+		if err := w.closers[i].Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
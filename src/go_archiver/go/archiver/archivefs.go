@@ -0,0 +1,324 @@
+package archiver
+
+import (
+	"archive/tar"
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ArchiveFS exposes the entries of an archive as a read-only file system.
+// It implements fs.StatFS, fs.ReadDirFS and fs.ReadFileFS. Directories are
+// synthesised from entry names where the archive has no directory entries.
+// Symbolic links and special files report their type in the file mode and
+// read as empty. The listing is taken when the ArchiveFS is created.
+type ArchiveFS struct {
+	a     *Archiver
+	files map[string]*archiveFileInfo
+	dirs  map[string]*archiveDir
+}
+
+var (
+	_ fs.StatFS     = (*ArchiveFS)(nil)
+	_ fs.ReadDirFS  = (*ArchiveFS)(nil)
+	_ fs.ReadFileFS = (*ArchiveFS)(nil)
+)
+
+// archiveDir is a directory with its sorted children
+type archiveDir struct {
+	info    *archiveFileInfo
+	entries []fs.DirEntry
+}
+
+// FS opens the archive at OutputPath as a file system. Seekable archives
+// are read by seeking to each entry; other archives are read up to it.
+// When TrustedKeys are configured the archive signature is verified once,
+// before the listing is taken.
+func (a *Archiver) FS() (*ArchiveFS, error) {
+	if len(a.config.TrustedKeys) > 0 {
+		if err := a.Verify(); err != nil {
+			return nil, err
+		}
+	}
+	info, err := a.scanTarball()
+	if err != nil {
+		return nil, err
+	}
+
+	fsys := &ArchiveFS{
+		a:     a,
+		files: make(map[string]*archiveFileInfo),
+		dirs:  make(map[string]*archiveDir),
+	}
+	fsys.dirs["."] = &archiveDir{info: &archiveFileInfo{name: ".", mode: fs.ModeDir | 0755}}
+
+	info.mu.RLock()
+	defer info.mu.RUnlock()
+
+	for name, entry := range info.Files {
+		clean := strings.TrimPrefix(strings.TrimSuffix(name, "/"), "./")
+		if !fs.ValidPath(clean) || clean == "." {
+			continue
+		}
+		hdr := entry.Header
+		if hdr.Typeflag == tar.TypeDir {
+			fsys.addDir(clean, hdr.ModTime, fs.FileMode(hdr.Mode).Perm())
+			continue
+		}
+
+		size, mode := entry.Size, fs.FileMode(hdr.Mode).Perm()
+		switch hdr.Typeflag {
+		case tar.TypeLink:
+			// Hard links report the size of their target
+			if target, ok := info.Files[hdr.Linkname]; ok {
+				size = target.Size
+			}
+		case tar.TypeSymlink:
+			mode |= fs.ModeSymlink
+		case tar.TypeChar:
+			mode |= fs.ModeDevice | fs.ModeCharDevice
+		case tar.TypeBlock:
+			mode |= fs.ModeDevice
+		case tar.TypeFifo:
+			mode |= fs.ModeNamedPipe
+		}
+		fsys.files[clean] = &archiveFileInfo{
+			name:    path.Base(clean),
+			entry:   name,
+			size:    size,
+			mode:    mode,
+			modTime: entry.ModTime,
+		}
+		fsys.addDir(path.Dir(clean), time.Time{}, 0)
+	}
+
+	// Link every file and directory into its parent
+	for name, file := range fsys.files {
+		parent := fsys.dirs[path.Dir(name)]
+		parent.entries = append(parent.entries, fs.FileInfoToDirEntry(file))
+	}
+	for name, dir := range fsys.dirs {
+		if name != "." {
+			parent := fsys.dirs[path.Dir(name)]
+			parent.entries = append(parent.entries, fs.FileInfoToDirEntry(dir.info))
+		}
+	}
+	for _, dir := range fsys.dirs {
+		sort.Slice(dir.entries, func(i, j int) bool {
+			return dir.entries[i].Name() < dir.entries[j].Name()
+		})
+	}
+	return fsys, nil
+}
+
+// addDir registers a directory and its parents. Explicit directory entries
+// supply the metadata of directories that were synthesised before them.
+func (f *ArchiveFS) addDir(name string, modTime time.Time, perm fs.FileMode) {
+	if perm == 0 {
+		perm = 0755
+	}
+	for ; name != "."; name = path.Dir(name) {
+		if dir, ok := f.dirs[name]; ok {
+			if !modTime.IsZero() {
+				dir.info.modTime = modTime
+				dir.info.mode = fs.ModeDir | perm
+			}
+			continue
+		}
+		f.dirs[name] = &archiveDir{info: &archiveFileInfo{
+			name:    path.Base(name),
+			mode:    fs.ModeDir | perm,
+			modTime: modTime,
+		}}
+		// Parents are synthesised without metadata
+		modTime, perm = time.Time{}, 0755
+	}
+}
+
+// Open opens the named file or directory
+func (f *ArchiveFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	if dir, ok := f.dirs[name]; ok {
+		return &archiveDirFile{dir: dir}, nil
+	}
+	info, ok := f.files[name]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	return &archiveFile{fsys: f, info: info}, nil
+}
+
+// Stat returns the metadata of the named file or directory without reading
+// the archive
+func (f *ArchiveFS) Stat(name string) (fs.FileInfo, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrInvalid}
+	}
+	if dir, ok := f.dirs[name]; ok {
+		return dir.info, nil
+	}
+	if info, ok := f.files[name]; ok {
+		return info, nil
+	}
+	return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+}
+
+// ReadDir lists the named directory, sorted by name
+func (f *ArchiveFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+	dir, ok := f.dirs[name]
+	if !ok {
+		err := fs.ErrNotExist
+		if _, isFile := f.files[name]; isFile {
+			err = errors.New("not a directory")
+		}
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: err}
+	}
+	return append([]fs.DirEntry(nil), dir.entries...), nil
+}
+
+// ReadFile returns the content of the named file
+func (f *ArchiveFS) ReadFile(name string) ([]byte, error) {
+	file, err := f.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	if _, ok := file.(*archiveDirFile); ok {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: errors.New("is a directory")}
+	}
+	return io.ReadAll(file)
+}
+
+// archiveFileInfo implements fs.FileInfo for entries and directories
+type archiveFileInfo struct {
+	name    string
+	entry   string // archive entry name, empty for directories
+	size    int64
+	mode    fs.FileMode
+	modTime time.Time
+}
+
+func (i *archiveFileInfo) Name() string       { return i.name }
+func (i *archiveFileInfo) Size() int64        { return i.size }
+func (i *archiveFileInfo) Mode() fs.FileMode  { return i.mode }
+func (i *archiveFileInfo) ModTime() time.Time { return i.modTime }
+func (i *archiveFileInfo) IsDir() bool        { return i.mode.IsDir() }
+func (i *archiveFileInfo) Sys() any           { return nil }
+
+// archiveFile reads an entry. The entry is opened on the first read and
+// reopened when seeking backwards, so seeking to learn the size, as
+// http.FileServer does, costs nothing.
+type archiveFile struct {
+	fsys   *ArchiveFS
+	info   *archiveFileInfo
+	rc     io.ReadCloser
+	pos    int64 // position of the next Read
+	stream int64 // position of rc
+	closed bool
+}
+
+func (f *archiveFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+
+func (f *archiveFile) Read(p []byte) (int, error) {
+	if f.closed {
+		return 0, fs.ErrClosed
+	}
+	if f.pos >= f.info.size {
+		return 0, io.EOF
+	}
+
+	if f.rc == nil || f.stream > f.pos {
+		if f.rc != nil {
+			f.rc.Close()
+		}
+		rc, _, err := f.fsys.a.openLinked(f.info.entry)
+		if err != nil {
+			f.rc = nil
+			return 0, err
+		}
+		f.rc, f.stream = rc, 0
+	}
+	if f.stream < f.pos {
+		n, err := io.CopyN(io.Discard, f.rc, f.pos-f.stream)
+		f.stream += n
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := f.rc.Read(p)
+	f.pos += int64(n)
+	f.stream += int64(n)
+	return n, err
+}
+
+func (f *archiveFile) Seek(offset int64, whence int) (int64, error) {
+	if f.closed {
+		return 0, fs.ErrClosed
+	}
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.pos
+	case io.SeekEnd:
+		offset += f.info.size
+	default:
+		return 0, &fs.PathError{Op: "seek", Path: f.info.name, Err: fs.ErrInvalid}
+	}
+	if offset < 0 {
+		return 0, &fs.PathError{Op: "seek", Path: f.info.name, Err: fs.ErrInvalid}
+	}
+	f.pos = offset
+	return offset, nil
+}
+
+func (f *archiveFile) Close() error {
+	if f.closed {
+		return fs.ErrClosed
+	}
+	f.closed = true
+	if f.rc != nil {
+		return f.rc.Close()
+	}
+	return nil
+}
+
+// archiveDirFile is an open directory
+type archiveDirFile struct {
+	dir    *archiveDir
+	offset int
+}
+
+func (d *archiveDirFile) Stat() (fs.FileInfo, error) { return d.dir.info, nil }
+
+func (d *archiveDirFile) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.dir.info.name, Err: errors.New("is a directory")}
+}
+
+func (d *archiveDirFile) Close() error { return nil }
+
+func (d *archiveDirFile) ReadDir(n int) ([]fs.DirEntry, error) {
+	rest := d.dir.entries[d.offset:]
+	if n <= 0 {
+		d.offset += len(rest)
+		return append([]fs.DirEntry(nil), rest...), nil
+	}
+	if len(rest) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(rest) {
+		n = len(rest)
+	}
+	d.offset += n
+	return append([]fs.DirEntry(nil), rest[:n]...), nil
+}
@@ -0,0 +1,157 @@
+package archiver
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestArchiveFS(t *testing.T) {
+	for _, seekable := range []bool{false, true} {
+		outputPath := filepath.Join(t.TempDir(), "archive.tar.gz")
+		a := createArchive(t, Config{
+			SourcePath: "testdata/source",
+			OutputPath: outputPath,
+			Recursive:  true,
+			FilterMode: FilterAll,
+			Seekable:   seekable,
+		})
+
+		fsys, err := a.FS()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := fstest.TestFS(fsys, "doc1.txt", "photo1.jpg", "video2.webm"); err != nil {
+			t.Errorf("seekable=%v: %v", seekable, err)
+		}
+
+		got, err := fs.ReadFile(fsys, "doc1.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		want, err := os.ReadFile("testdata/source/documents/doc1.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != string(want) {
+			t.Errorf("Expected doc1.txt content %q, got %q", want, got)
+		}
+		// The stored manifest is in the archive but not in the file system
+		if _, err := a.StoredManifest(); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := fsys.Open(manifestMetaEntry); err == nil {
+			t.Error("Expected meta entries to be hidden")
+		}
+		if _, err := fs.Stat(fsys, ".archiver"); err == nil {
+			t.Error("Expected no directory for meta entries")
+		}
+	}
+}
+
+func TestArchiveFSDirectories(t *testing.T) {
+	outputPath := filepath.Join(t.TempDir(), "nested.tar.gz")
+	f, err := os.Create(outputPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := gzip.NewWriter(f)
+	tw := tar.NewWriter(zw)
+	modTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	entries := []struct {
+		hdr     tar.Header
+		content string
+	}{
+		{tar.Header{Name: "docs/", Typeflag: tar.TypeDir, Mode: 0700, ModTime: modTime}, ""},
+		{tar.Header{Name: "docs/a.txt", Typeflag: tar.TypeReg, Mode: 0644}, "alpha"},
+		{tar.Header{Name: "src/pkg/b.go", Typeflag: tar.TypeReg, Mode: 0644}, "package pkg"},
+		{tar.Header{Name: "src/c.txt", Typeflag: tar.TypeLink, Linkname: "docs/a.txt", Mode: 0644}, ""},
+		{tar.Header{Name: "src/d.txt", Typeflag: tar.TypeSymlink, Linkname: "c.txt", Mode: 0777}, ""},
+	}
+	for _, e := range entries {
+		e.hdr.Size = int64(len(e.content))
+		if err := tw.WriteHeader(&e.hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.WriteString(tw, e.content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	zw.Close()
+	f.Close()
+
+	fsys, err := New(Config{OutputPath: outputPath}).FS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fstest.TestFS(fsys, "docs/a.txt", "src/pkg/b.go", "src/c.txt", "src/d.txt"); err != nil {
+		t.Error(err)
+	}
+
+	var walked []string
+	err = fs.WalkDir(fsys, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		walked = append(walked, p)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{".", "docs", "docs/a.txt", "src", "src/c.txt", "src/d.txt", "src/pkg", "src/pkg/b.go"}
+	if !reflect.DeepEqual(walked, want) {
+		t.Errorf("Expected walk %v, got %v", want, walked)
+	}
+
+	// Explicit directory entries keep their metadata
+	info, err := fs.Stat(fsys, "docs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode() != fs.ModeDir|0700 || !info.ModTime().Equal(modTime) {
+		t.Errorf("Expected docs metadata from its entry, got %v %v", info.Mode(), info.ModTime())
+	}
+
+	// Hard links read and report their target
+	link, err := fs.ReadFile(fsys, "src/c.txt")
+	if err != nil || string(link) != "alpha" {
+		t.Errorf("Expected link content alpha, got %q (%v)", link, err)
+	}
+	if info, _ := fs.Stat(fsys, "src/c.txt"); info == nil || info.Size() != 5 {
+		t.Errorf("Expected link size 5, got %v", info)
+	}
+
+	// Symbolic links keep their type
+	if info, _ := fs.Stat(fsys, "src/d.txt"); info == nil || info.Mode() != fs.ModeSymlink|0777 {
+		t.Errorf("Expected a symlink, got %v", info)
+	}
+
+	// Seeking backwards reopens the entry
+	file, err := fsys.Open("src/pkg/b.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	seeker := file.(io.ReadSeeker)
+	if _, err := io.ReadAll(seeker); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := seeker.Seek(8, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	rest, err := io.ReadAll(seeker)
+	if err != nil || string(rest) != "pkg" {
+		t.Errorf("Expected pkg after seeking, got %q (%v)", rest, err)
+	}
+}
@@ -0,0 +1,309 @@
+package archiver
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+// backupArchive lists the entries and backup metadata of an archive
+func backupArchive(t *testing.T, path string) ([]string, BackupInfo) {
+	t.Helper()
+
+	tr, err := New(Config{OutputPath: path}).openArchive(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tr.Close()
+
+	var (
+		names []string
+		info  BackupInfo
+	)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if header.Name == backupEntry {
+			if err := json.NewDecoder(tr).Decode(&info); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		if isMetaEntry(header.Name) {
+			continue
+		}
+		names = append(names, header.Name)
+	}
+	sort.Strings(names)
+	return names, info
+}
+
+func writeSourceFile(t *testing.T, path, content string, modTime time.Time) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestIncrementalBackup(t *testing.T) {
+	source := t.TempDir()
+	outputDir := t.TempDir()
+	snapshot := filepath.Join(outputDir, "snapshot.json")
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	writeSourceFile(t, filepath.Join(source, "a.txt"), "a", base)
+	writeSourceFile(t, filepath.Join(source, "b.txt"), "b", base)
+	writeSourceFile(t, filepath.Join(source, "c.txt"), "c", base)
+
+	backup := func(level BackupLevel, name string) ([]string, BackupInfo) {
+		t.Helper()
+		output := filepath.Join(outputDir, name)
+		createArchive(t, Config{
+			SourcePath:   source,
+			OutputPath:   output,
+			FilterMode:   FilterAll,
+			BackupLevel:  level,
+			SnapshotPath: snapshot,
+		})
+		return backupArchive(t, output)
+	}
+
+	// Without a snapshot an incremental run starts the chain with a full backup
+	names, info := backup(LevelIncremental, "full.tar.gz")
+	if info.Level != LevelFull || !reflect.DeepEqual(names, []string{"a.txt", "b.txt", "c.txt"}) {
+		t.Fatalf("Expected a full backup, got %s %v", info.Level, names)
+	}
+
+	writeSourceFile(t, filepath.Join(source, "a.txt"), "changed", base.Add(time.Hour))
+	if err := os.Remove(filepath.Join(source, "b.txt")); err != nil {
+		t.Fatal(err)
+	}
+
+	names, info = backup(LevelIncremental, "inc1.tar.gz")
+	if !reflect.DeepEqual(names, []string{"a.txt"}) {
+		t.Errorf("Expected only the changed file, got %v", names)
+	}
+	if !reflect.DeepEqual(info.Tombstones, []string{"b.txt"}) {
+		t.Errorf("Expected a tombstone for b.txt, got %v", info.Tombstones)
+	}
+
+	writeSourceFile(t, filepath.Join(source, "d.txt"), "d", base)
+
+	// The next incremental only sees changes since the previous run
+	names, info = backup(LevelIncremental, "inc2.tar.gz")
+	if !reflect.DeepEqual(names, []string{"d.txt"}) || len(info.Tombstones) != 0 {
+		t.Errorf("Expected only d.txt, got %v and tombstones %v", names, info.Tombstones)
+	}
+
+	// A differential collects every change since the full backup
+	names, info = backup(LevelDifferential, "diff.tar.gz")
+	if !reflect.DeepEqual(names, []string{"a.txt", "d.txt"}) {
+		t.Errorf("Expected changes since the full backup, got %v", names)
+	}
+	if !reflect.DeepEqual(info.Tombstones, []string{"b.txt"}) {
+		t.Errorf("Expected a tombstone for b.txt, got %v", info.Tombstones)
+	}
+
+	snap, err := LoadSnapshot(snapshot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snap.Entries["c.txt"].SHA256 == "" {
+		t.Error("Expected unchanged files to keep their hash in the snapshot")
+	}
+}
+
+func TestRestoreChain(t *testing.T) {
+	source := t.TempDir()
+	outputDir := t.TempDir()
+	snapshot := filepath.Join(outputDir, "snapshot.json")
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	backup := func(level BackupLevel, name string) BackupInfo {
+		t.Helper()
+		output := filepath.Join(outputDir, name)
+		createArchive(t, Config{
+			SourcePath:   source,
+			OutputPath:   output,
+			FilterMode:   FilterAll,
+			BackupLevel:  level,
+			SnapshotPath: snapshot,
+		})
+		_, info := backupArchive(t, output)
+		return info
+	}
+
+	writeSourceFile(t, filepath.Join(source, "a.txt"), "a1", base)
+	writeSourceFile(t, filepath.Join(source, "b.txt"), "b1", base)
+	backup(LevelFull, "1-full.tar.gz")
+
+	writeSourceFile(t, filepath.Join(source, "a.txt"), "a2", base.Add(time.Hour))
+	if err := os.Remove(filepath.Join(source, "b.txt")); err != nil {
+		t.Fatal(err)
+	}
+	inc := backup(LevelIncremental, "2-inc.tar.gz")
+
+	writeSourceFile(t, filepath.Join(source, "c.txt"), "c1", base)
+	backup(LevelIncremental, "3-inc.tar.gz")
+
+	// Stray files next to the backups are not part of the chain
+	full, err := os.ReadFile(filepath.Join(outputDir, "1-full.tar.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	strays := map[string][]byte{
+		"4-partial.tar.gz": full[:len(full)/2],
+		"notes.txt":        []byte("not an archive"),
+		"empty.tar":        nil,
+		"archive.cache":    []byte(`{"files": {}}`),
+	}
+	for name, data := range strays {
+		if err := os.WriteFile(filepath.Join(outputDir, name), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	a := New(Config{})
+
+	// Restoring to the first increment ignores later archives
+	plan, err := a.PlanRestore(outputDir, inc.Time)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Archives) != 2 || !reflect.DeepEqual(plan.Deleted, []string{"b.txt"}) {
+		t.Errorf("Unexpected plan:\n%s", plan)
+	}
+	if plan.Sources["a.txt"] != filepath.Join(outputDir, "2-inc.tar.gz") {
+		t.Errorf("Expected a.txt from the increment, got %s", plan.Sources["a.txt"])
+	}
+
+	plan, err = a.PlanRestore(outputDir, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dest := t.TempDir()
+	for result := range a.Restore(plan, dest) {
+		if result.Error != nil {
+			t.Fatalf("Restore failed: %v", result.Error)
+		}
+	}
+	for name, want := range map[string]string{"a.txt": "a2", "c.txt": "c1"} {
+		data, err := os.ReadFile(filepath.Join(dest, name))
+		if err != nil || string(data) != want {
+			t.Errorf("Expected %s to contain %q, got %q (%v)", name, want, data, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dest, "b.txt")); !os.IsNotExist(err) {
+		t.Error("Expected deleted file not to be restored")
+	}
+
+	// A missing increment breaks the chain
+	if err := os.Remove(filepath.Join(outputDir, "2-inc.tar.gz")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.PlanRestore(outputDir, time.Time{}); !errors.Is(err, ErrBrokenChain) {
+		t.Errorf("Expected ErrBrokenChain, got %v", err)
+	}
+}
+
+func TestBackupAfterErrors(t *testing.T) {
+	outputDir := t.TempDir()
+	snapshot := filepath.Join(outputDir, "snapshot.json")
+	source := newFailingFS(0)
+	config := Config{
+		SourceFS:     source,
+		OutputPath:   filepath.Join(outputDir, "full.tar.gz"),
+		FilterMode:   FilterAll,
+		BackupLevel:  LevelFull,
+		SnapshotPath: snapshot,
+		ErrorPolicy:  ErrorContinue,
+	}
+	if _, reported, final := createWithErrors(t, config); final.Error != nil || len(reported) != 2 {
+		t.Fatalf("Expected two failed files, got %v and %v", reported, final.Error)
+	}
+
+	// Files that failed are archived again once they can be read
+	config.SourceFS = source.MapFS
+	config.OutputPath = filepath.Join(outputDir, "inc.tar.gz")
+	config.BackupLevel = LevelIncremental
+	createArchive(t, config)
+	names, info := backupArchive(t, config.OutputPath)
+	if !reflect.DeepEqual(names, []string{"broken.txt", "missing.txt"}) {
+		t.Errorf("Expected the failed files in the incremental, got %v", names)
+	}
+	if len(info.Tombstones) != 0 {
+		t.Errorf("Expected no tombstones, got %v", info.Tombstones)
+	}
+}
+
+func TestSignedBackupMetadata(t *testing.T) {
+	signingKey, publicKey, err := GenerateSigningKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	source := t.TempDir()
+	outputDir := t.TempDir()
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	writeSourceFile(t, filepath.Join(source, "a.txt"), "a", base)
+	writeSourceFile(t, filepath.Join(source, "b.txt"), "b", base)
+
+	config := Config{
+		SourcePath:   source,
+		OutputPath:   filepath.Join(outputDir, "1-full.tar"),
+		FilterMode:   FilterAll,
+		BackupLevel:  LevelFull,
+		SnapshotPath: filepath.Join(t.TempDir(), "snapshot.json"),
+		SigningKey:   signingKey,
+		TrustedKeys:  []string{publicKey},
+	}
+	if err := createArchive(t, config).Verify(); err != nil {
+		t.Fatalf("Expected a signed backup to verify, got %v", err)
+	}
+
+	// Add a tombstone for a.txt, keeping the signature
+	rewriteTar(t, config.OutputPath, func(header *tar.Header, content []byte) []byte {
+		if header.Name != backupEntry {
+			return content
+		}
+		var info BackupInfo
+		if err := json.Unmarshal(content, &info); err != nil {
+			t.Fatal(err)
+		}
+		info.Tombstones = append(info.Tombstones, "a.txt")
+		content, err := json.Marshal(info)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return content
+	})
+
+	a := New(Config{TrustedKeys: []string{publicKey}})
+	plan, err := a.PlanRestore(outputDir, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var restoreErr error
+	for result := range a.Restore(plan, t.TempDir()) {
+		if result.Error != nil {
+			restoreErr = result.Error
+		}
+	}
+	if !errors.Is(restoreErr, ErrManifestMismatch) {
+		t.Errorf("Expected the altered tombstones to be rejected, got %v", restoreErr)
+	}
+}
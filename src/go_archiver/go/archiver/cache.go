@@ -0,0 +1,271 @@
+package archiver
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+// indexCacheExtension names the sidecar holding the cached entry listing
+const indexCacheExtension = ".cache.json"
+
+// keySampleSize is how much of the head and tail of an archive is hashed
+// into its cache key
+const keySampleSize = 64 << 10
+
+// archiveKey identifies one version of an archive on disk. Hashing the head
+// and tail catches rewrites that keep the size and mtime without reading
+// the whole archive.
+type archiveKey struct {
+	Size    int64  `json:"size"`
+	ModTime int64  `json:"mtime"`
+	Hash    string `json:"hash"`
+}
+
+// cachedIndex is the entry listing of an archive together with the key of
+// the archive it was read from
+type cachedIndex struct {
+	Key     archiveKey   `json:"key"`
+	Entries []IndexEntry `json:"entries"`
+}
+
+// archiveKeyFor computes the key of the archive at p, a single file or a
+// volume set
+func archiveKeyFor(p string) (archiveKey, error) {
+	var key archiveKey
+
+	files := []string{p}
+	if _, err := os.Stat(p); os.IsNotExist(err) {
+		files = nil
+		for n := 1; ; n++ {
+			if _, err := os.Stat(volumeName(p, n)); err != nil {
+				break
+			}
+			files = append(files, volumeName(p, n))
+		}
+		if len(files) == 0 {
+			return key, err
+		}
+	}
+
+	h := sha256.New()
+	for i, name := range files {
+		f, err := os.Open(name)
+		if err != nil {
+			return key, err
+		}
+		info, err := f.Stat()
+		if err == nil {
+			key.Size += info.Size()
+			if mtime := info.ModTime().UnixNano(); mtime > key.ModTime {
+				key.ModTime = mtime
+			}
+			if i == 0 {
+				_, err = io.Copy(h, io.NewSectionReader(f, 0, keySampleSize))
+			}
+			if err == nil && i == len(files)-1 {
+				start := info.Size() - keySampleSize
+				if start < 0 {
+					start = 0
+				}
+				_, err = io.Copy(h, io.NewSectionReader(f, start, keySampleSize))
+			}
+		}
+		f.Close()
+		if err != nil {
+			return key, err
+		}
+	}
+	key.Hash = hex.EncodeToString(h.Sum(nil))
+	return key, nil
+}
+
+// cachedTarballInfo returns the cached listing if it still matches the
+// archive, checking the Archiver first and the sidecar second
+func (a *Archiver) cachedTarballInfo(key archiveKey) *TarballInfo {
+	a.indexMu.Lock()
+	defer a.indexMu.Unlock()
+
+	if a.index == nil {
+		data, err := os.ReadFile(a.config.OutputPath + indexCacheExtension)
+		if err != nil {
+			return nil
+		}
+		var cached cachedIndex
+		if json.Unmarshal(data, &cached) != nil {
+			return nil
+		}
+		a.index = &cached
+	}
+	if a.index.Key != key {
+		return nil
+	}
+	return tarballInfo(a.index.Entries)
+}
+
+// storeTarballInfo caches a listing on the Archiver and, unless the archive
+// is encrypted and its names must not leak, in the sidecar
+func (a *Archiver) storeTarballInfo(key archiveKey, entries []IndexEntry, persist bool) {
+	a.indexMu.Lock()
+	defer a.indexMu.Unlock()
+
+	a.index = &cachedIndex{Key: key, Entries: entries}
+	sidecar := a.config.OutputPath + indexCacheExtension
+	if !persist {
+		os.Remove(sidecar)
+		return
+	}
+	// The cache is an optimisation; failing to write it is not an error
+	if data, err := json.Marshal(a.index); err == nil {
+		os.WriteFile(sidecar, data, 0644)
+	}
+}
+
+// invalidateTarballInfo drops the cached listing
+func (a *Archiver) invalidateTarballInfo() {
+	a.indexMu.Lock()
+	defer a.indexMu.Unlock()
+
+	a.index = nil
+	os.Remove(a.config.OutputPath + indexCacheExtension)
+}
+
+// tarballInfo builds a TarballInfo from index entries
+func tarballInfo(entries []IndexEntry) *TarballInfo {
+	info := &TarballInfo{
+		Files: make(map[string]FileEntry, len(entries)),
+	}
+	for _, entry := range entries {
+		info.Files[entry.Name] = FileEntry{
+			Header:  entry.header(),
+			Offset:  entry.Offset,
+			Size:    entry.Size,
+			ModTime: entry.ModTime,
+		}
+	}
+	return info
+}
+
+// indexEntryFor records a header for the cache
+func indexEntryFor(hdr *tar.Header, offset int64) IndexEntry {
+	return IndexEntry{
+		Name:     hdr.Name,
+		Typeflag: hdr.Typeflag,
+		Linkname: hdr.Linkname,
+		Size:     hdr.Size,
+		Mode:     hdr.Mode,
+		ModTime:  hdr.ModTime,
+		Offset:   offset,
+	}
+}
+
+// recordingWriter notes the headers written through it, so that Modify can
+// update the cached listing without reading the new archive back
+type recordingWriter struct {
+	entryWriter
+	entries []IndexEntry
+}
+
+func (r *recordingWriter) WriteHeader(hdr *tar.Header) error {
+	if !isMetaEntry(hdr.Name) {
+		r.entries = append(r.entries, indexEntryFor(hdr, -1))
+	}
+	return r.entryWriter.WriteHeader(hdr)
+}
+
+// ListSort orders the entries of a listing
+type ListSort string
+
+const (
+	SortByName    ListSort = "name"
+	SortBySize    ListSort = "size"
+	SortByModTime ListSort = "mtime"
+)
+
+// ListOptions filters, sorts and pages a listing
+type ListOptions struct {
+	Prefix     string   // Only entries whose name starts with Prefix
+	Pattern    string   // Only entries whose base name matches this path.Match pattern
+	SortBy     ListSort // SortByName when empty
+	Descending bool
+	Offset     int // Entries to skip after filtering and sorting; negative counts as zero
+	Limit      int // Maximum number of entries, zero for all
+}
+
+// ListPage is one page of a listing
+type ListPage struct {
+	Entries []FileEntry
+	// Total is the number of entries matching the filters across all pages
+	Total int
+}
+
+// ListFilesPage lists the archive entries matching opts, sorted and
+// paginated for display
+func (a *Archiver) ListFilesPage(opts ListOptions) (ListPage, error) {
+	var page ListPage
+
+	info, err := a.scanTarball()
+	if err != nil {
+		return page, err
+	}
+
+	info.mu.RLock()
+	entries := make([]FileEntry, 0, len(info.Files))
+	for name, entry := range info.Files {
+		if !strings.HasPrefix(name, opts.Prefix) {
+			continue
+		}
+		if opts.Pattern != "" {
+			matched, err := path.Match(opts.Pattern, path.Base(name))
+			if err != nil {
+				info.mu.RUnlock()
+				return page, err
+			}
+			if !matched {
+				continue
+			}
+		}
+		entries = append(entries, entry)
+	}
+	info.mu.RUnlock()
+
+	less := func(x, y FileEntry) bool {
+		switch opts.SortBy {
+		case SortBySize:
+			if x.Size != y.Size {
+				return x.Size < y.Size
+			}
+		case SortByModTime:
+			if !x.ModTime.Equal(y.ModTime) {
+				return x.ModTime.Before(y.ModTime)
+			}
+		}
+		return x.Header.Name < y.Header.Name
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if opts.Descending {
+			return less(entries[j], entries[i])
+		}
+		return less(entries[i], entries[j])
+	})
+
+	page.Total = len(entries)
+	if opts.Offset < 0 {
+		opts.Offset = 0
+	}
+	if opts.Offset > len(entries) {
+		opts.Offset = len(entries)
+	}
+	entries = entries[opts.Offset:]
+	if opts.Limit > 0 && opts.Limit < len(entries) {
+		entries = entries[:opts.Limit]
+	}
+	page.Entries = entries
+	return page, nil
+}
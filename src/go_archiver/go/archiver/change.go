@@ -0,0 +1,237 @@
+package archiver
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"time"
+)
+
+// ChangePolicy decides what Create does with a file that changes while it
+// is being read
+type ChangePolicy string
+
+const (
+	ChangeFail     ChangePolicy = "fail"     // Report an error for the file; the default
+	ChangeRetry    ChangePolicy = "retry"    // Read the file again until it holds still
+	ChangeSnapshot ChangePolicy = "snapshot" // Archive a private copy, whatever state it caught
+	ChangeMark     ChangePolicy = "mark"     // Archive what was read and mark the entry inconsistent in the stored manifest
+)
+
+// ErrFileChanged is returned for a file that changed while it was read
+var ErrFileChanged = errors.New("file changed while being archived")
+
+// DefaultChangeRetries is how often ChangeRetry rereads a file when
+// Config.ChangeRetries is zero
+const DefaultChangeRetries = 3
+
+// changeRetryDelay is the pause before the first reread; it doubles with
+// every further attempt
+var changeRetryDelay = 100 * time.Millisecond
+
+// FileChange reports a file that changed while Create read it
+type FileChange struct {
+	Path     string
+	Name     string       // Entry name in the archive
+	Policy   ChangePolicy // How the change was handled
+	Attempts int          // Reads of the file, including the first
+}
+
+// sourceState is what is compared to detect a change
+type sourceState struct {
+	size    int64
+	modTime time.Time
+}
+
+func stateOf(info fs.FileInfo) sourceState {
+	return sourceState{size: info.Size(), modTime: info.ModTime()}
+}
+
+// changePolicy returns the configured policy, ChangeFail when empty
+func (a *Archiver) changePolicy() (ChangePolicy, error) {
+	switch a.config.ChangedFiles {
+	case "":
+		return ChangeFail, nil
+	case ChangeFail, ChangeRetry, ChangeSnapshot, ChangeMark:
+		return a.config.ChangedFiles, nil
+	}
+	return "", fmt.Errorf("%w: changed files %s", ErrUnknownPolicy, a.config.ChangedFiles)
+}
+
+// sourceContent is a file opened for archiving. Content holds exactly Size
+// bytes unless the file shrank while it was streamed.
+type sourceContent struct {
+	content io.Reader
+	sample  io.ReaderAt
+	size    int64
+	before  sourceState
+	closers []io.Closer
+	spooled bool
+	change  *FileChange
+}
+
+func (s *sourceContent) Close() {
+	for _, c := range s.closers {
+		c.Close()
+	}
+}
+
+// openContent opens a file for archiving. Under ChangeRetry and
+// ChangeSnapshot the file is first copied to a spool file, so a change is
+// known before the entry is written; the other policies stream it.
+func (a *Archiver) openContent(info FileInfo, policy ChangePolicy) (*sourceContent, error) {
+	if policy != ChangeRetry && policy != ChangeSnapshot {
+		file, err := a.openSource(info)
+		if err != nil {
+			return nil, err
+		}
+		stat, err := file.Stat()
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		return &sourceContent{
+			content: file,
+			sample:  sampler(file),
+			size:    stat.Size(),
+			before:  stateOf(stat),
+			closers: []io.Closer{file},
+		}, nil
+	}
+
+	retries := a.config.ChangeRetries
+	if retries <= 0 {
+		retries = DefaultChangeRetries
+	}
+
+	spool, err := os.CreateTemp("", "archiver-spool-*")
+	if err != nil {
+		return nil, err
+	}
+	os.Remove(spool.Name())
+
+	for attempt := 1; ; attempt++ {
+		if attempt > 1 {
+			time.Sleep(changeRetryDelay << (attempt - 2))
+		}
+		if _, err := spool.Seek(0, io.SeekStart); err != nil {
+			spool.Close()
+			return nil, err
+		}
+		if err := spool.Truncate(0); err != nil {
+			spool.Close()
+			return nil, err
+		}
+
+		size, changed, err := a.copyStable(spool, info)
+		if err != nil {
+			spool.Close()
+			return nil, err
+		}
+		if _, err := spool.Seek(0, io.SeekStart); err != nil {
+			spool.Close()
+			return nil, err
+		}
+
+		s := &sourceContent{
+			content: spool,
+			sample:  spool,
+			size:    size,
+			closers: []io.Closer{spool},
+			spooled: true,
+		}
+		switch {
+		case changed && policy == ChangeSnapshot:
+			s.change = &FileChange{Path: info.Path, Name: entryName(info), Policy: ChangeSnapshot, Attempts: attempt}
+		case changed && attempt <= retries:
+			continue
+		case changed:
+			spool.Close()
+			return nil, fmt.Errorf("%w: %s after %d attempts", ErrFileChanged, info.Path, attempt)
+		case attempt > 1:
+			s.change = &FileChange{Path: info.Path, Name: entryName(info), Policy: ChangeRetry, Attempts: attempt}
+		}
+		return s, nil
+	}
+}
+
+// copyStable copies a file to w and reports whether it changed meanwhile
+func (a *Archiver) copyStable(w io.Writer, info FileInfo) (int64, bool, error) {
+	file, err := a.openSource(info)
+	if err != nil {
+		return 0, false, err
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return 0, false, err
+	}
+	n, err := io.Copy(w, file)
+	if err != nil {
+		return n, false, err
+	}
+	changed, err := a.sourceChanged(info, stateOf(stat), n)
+	return n, changed, err
+}
+
+// sourceChanged re-stats a file after it was read and compares it to the
+// state it was opened in and the number of bytes read
+func (a *Archiver) sourceChanged(info FileInfo, before sourceState, read int64) (bool, error) {
+	after, err := a.statSource(info)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return true, nil
+		}
+		return false, err
+	}
+	return read != before.size || stateOf(after) != before, nil
+}
+
+// copyContent writes exactly size bytes of r to w. A file that shrank or
+// failed to read is padded with zeros so the entry still matches its
+// header; the padding is reported through the returned count of bytes
+// actually read, and a read failure as readErr.
+func copyContent(w io.Writer, r io.Reader, size int64) (read int64, readErr, writeErr error) {
+	tracked := &readTracker{r: r}
+	read, err := io.CopyN(w, tracked, size)
+	if err != nil && err != io.EOF && tracked.err == nil {
+		return read, nil, err
+	}
+	if read < size {
+		if _, err := io.CopyN(w, zeroReader{}, size-read); err != nil {
+			return read, nil, err
+		}
+	}
+	if tracked.err != io.EOF {
+		readErr = tracked.err
+	}
+	return read, readErr, nil
+}
+
+// readTracker remembers the error of the reader it wraps, telling read
+// failures apart from write failures during a copy
+type readTracker struct {
+	r   io.Reader
+	err error
+}
+
+func (t *readTracker) Read(p []byte) (int, error) {
+	n, err := t.r.Read(p)
+	if err != nil && t.err == nil {
+		t.err = err
+	}
+	return n, err
+}
+
+// zeroReader produces an endless stream of zeros
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
@@ -0,0 +1,221 @@
+package archiver
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// changingFS serves live.txt with new content on every open. Until it has
+// been opened stableAfter times, a stat after reading reports a newer mtime,
+// as if the file was written to meanwhile.
+type changingFS struct {
+	fstest.MapFS
+	stableAfter int
+	opens       int
+}
+
+var changingBase = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func newChangingFS(stableAfter int) *changingFS {
+	return &changingFS{
+		MapFS:       fstest.MapFS{"live.txt": {Data: []byte("v0"), ModTime: changingBase}},
+		stableAfter: stableAfter,
+	}
+}
+
+func (c *changingFS) Open(name string) (fs.File, error) {
+	if name != "live.txt" {
+		return c.MapFS.Open(name)
+	}
+	c.opens++
+	version := fstest.MapFS{name: {
+		Data:    []byte(fmt.Sprintf("v%d", c.opens)),
+		ModTime: changingBase.Add(time.Duration(c.opens) * time.Second),
+	}}
+	return version.Open(name)
+}
+
+func (c *changingFS) Stat(name string) (fs.FileInfo, error) {
+	if name != "live.txt" {
+		return c.MapFS.Stat(name)
+	}
+	modTime := changingBase.Add(time.Duration(c.opens) * time.Second)
+	if c.opens < c.stableAfter {
+		modTime = modTime.Add(time.Second)
+	}
+	info := &fstest.MapFile{Data: []byte(fmt.Sprintf("v%d", c.opens)), ModTime: modTime}
+	return fstest.MapFS{name: info}.Stat(name)
+}
+
+func TestChangedFiles(t *testing.T) {
+	defer func(delay time.Duration) { changeRetryDelay = delay }(changeRetryDelay)
+	changeRetryDelay = 0
+
+	tests := []struct {
+		policy      ChangePolicy
+		stableAfter int
+		retries     int
+		content     string
+		change      *FileChange
+		err         error
+	}{
+		{policy: ChangeRetry, stableAfter: 3, content: "v3",
+			change: &FileChange{Path: "live.txt", Name: "live.txt", Policy: ChangeRetry, Attempts: 3}},
+		{policy: ChangeRetry, stableAfter: 10, retries: 2, err: ErrFileChanged},
+		{policy: ChangeSnapshot, stableAfter: 10, content: "v1",
+			change: &FileChange{Path: "live.txt", Name: "live.txt", Policy: ChangeSnapshot, Attempts: 1}},
+		{policy: ChangeMark, stableAfter: 10, content: "v1",
+			change: &FileChange{Path: "live.txt", Name: "live.txt", Policy: ChangeMark, Attempts: 1}},
+		{policy: "", stableAfter: 10, err: ErrFileChanged},
+		{policy: ChangeFail, stableAfter: 0, content: "v1"},
+	}
+
+	for _, tt := range tests {
+		name := fmt.Sprintf("%s/%d", tt.policy, tt.stableAfter)
+		a := New(Config{
+			SourceFS:      newChangingFS(tt.stableAfter),
+			OutputPath:    filepath.Join(t.TempDir(), "live.tar.gz"),
+			FilterMode:    FilterAll,
+			ChangedFiles:  tt.policy,
+			ChangeRetries: tt.retries,
+		})
+		scanResults, err := a.Scan()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var (
+			final   CreateResult
+			lastErr error
+		)
+		for result := range a.Create(a.Filter(scanResults)) {
+			if result.Error != nil {
+				lastErr = result.Error
+				continue
+			}
+			final = result
+		}
+		if tt.err != nil {
+			if !errors.Is(lastErr, tt.err) {
+				t.Errorf("%s: Expected %v, got %v", name, tt.err, lastErr)
+			}
+			continue
+		}
+		if lastErr != nil {
+			t.Fatalf("%s: %v", name, lastErr)
+		}
+
+		var wantChanges []FileChange
+		if tt.change != nil {
+			wantChanges = []FileChange{*tt.change}
+		}
+		if !reflect.DeepEqual(final.Changes, wantChanges) {
+			t.Errorf("%s: Expected changes %+v, got %+v", name, wantChanges, final.Changes)
+		}
+		if got := mergedContents(t, a); got["live.txt"] != tt.content {
+			t.Errorf("%s: Expected content %q, got %q", name, tt.content, got["live.txt"])
+		}
+	}
+}
+
+func TestVerifyChangedFiles(t *testing.T) {
+	signingKey, publicKey, err := GenerateSigningKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := New(Config{
+		SourceFS:     newChangingFS(10),
+		OutputPath:   filepath.Join(t.TempDir(), "live.tar.gz"),
+		FilterMode:   FilterAll,
+		ChangedFiles: ChangeMark,
+		SigningKey:   signingKey,
+		TrustedKeys:  []string{publicKey},
+	})
+	scanResults, err := a.Scan()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var final CreateResult
+	for result := range a.Create(a.Filter(scanResults)) {
+		final = result
+	}
+	if final.Error != nil || len(final.Changes) != 1 {
+		t.Fatalf("Expected one marked file, got %+v", final)
+	}
+
+	// The inconsistent mark does not break the signature
+	if err := a.Verify(); err != nil {
+		t.Errorf("Expected valid signature, got %v", err)
+	}
+}
+
+func TestStoredManifestMarks(t *testing.T) {
+	inconsistent := func(a *Archiver) map[string]bool {
+		t.Helper()
+		manifest, err := a.StoredManifest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		marks := make(map[string]bool)
+		for _, entry := range manifest.Entries {
+			marks[entry.Name] = entry.Inconsistent
+		}
+		return marks
+	}
+
+	signingKey, _, err := GenerateSigningKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, detached := range []bool{false, true} {
+		config := Config{
+			SourceFS:     newChangingFS(10),
+			OutputPath:   filepath.Join(t.TempDir(), "live.tar.gz"),
+			FilterMode:   FilterAll,
+			ChangedFiles: ChangeMark,
+		}
+		if detached {
+			config.SigningKey, config.DetachedSignature = signingKey, true
+		}
+		a := createArchive(t, config)
+		if marks := inconsistent(a); !marks["live.txt"] {
+			t.Errorf("detached %v: Expected live.txt marked inconsistent, got %v", detached, marks)
+		}
+	}
+
+	// Modify keeps the marks of copied entries, and Merge those of its inputs
+	input := createArchive(t, Config{
+		SourceFS:     newChangingFS(10),
+		OutputPath:   filepath.Join(t.TempDir(), "live.tar.gz"),
+		FilterMode:   FilterAll,
+		ChangedFiles: ChangeMark,
+	})
+	extra := filepath.Join(t.TempDir(), "extra.txt")
+	writeSourceFile(t, extra, "extra", changingBase)
+	modifier := New(Config{OutputPath: input.config.OutputPath, Modifiable: true})
+	for result := range modifier.Modify([]ModifyRequest{{Operation: OperationAdd, FileInfo: FileInfo{Path: extra}}}, CompressionDefault) {
+		if result.Error != nil {
+			t.Fatal(result.Error)
+		}
+	}
+	want := map[string]bool{"live.txt": true, "extra.txt": false}
+	if marks := inconsistent(modifier); !reflect.DeepEqual(marks, want) {
+		t.Errorf("Expected %v after Modify, got %v", want, marks)
+	}
+
+	merged := New(Config{OutputPath: filepath.Join(t.TempDir(), "merged.tar.gz")})
+	for result := range merged.Merge([]string{input.config.OutputPath}, ConflictFirst, false) {
+		if result.Error != nil {
+			t.Fatal(result.Error)
+		}
+	}
+	if marks := inconsistent(merged); !reflect.DeepEqual(marks, want) {
+		t.Errorf("Expected %v after Merge, got %v", want, marks)
+	}
+}
@@ -0,0 +1,218 @@
+package archiver
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+var (
+	// ErrNotResumable is returned when checkpointing is combined with a
+	// layout that cannot be cut between entries: anything but unencrypted,
+	// single-file gzip tar archives.
+	ErrNotResumable = errors.New("archive layout cannot be checkpointed")
+	// ErrCheckpointMismatch is returned by Resume for a checkpoint of
+	// another output, or an output shorter than its checkpoint
+	ErrCheckpointMismatch = errors.New("checkpoint does not match the output")
+)
+
+// DefaultCheckpointEvery is the number of bytes archived between
+// checkpoints when Config.CheckpointEvery is zero
+const DefaultCheckpointEvery = 256 << 20
+
+// Checkpoint is the state of an unfinished archive at a gzip member
+// boundary. Truncating the output to Offset leaves exactly Entries.
+type Checkpoint struct {
+	OutputPath string          `json:"output"`
+	Offset     int64           `json:"offset"`
+	Time       time.Time       `json:"time"`
+	Entries    []ManifestEntry `json:"entries"`
+	Index      []IndexEntry    `json:"index,omitempty"` // Of a seekable archive
+}
+
+// LoadCheckpoint reads a checkpoint file
+func LoadCheckpoint(path string) (*Checkpoint, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cp Checkpoint
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return nil, err
+	}
+	return &cp, nil
+}
+
+// Save writes the checkpoint file atomically
+func (c *Checkpoint) Save(path string) error {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// checkpointer ends the gzip member of the last complete entry and returns
+// the output offset after it, with the seekable index so far
+type checkpointer interface {
+	checkpoint() (int64, []IndexEntry, error)
+}
+
+func (w *indexWriter) checkpoint() (int64, []IndexEntry, error) {
+	if err := w.tw.Flush(); err != nil {
+		return 0, nil, err
+	}
+	offset, err := w.gz.cut()
+	if err != nil {
+		return 0, nil, err
+	}
+	return offset, append([]IndexEntry(nil), w.index.Entries...), nil
+}
+
+// memberWriter is a gzip tar writer whose members end at checkpoints only
+type memberWriter struct {
+	*tar.Writer
+	gz *seekableGzipWriter
+}
+
+func (w *memberWriter) checkpoint() (int64, []IndexEntry, error) {
+	if err := w.Flush(); err != nil {
+		return 0, nil, err
+	}
+	offset, err := w.gz.cut()
+	return offset, nil, err
+}
+
+// newCheckpointWriter layers a gzip tar writer that can be checkpointed over
+// w, which already holds offset bytes of the archive
+func (a *Archiver) newCheckpointWriter(w io.Writer, offset int64, index []IndexEntry) (*archiveWriter, error) {
+	layout := a.layout()
+	if layout.format != FormatTar || layout.codec != CodecGzip ||
+		len(a.config.Recipients) > 0 || a.config.VolumeSize > 0 {
+		return nil, ErrNotResumable
+	}
+
+	gz, err := newSeekableGzipWriter(w, a.compressionLevel())
+	if err != nil {
+		return nil, err
+	}
+	gz.w.n = offset
+
+	if layout.seekable {
+		iw := &indexWriter{tw: tar.NewWriter(gz), gz: gz, modTime: a.metaTime()}
+		iw.index.Entries = index
+		return &archiveWriter{entryWriter: iw, closers: []io.Closer{gz, iw}, checkpointer: iw}, nil
+	}
+	mw := &memberWriter{Writer: tar.NewWriter(gz), gz: gz}
+	return &archiveWriter{entryWriter: mw, closers: []io.Closer{gz.gz, mw.Writer}, checkpointer: mw}, nil
+}
+
+// openOutput creates the output of a new archive. When resuming, it instead
+// reopens the output truncated to its checkpoint and returns the checkpoint.
+func (a *Archiver) openOutput(resume bool) (io.WriteCloser, *archiveWriter, *Checkpoint, error) {
+	if !resume {
+		f, err := createOutput(a.config.OutputPath, a.config.VolumeSize)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		var aw *archiveWriter
+		if a.config.CheckpointPath != "" {
+			aw, err = a.newCheckpointWriter(f, 0, nil)
+		} else {
+			aw, err = a.newArchiveWriter(f, a.compressionLevel(), nil)
+		}
+		if err != nil {
+			f.Close()
+			return nil, nil, nil, err
+		}
+		return f, aw, nil, nil
+	}
+
+	if a.config.CheckpointPath == "" {
+		return nil, nil, nil, fmt.Errorf("%w: no checkpoint path", ErrNotResumable)
+	}
+	cp, err := LoadCheckpoint(a.config.CheckpointPath)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if !samePath(cp.OutputPath, a.config.OutputPath) {
+		return nil, nil, nil, fmt.Errorf("%w: checkpoint is for %s", ErrCheckpointMismatch, cp.OutputPath)
+	}
+
+	f, err := os.OpenFile(a.config.OutputPath, os.O_RDWR, 0)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, nil, err
+	}
+	if info.Size() < cp.Offset {
+		f.Close()
+		return nil, nil, nil, fmt.Errorf("%w: output is shorter than %d bytes", ErrCheckpointMismatch, cp.Offset)
+	}
+	if err := f.Truncate(cp.Offset); err != nil {
+		f.Close()
+		return nil, nil, nil, err
+	}
+	if _, err := f.Seek(cp.Offset, io.SeekStart); err != nil {
+		f.Close()
+		return nil, nil, nil, err
+	}
+	aw, err := a.newCheckpointWriter(f, cp.Offset, cp.Index)
+	if err != nil {
+		f.Close()
+		return nil, nil, nil, err
+	}
+	return f, aw, cp, nil
+}
+
+// saveCheckpoint cuts the archive after its last complete entry, syncs the
+// output and records entries as the contents up to the cut
+func (a *Archiver) saveCheckpoint(f io.Writer, aw *archiveWriter, entries []ManifestEntry) error {
+	offset, index, err := aw.checkpointer.checkpoint()
+	if err != nil {
+		return err
+	}
+	if s, ok := f.(interface{ Sync() error }); ok {
+		if err := s.Sync(); err != nil {
+			return err
+		}
+	}
+	cp := &Checkpoint{
+		OutputPath: a.config.OutputPath,
+		Offset:     offset,
+		Time:       time.Now().UTC(),
+		Entries:    append([]ManifestEntry(nil), entries...),
+		Index:      index,
+	}
+	return cp.Save(a.config.CheckpointPath)
+}
+
+// checkpointEvery returns the bytes archived between checkpoints
+func (a *Archiver) checkpointEvery() int64 {
+	if a.config.CheckpointEvery > 0 {
+		return a.config.CheckpointEvery
+	}
+	return DefaultCheckpointEvery
+}
+
+// samePath reports whether two paths name the same file
+func samePath(a, b string) bool {
+	absA, errA := filepath.Abs(a)
+	absB, errB := filepath.Abs(b)
+	if errA != nil || errB != nil {
+		return filepath.Clean(a) == filepath.Clean(b)
+	}
+	return absA == absB
+}
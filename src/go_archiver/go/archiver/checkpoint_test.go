@@ -0,0 +1,164 @@
+package archiver
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+)
+
+// interruptFS fails to open broken.txt while broken is set and counts the
+// opens of every file
+type interruptFS struct {
+	fstest.MapFS
+	broken bool
+	opens  map[string]int
+}
+
+func (f *interruptFS) Open(name string) (fs.File, error) {
+	if name == "broken.txt" && f.broken {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: errBroken}
+	}
+	f.opens[name]++
+	return f.MapFS.Open(name)
+}
+
+func runPipeline(t *testing.T, a *Archiver, resume bool) CreateResult {
+	t.Helper()
+
+	scanResults, err := a.Scan()
+	if err != nil {
+		t.Fatal(err)
+	}
+	run := a.Create
+	if resume {
+		run = a.Resume
+	}
+	var final CreateResult
+	for result := range run(a.Filter(scanResults)) {
+		if _, ok := result.Error.(*FileError); !ok {
+			final = result
+		}
+	}
+	return final
+}
+
+func TestCheckpointResume(t *testing.T) {
+	for _, seekable := range []bool{false, true} {
+		source := &interruptFS{MapFS: fstest.MapFS{}, broken: true, opens: make(map[string]int)}
+		want := make(map[string]string)
+		for _, name := range []string{"a.txt", "b.txt", "broken.txt", "c.txt", "d.txt"} {
+			source.MapFS[name] = &fstest.MapFile{Data: []byte("content of " + name)}
+			want[name] = "content of " + name
+		}
+		dir := t.TempDir()
+		config := Config{
+			SourceFS:        source,
+			OutputPath:      filepath.Join(dir, "out.tar.gz"),
+			FilterMode:      FilterAll,
+			Seekable:        seekable,
+			Reproducible:    true, // Archive in name order, so the run stops at broken.txt
+			CheckpointPath:  filepath.Join(dir, "out.checkpoint"),
+			CheckpointEvery: 1,
+		}
+
+		a := New(config)
+		if final := runPipeline(t, a, false); !errors.Is(final.Error, ErrAborted) {
+			t.Fatalf("seekable=%v: Expected an aborted run, got %v", seekable, final.Error)
+		}
+		cp, err := LoadCheckpoint(config.CheckpointPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(cp.Entries) != 2 || cp.Entries[1].Name != "b.txt" {
+			t.Errorf("seekable=%v: Expected a checkpoint after b.txt, got %+v", seekable, cp.Entries)
+		}
+
+		// Leftovers of the interrupted entry are cut off on resume
+		out, err := os.OpenFile(config.OutputPath, os.O_WRONLY|os.O_APPEND, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out.Write([]byte("partial member"))
+		out.Close()
+
+		source.broken = false
+		final := runPipeline(t, a, true)
+		if final.Error != nil {
+			t.Fatalf("seekable=%v: %v", seekable, final.Error)
+		}
+		if final.FilesResumed != 2 || final.FilesProcessed != 3 {
+			t.Errorf("seekable=%v: Expected 2 resumed and 3 processed files, got %d and %d",
+				seekable, final.FilesResumed, final.FilesProcessed)
+		}
+		if source.opens["a.txt"] != 1 {
+			t.Errorf("seekable=%v: Expected a.txt read once, got %d", seekable, source.opens["a.txt"])
+		}
+		if _, err := os.Stat(config.CheckpointPath); !os.IsNotExist(err) {
+			t.Errorf("seekable=%v: Expected the checkpoint removed, got %v", seekable, err)
+		}
+
+		got := mergedContents(t, a)
+		for name, content := range want {
+			if got[name] != content {
+				t.Errorf("seekable=%v: Expected %s to hold %q, got %q", seekable, name, content, got[name])
+			}
+		}
+		if len(got) != len(want) {
+			t.Errorf("seekable=%v: Expected %d entries, got %d", seekable, len(want), len(got))
+		}
+		if !seekable {
+			continue
+		}
+
+		// Index offsets written before the checkpoint still address their members
+		idx, err := readIndex(config.OutputPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(idx.Entries) != len(want) {
+			t.Errorf("Expected %d indexed entries, got %d", len(want), len(idx.Entries))
+		}
+		for name, content := range want {
+			rc, _, err := a.OpenEntry(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err := io.ReadAll(rc)
+			rc.Close()
+			if err != nil || string(data) != content {
+				t.Errorf("Expected indexed %s to hold %q, got %q (%v)", name, content, data, err)
+			}
+		}
+	}
+}
+
+func TestResumeErrors(t *testing.T) {
+	dir := t.TempDir()
+	source := fstest.MapFS{"a.txt": {Data: []byte("alpha")}}
+
+	a := New(Config{SourceFS: source, OutputPath: filepath.Join(dir, "out.tar.gz"), FilterMode: FilterAll,
+		CheckpointPath: filepath.Join(dir, "missing.checkpoint")})
+	if final := runPipeline(t, a, true); !errors.Is(final.Error, fs.ErrNotExist) {
+		t.Errorf("Expected a missing checkpoint to be reported, got %v", final.Error)
+	}
+
+	a = New(Config{SourceFS: source, OutputPath: filepath.Join(dir, "out.zip"), FilterMode: FilterAll,
+		CheckpointPath: filepath.Join(dir, "zip.checkpoint")})
+	if final := runPipeline(t, a, false); !errors.Is(final.Error, ErrNotResumable) {
+		t.Errorf("Expected ErrNotResumable, got %v", final.Error)
+	}
+
+	cp := &Checkpoint{OutputPath: filepath.Join(dir, "other.tar.gz")}
+	if err := cp.Save(filepath.Join(dir, "other.checkpoint")); err != nil {
+		t.Fatal(err)
+	}
+	a = New(Config{SourceFS: source, OutputPath: filepath.Join(dir, "out.tar.gz"), FilterMode: FilterAll,
+		CheckpointPath: filepath.Join(dir, "other.checkpoint")})
+	if final := runPipeline(t, a, true); !errors.Is(final.Error, ErrCheckpointMismatch) {
+		t.Errorf("Expected ErrCheckpointMismatch, got %v", final.Error)
+	}
+}
@@ -0,0 +1,310 @@
+package archiver
+
+import (
+	"bytes"
+	"compress/bzip2"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"io"
+	"runtime"
+	"sync"
+)
+
+// ErrReadOnlyCodec is returned when writing with a codec that can only decode
+var ErrReadOnlyCodec = errors.New("codec does not support writing")
+
+// Codec names a compression codec for tar archives
+type Codec string
+
+const (
+	CodecNone    Codec = "none"
+	CodecGzip    Codec = "gzip"
+	CodecZlib    Codec = "zlib"
+	CodecDeflate Codec = "deflate" // Raw deflate without framing
+	CodecPgzip   Codec = "pgzip"   // Gzip compressed in parallel blocks
+	CodecBzip2   Codec = "bzip2"   // Read only
+)
+
+// Compressor wraps a stream in a compression codec
+type Compressor interface {
+	// Codec returns the name of the codec
+	Codec() Codec
+	// Detect reports whether a stream starting with header uses the codec
+	Detect(header []byte) bool
+	// NewWriter returns a compressing writer. Close flushes the stream but
+	// does not close w.
+	NewWriter(w io.Writer, level CompressionLevel) (io.WriteCloser, error)
+	// NewReader returns a decompressing reader
+	NewReader(r io.Reader) (io.ReadCloser, error)
+}
+
+var (
+	compressorsMu sync.RWMutex
+	compressors   = map[Codec]Compressor{}
+	// detectOrder is the order in which compressors are tried on read
+	detectOrder []Codec
+)
+
+func init() {
+	for _, c := range []Compressor{
+		noneCompressor{},
+		gzipCompressor{},
+		zlibCompressor{},
+		bzip2Compressor{},
+		deflateCompressor{},
+		pgzipCompressor{},
+	} {
+		RegisterCompressor(c)
+	}
+}
+
+// RegisterCompressor makes a codec available for writing and, if it can
+// detect its own streams, for reading. Registering a codec name again
+// replaces the previous compressor.
+func RegisterCompressor(c Compressor) {
+	compressorsMu.Lock()
+	defer compressorsMu.Unlock()
+
+	if _, exists := compressors[c.Codec()]; !exists {
+		detectOrder = append(detectOrder, c.Codec())
+	}
+	compressors[c.Codec()] = c
+}
+
+// compressorFor returns the compressor registered for codec
+func compressorFor(codec Codec) (Compressor, error) {
+	compressorsMu.RLock()
+	defer compressorsMu.RUnlock()
+
+	c, ok := compressors[codec]
+	if !ok {
+		return nil, errors.New("unknown codec: " + string(codec))
+	}
+	return c, nil
+}
+
+// detectCompressor returns the compressor recognising header, falling back
+// to raw deflate which has no magic bytes of its own
+func detectCompressor(header []byte) Compressor {
+	compressorsMu.RLock()
+	defer compressorsMu.RUnlock()
+
+	for _, codec := range detectOrder {
+		if c := compressors[codec]; c.Detect(header) {
+			return c
+		}
+	}
+	return compressors[CodecDeflate]
+}
+
+// compressionLevel returns the configured level; CompressionUnset selects
+// CompressionDefault
+func (a *Archiver) compressionLevel() CompressionLevel {
+	if a.config.CompressionLevel == CompressionUnset {
+		return CompressionDefault
+	}
+	return a.config.CompressionLevel
+}
+
+// nopWriteCloser adds a no-op Close to a writer
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+type noneCompressor struct{}
+
+func (noneCompressor) Codec() Codec { return CodecNone }
+
+// Detect is always false; uncompressed tar is recognised by its header
+func (noneCompressor) Detect([]byte) bool { return false }
+
+func (noneCompressor) NewWriter(w io.Writer, _ CompressionLevel) (io.WriteCloser, error) {
+	return nopWriteCloser{w}, nil
+}
+
+func (noneCompressor) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return io.NopCloser(r), nil
+}
+
+type gzipCompressor struct{}
+
+func (gzipCompressor) Codec() Codec { return CodecGzip }
+
+func (gzipCompressor) Detect(header []byte) bool {
+	return bytes.HasPrefix(header, gzipMagic)
+}
+
+func (gzipCompressor) NewWriter(w io.Writer, level CompressionLevel) (io.WriteCloser, error) {
+	return gzip.NewWriterLevel(w, level.flateLevel())
+}
+
+func (gzipCompressor) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return gzip.NewReader(r)
+}
+
+type zlibCompressor struct{}
+
+func (zlibCompressor) Codec() Codec { return CodecZlib }
+
+// Detect checks the RFC 1950 header: deflate method, a valid window size and
+// the header checksum
+func (zlibCompressor) Detect(header []byte) bool {
+	if len(header) < 2 {
+		return false
+	}
+	cmf, flg := header[0], header[1]
+	return cmf&0x0f == 8 && cmf>>4 <= 7 && (uint16(cmf)<<8|uint16(flg))%31 == 0
+}
+
+func (zlibCompressor) NewWriter(w io.Writer, level CompressionLevel) (io.WriteCloser, error) {
+	return zlib.NewWriterLevel(w, level.flateLevel())
+}
+
+func (zlibCompressor) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return zlib.NewReader(r)
+}
+
+type deflateCompressor struct{}
+
+func (deflateCompressor) Codec() Codec { return CodecDeflate }
+
+// Detect is always false; raw deflate is the fallback when nothing matches
+func (deflateCompressor) Detect([]byte) bool { return false }
+
+func (deflateCompressor) NewWriter(w io.Writer, level CompressionLevel) (io.WriteCloser, error) {
+	return flate.NewWriter(w, level.flateLevel())
+}
+
+func (deflateCompressor) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return flate.NewReader(r), nil
+}
+
+type bzip2Compressor struct{}
+
+func (bzip2Compressor) Codec() Codec { return CodecBzip2 }
+
+func (bzip2Compressor) Detect(header []byte) bool {
+	return len(header) >= 4 && string(header[:3]) == "BZh" && header[3] >= '1' && header[3] <= '9'
+}
+
+func (bzip2Compressor) NewWriter(io.Writer, CompressionLevel) (io.WriteCloser, error) {
+	return nil, ErrReadOnlyCodec
+}
+
+func (bzip2Compressor) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return io.NopCloser(bzip2.NewReader(r)), nil
+}
+
+type pgzipCompressor struct{}
+
+func (pgzipCompressor) Codec() Codec { return CodecPgzip }
+
+// Detect is always false; the output is plain multi-member gzip and is read
+// by the gzip codec
+func (pgzipCompressor) Detect([]byte) bool { return false }
+
+func (pgzipCompressor) NewWriter(w io.Writer, level CompressionLevel) (io.WriteCloser, error) {
+	return newParallelGzipWriter(w, level)
+}
+
+func (pgzipCompressor) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return gzip.NewReader(r)
+}
+
+const pgzipBlockSize = 1 << 20
+
+type pgzipBlock struct {
+	data []byte
+	err  error
+}
+
+// parallelGzipWriter compresses fixed-size blocks concurrently, each as its
+// own gzip member, and writes the members in order. Errors from the
+// underlying writer are reported by Close.
+type parallelGzipWriter struct {
+	w       io.Writer
+	level   int
+	buf     []byte
+	started bool
+	queue   chan chan pgzipBlock
+	done    chan error
+}
+
+func newParallelGzipWriter(w io.Writer, level CompressionLevel) (*parallelGzipWriter, error) {
+	// Validate the level up front rather than in every worker
+	if _, err := gzip.NewWriterLevel(io.Discard, level.flateLevel()); err != nil {
+		return nil, err
+	}
+
+	p := &parallelGzipWriter{
+		w:     w,
+		level: level.flateLevel(),
+		buf:   make([]byte, 0, pgzipBlockSize),
+		queue: make(chan chan pgzipBlock, runtime.GOMAXPROCS(0)),
+		done:  make(chan error, 1),
+	}
+	go p.drain()
+	return p, nil
+}
+
+// drain writes compressed members in submission order
+func (p *parallelGzipWriter) drain() {
+	var err error
+	for result := range p.queue {
+		block := <-result
+		if err != nil {
+			continue
+		}
+		err = block.err
+		if err == nil {
+			_, err = p.w.Write(block.data)
+		}
+	}
+	p.done <- err
+}
+
+// submit compresses a block in the background. The bounded queue applies
+// back pressure once every worker is busy.
+func (p *parallelGzipWriter) submit(data []byte) {
+	p.started = true
+	result := make(chan pgzipBlock, 1)
+	p.queue <- result
+
+	go func() {
+		var buf bytes.Buffer
+		gw, _ := gzip.NewWriterLevel(&buf, p.level)
+		_, err := gw.Write(data)
+		if err == nil {
+			err = gw.Close()
+		}
+		result <- pgzipBlock{data: buf.Bytes(), err: err}
+	}()
+}
+
+func (p *parallelGzipWriter) Write(b []byte) (int, error) {
+	written := len(b)
+	for len(b) > 0 {
+		n := copy(p.buf[len(p.buf):cap(p.buf)], b)
+		p.buf = p.buf[:len(p.buf)+n]
+		b = b[n:]
+		if len(p.buf) == cap(p.buf) {
+			p.submit(p.buf)
+			p.buf = make([]byte, 0, pgzipBlockSize)
+		}
+	}
+	return written, nil
+}
+
+// Close compresses the remaining data and waits for every member to be
+// written. It does not close the underlying writer.
+func (p *parallelGzipWriter) Close() error {
+	if len(p.buf) > 0 || !p.started {
+		p.submit(p.buf)
+	}
+	close(p.queue)
+	return <-p.done
+}
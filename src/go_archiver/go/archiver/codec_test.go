@@ -0,0 +1,192 @@
+package archiver
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCodecs(t *testing.T) {
+	for _, codec := range []Codec{CodecNone, CodecGzip, CodecZlib, CodecDeflate, CodecPgzip} {
+		t.Run(string(codec), func(t *testing.T) {
+			outputPath := filepath.Join(t.TempDir(), "out.tar")
+			a := createArchive(t, Config{
+				SourcePath:       "testdata/source",
+				OutputPath:       outputPath,
+				Recursive:        true,
+				FilterMode:       FilterAll,
+				Modifiable:       true,
+				Codec:            codec,
+				CompressionLevel: CompressionBest,
+			})
+
+			tr, err := a.openArchive(outputPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tr.Close()
+			// pgzip output is plain multi-member gzip
+			want := codec
+			if codec == CodecPgzip {
+				want = CodecGzip
+			}
+			if tr.layout.codec != want {
+				t.Errorf("Expected codec %s to be detected, got %s", want, tr.layout.codec)
+			}
+
+			files, err := a.ListFiles()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(files) != 7 {
+				t.Errorf("Expected 7 files, got %v", files)
+			}
+
+			for result := range a.Modify([]ModifyRequest{{Operation: OperationRemove, Path: "doc1.txt"}}, CompressionDefault) {
+				if result.Error != nil {
+					t.Fatal(result.Error)
+				}
+			}
+			if files, err = a.ListFiles(); err != nil || len(files) != 6 {
+				t.Errorf("Expected 6 files after removal, got %v (%v)", files, err)
+			}
+		})
+	}
+}
+
+func TestParallelGzipLargeFile(t *testing.T) {
+	sourceDir := t.TempDir()
+	data := bytes.Repeat([]byte("parallel gzip block "), 3*pgzipBlockSize/20+7)
+	if err := os.WriteFile(filepath.Join(sourceDir, "big.txt"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	a := createArchive(t, Config{
+		SourcePath: sourceDir,
+		OutputPath: filepath.Join(t.TempDir(), "big.tar.gz"),
+		FilterMode: FilterAll,
+		Codec:      CodecPgzip,
+	})
+
+	dest := t.TempDir()
+	for result := range a.Extract(dest) {
+		if result.Error != nil {
+			t.Fatal(result.Error)
+		}
+	}
+	got, err := os.ReadFile(filepath.Join(dest, "big.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sha256.Sum256(got) != sha256.Sum256(data) {
+		t.Error("Expected extracted content to match the source")
+	}
+}
+
+func TestReadBzip2Archive(t *testing.T) {
+	outputPath := filepath.Join(t.TempDir(), "documents.tar.bz2")
+	fixture, err := os.ReadFile("testdata/archives/documents.tar.bz2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(outputPath, fixture, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	a := New(Config{OutputPath: outputPath, Modifiable: true})
+	entry, err := a.GetFileInfo("doc1.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.Size != int64(len("This is a test document")) {
+		t.Errorf("Unexpected size %d", entry.Size)
+	}
+
+	for result := range a.Modify([]ModifyRequest{{Operation: OperationRemove, Path: "doc2.pdf"}}, CompressionDefault) {
+		if result.Error != nil {
+			t.Fatal(result.Error)
+		}
+	}
+	tr, err := a.openArchive(outputPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr.Close()
+	if tr.layout.codec != CodecGzip {
+		t.Errorf("Expected bzip2 archive to be rewritten as gzip, got %s", tr.layout.codec)
+	}
+	if files, err := a.ListFiles(); err != nil || len(files) != 1 {
+		t.Errorf("Expected 1 file after removal, got %v (%v)", files, err)
+	}
+}
+
+func TestCompressionNone(t *testing.T) {
+	source := t.TempDir()
+	content := strings.Repeat("stored ", 512)
+	writeSourceFile(t, filepath.Join(source, "a.txt"), content, time.Now())
+	extra := filepath.Join(t.TempDir(), "b.txt")
+	writeSourceFile(t, extra, content, time.Now())
+
+	stored := func(path string) bool {
+		t.Helper()
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return bytes.Contains(data, []byte(content))
+	}
+	create := func(name string, level CompressionLevel) *Archiver {
+		t.Helper()
+		return createArchive(t, Config{
+			SourcePath:       source,
+			OutputPath:       filepath.Join(t.TempDir(), name),
+			FilterMode:       FilterAll,
+			Modifiable:       true,
+			CompressionLevel: level,
+		})
+	}
+	modify := func(a *Archiver, level CompressionLevel) {
+		t.Helper()
+		for result := range a.Modify([]ModifyRequest{{Operation: OperationAdd, FileInfo: FileInfo{Path: extra}}}, level) {
+			if result.Error != nil {
+				t.Fatal(result.Error)
+			}
+		}
+	}
+
+	unset := create("unset.tar.gz", CompressionUnset)
+	if stored(unset.config.OutputPath) {
+		t.Error("Expected an unset level to compress")
+	}
+	modify(unset, CompressionNone)
+	if !stored(unset.config.OutputPath) {
+		t.Error("Expected Modify with CompressionNone to store entries")
+	}
+
+	// An unset level in Modify keeps the configured one
+	none := create("none.tar.gz", CompressionNone)
+	if !stored(none.config.OutputPath) {
+		t.Error("Expected Create with CompressionNone to store entries")
+	}
+	modify(none, CompressionUnset)
+	if !stored(none.config.OutputPath) {
+		t.Error("Expected Modify to keep CompressionNone")
+	}
+
+	zipArchive := create("none.zip", CompressionNone)
+	zr, err := zip.OpenReader(zipArchive.config.OutputPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zr.Close()
+	for _, f := range zr.File {
+		if f.Method != zip.Store {
+			t.Errorf("Expected %s to be stored, got method %d", f.Name, f.Method)
+		}
+	}
+}
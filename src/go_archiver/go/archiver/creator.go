@@ -2,7 +2,10 @@ package archiver
 
 import (
 	"archive/tar"
-	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
 	"io"
 	"os"
 	"path/filepath"
@@ -15,110 +18,369 @@ import (
 type CreateResult struct {
 	FilesProcessed int64
 	TotalSize     int64
+	FilesSkipped  int64 // Unchanged since the base snapshot of a backup
+	FilesDeleted  int64 // Tombstones recorded for a backup
+	FilesResumed  int64 // Already archived at the checkpoint of a resumed run
+	Changes       []FileChange // Files that changed while being read
+	Errors        []*FileError // Every failed file, on the final result
 	Error         error
 }
 
-// Create generates a tarball from the filtered files
+// Create generates a tarball from the filtered files. Each failed file is
+// reported as it happens with a *FileError; the error policy decides
+// whether the archive is still finished. A run that stops early ends with
+// an ErrAborted result and leaves no complete archive, but with
+// Config.CheckpointPath set it can be continued by Resume.
 func (a *Archiver) Create(in <-chan FilterResult) <-chan CreateResult {
+	return a.create(in, false)
+}
+
+// Resume continues an archive whose Create stopped, from the checkpoint at
+// Config.CheckpointPath. The output is truncated to the checkpoint and the
+// files archived before it are skipped, so in should carry the same scan.
+func (a *Archiver) Resume(in <-chan FilterResult) <-chan CreateResult {
+	return a.create(in, true)
+}
+
+func (a *Archiver) create(in <-chan FilterResult, resume bool) <-chan CreateResult {
 	out := make(chan CreateResult)
 
 	go func() {
 		defer close(out)
 
-		// Create the output file
-		f, err := os.Create(a.config.OutputPath)
+		// Load the base snapshot for incremental and differential backups
+		backup, err := a.startBackup()
 		if err != nil {
 			out <- CreateResult{Error: err}
 			return
 		}
-		defer f.Close()
 
-		// Create gzip writer
-		gw := gzip.NewWriter(f)
-		defer gw.Close()
+		tracker, err := a.newErrorTracker()
+		if err != nil {
+			out <- CreateResult{Error: err}
+			return
+		}
+		if _, err := a.changePolicy(); err != nil {
+			out <- CreateResult{Error: err}
+			return
+		}
 
-		// Create tar writer
-		tw := tar.NewWriter(gw)
-		defer tw.Close()
+		// Create the output file or volume set with the compressed (and
+		// optionally encrypted) tar writer, or reopen it at the checkpoint
+		f, aw, checkpoint, err := a.openOutput(resume)
+		if err != nil {
+			out <- CreateResult{Error: err}
+			return
+		}
+		defer f.Close()
+		tw := aw.entryWriter
 
 		var (
 			filesProcessed int64
 			totalSize     int64
+			filesSkipped  int64
 			wg           sync.WaitGroup
-			errChan      = make(chan error, 1)
 			semaphore    = make(chan struct{}, 5) // Limit concurrent file processing
+			twMu         sync.Mutex // tar entries must be written one at a time
+			manifest     Manifest
+			changes      []FileChange
+			filesResumed int64
+			resumed      = make(map[string]ManifestEntry)
+			// archived counts the bytes since the last checkpoint
+			archived int64
 		)
 
-		// Process files concurrently
-		for result := range in {
-			if result.Error != nil {
-				out <- CreateResult{Error: result.Error}
-				continue
+		if checkpoint != nil {
+			manifest.Entries = checkpoint.Entries
+			for _, entry := range checkpoint.Entries {
+				resumed[entry.Name] = entry
+			}
+		} else if aw.checkpointer != nil {
+			// Resume always has a checkpoint to start from
+			if err := a.saveCheckpoint(f, aw, nil); err != nil {
+				out <- CreateResult{Error: err}
+				return
 			}
+		}
 
-			wg.Add(1)
-			go func(res FilterResult) {
-				defer wg.Done()
-				semaphore <- struct{}{} // Acquire
-				defer func() { <-semaphore }() // Release
-
-				// Open and process the file
-				if err := a.addFileToTar(tw, res.FileInfo); err != nil {
-					select {
-					case errChan <- err:
-					default:
+		// fail reports a failed file and applies the error policy
+		fail := func(fe *FileError) {
+			tracker.add(fe)
+			out <- CreateResult{Error: fe}
+		}
+
+		// process adds a single file to the archive
+		process := func(res FilterResult) {
+			defer wg.Done()
+			semaphore <- struct{}{} // Acquire
+			defer func() { <-semaphore }() // Release
+
+			// Open and process the file
+			twMu.Lock()
+			if tracker.stopped() != nil {
+				twMu.Unlock()
+				return
+			}
+			entry, change, err := a.addFileToTar(tw, res.FileInfo)
+			// A file that failed while being read still has its entry
+			if entry.Name != "" {
+				manifest.Entries = append(manifest.Entries, entry)
+			}
+			if err == nil && backup != nil {
+				backup.archived(entry)
+			}
+			if change != nil {
+				changes = append(changes, *change)
+			}
+			if entry.Name != "" && aw.checkpointer != nil {
+				archived += entry.Size
+				if archived >= a.checkpointEvery() {
+					archived = 0
+					if cpErr := a.saveCheckpoint(f, aw, manifest.Entries); cpErr != nil && err == nil {
+						err = fileError(cpErr, a.config.CheckpointPath, StageWrite)
 					}
-					return
 				}
+			}
+			twMu.Unlock()
+			if err != nil {
+				fail(fileError(err, res.FileInfo.Path, StageOpen))
+				return
+			}
 
-				atomic.AddInt64(&filesProcessed, 1)
-				atomic.AddInt64(&totalSize, res.FileInfo.Size)
-			}(result)
+			atomic.AddInt64(&filesProcessed, 1)
+			atomic.AddInt64(&totalSize, res.FileInfo.Size)
+		}
+
+		// Process files concurrently, unless the entry order matters
+		ordered := a.orderedOutput()
+		for result := range a.orderEntries(in) {
+			// Keep draining the input so the stages before Create finish
+			if tracker.stopped() != nil {
+				continue
+			}
+			if result.Error != nil {
+				fail(fileError(result.Error, "", StageScan))
+				continue
+			}
+			if backup != nil && !backup.changed(entryName(result.FileInfo), result.FileInfo) {
+				filesSkipped++
+				continue
+			}
+			if entry, ok := resumed[entryName(result.FileInfo)]; ok {
+				if backup != nil {
+					backup.archived(entry)
+				}
+				filesResumed++
+				continue
+			}
+
+			wg.Add(1)
+			if ordered {
+				process(result)
+				continue
+			}
+			go process(result)
 		}
 
 		// Wait for all files to be processed
 		wg.Wait()
 
-		// Check for any errors
-		select {
-		case err := <-errChan:
+		// A stopped run leaves the archive unfinished
+		if err := tracker.stopped(); err != nil {
+			out <- CreateResult{Error: err, Errors: tracker.list()}
+			return
+		}
+		var info *BackupInfo
+		if backup != nil {
+			finished := backup.finish()
+			info = &finished
+		}
+		if err := a.finishArchive(f, aw, manifest, info); err != nil {
 			out <- CreateResult{Error: err}
 			return
-		default:
-			out <- CreateResult{
-				FilesProcessed: filesProcessed,
-				TotalSize:     totalSize,
+		}
+		// Only a complete archive may advance the backup chain
+		if backup != nil {
+			if err := backup.save(a.config.SnapshotPath); err != nil {
+				out <- CreateResult{Error: err}
+				return
 			}
 		}
+		if aw.checkpointer != nil {
+			os.Remove(a.config.CheckpointPath)
+		}
+
+		result := CreateResult{
+			FilesProcessed: filesProcessed,
+			TotalSize:     totalSize,
+			FilesSkipped:  filesSkipped,
+			FilesResumed:  filesResumed,
+			Changes:       changes,
+			Errors:        tracker.list(),
+		}
+		if info != nil {
+			result.FilesDeleted = int64(len(info.Tombstones))
+		}
+		out <- result
 	}()
 
 	return out
 }
 
-// addFileToTar adds a single file to the tar archive
-func (a *Archiver) addFileToTar(tw *tar.Writer, info FileInfo) error {
-	file, err := os.Open(info.Path)
-	if err != nil {
+// finishArchive writes the backup metadata and the manifest, signs the
+// archive if a signing key is configured and flushes every layer to disk
+func (a *Archiver) finishArchive(f io.Closer, aw *archiveWriter, manifest Manifest, backup *BackupInfo) error {
+	if backup != nil {
+		// Restore trusts the tombstones in it, so the signature covers it
+		entry, err := writeMetaEntry(aw, backupEntry, backup, a.metaTime())
+		if err != nil {
+			return err
+		}
+		manifest.Entries = append(manifest.Entries[:len(manifest.Entries):len(manifest.Entries)], entry)
+	}
+
+	// The stored manifest keeps the inconsistent marks whether or not the
+	// archive is signed. It is not covered by the signature itself.
+	stored := Manifest{Entries: append([]ManifestEntry(nil), manifest.Entries...)}
+	stored.sort()
+	if _, err := writeMetaEntry(aw, manifestMetaEntry, stored, a.metaTime()); err != nil {
 		return err
 	}
-	defer file.Close()
 
-	// Create tar header
+	if a.config.SigningKey != "" && !a.config.DetachedSignature {
+		sig, err := a.newSignature(manifest, "")
+		if err != nil {
+			return err
+		}
+		if _, err := writeMetaEntry(aw, signatureEntry, sig, a.metaTime()); err != nil {
+			return err
+		}
+	}
+
+	// The encryption layer only seals its final chunk on close
+	if err := aw.Close(); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	if a.config.SigningKey != "" && a.config.DetachedSignature {
+		return a.writeDetachedSignature(manifest)
+	}
+	return nil
+}
+
+// writeMetaEntry stores v as a JSON bookkeeping entry and returns its
+// manifest entry
+func writeMetaEntry(w entryWriter, name string, v interface{}, modTime time.Time) (ManifestEntry, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return ManifestEntry{}, err
+	}
 	header := &tar.Header{
-		Name:    filepath.Base(info.Path),
-		Size:    info.Size,
+		Name:    name,
+		Size:    int64(len(data)),
 		Mode:    0644,
-		ModTime: time.Now(),
+		ModTime: modTime,
+	}
+	if err := w.WriteHeader(header); err != nil {
+		return ManifestEntry{}, err
+	}
+	if _, err := w.Write(data); err != nil {
+		return ManifestEntry{}, err
 	}
+	sum := sha256.Sum256(data)
+	return manifestEntry(header, hex.EncodeToString(sum[:])), nil
+}
 
-	if err := tw.WriteHeader(header); err != nil {
-		return err
+// entryName returns the name a file is stored under in the archive
+func entryName(info FileInfo) string {
+	if info.Name != "" {
+		return info.Name
 	}
+	return filepath.Base(info.Path)
+}
 
-	// Copy file content to tar
-	if _, err := io.Copy(tw, file); err != nil {
+// fileMode returns the permission bits a file is stored with, 0644 when the
+// file info carries none
+func fileMode(info FileInfo) int64 {
+	if perm := info.Mode.Perm(); perm != 0 {
+		return int64(perm)
+	}
+	return 0644
+}
+
+// addFileToTar adds a single file to the tar archive and returns its
+// manifest entry, and how a change while reading it was handled. Errors are
+// FileErrors; after a StageRead error the entry is still in the archive and
+// is returned marked inconsistent.
+func (a *Archiver) addFileToTar(tw entryWriter, info FileInfo) (ManifestEntry, *FileChange, error) {
+	var entry ManifestEntry
+
+	// Links and special files are stored without content
+	if header := specialHeader(info); header != nil {
+		if err := a.writeEntryHeader(tw, header, nil); err != nil {
+			return entry, nil, fileError(err, info.Path, StageWrite)
+		}
+		return manifestEntry(header, emptySHA256), nil, nil
+	}
+
+	policy, err := a.changePolicy()
+	if err != nil {
+		return entry, nil, fileError(err, info.Path, StageOpen)
+	}
+	var source *sourceContent
+	err = a.withRetry(func() error {
+		source, err = a.openContent(info, policy)
 		return err
+	})
+	if err != nil {
+		return entry, nil, fileError(err, info.Path, StageOpen)
 	}
+	defer source.Close()
 
-	return nil
+	// Create tar header from the size the file was opened with, so that a
+	// file growing meanwhile cannot overrun its entry
+	header := &tar.Header{
+		Name:    entryName(info),
+		Size:    source.size,
+		Mode:    fileMode(info),
+		ModTime: info.ModTime,
+	}
+
+	if err := a.writeEntryHeader(tw, header, source.sample); err != nil {
+		return entry, nil, fileError(err, info.Path, StageWrite)
+	}
+
+	// Copy file content to tar, hashing it for the manifest
+	h := sha256.New()
+	read, readErr, err := copyContent(io.MultiWriter(tw, h), source.content, header.Size)
+	if err != nil {
+		return entry, nil, fileError(err, info.Path, StageWrite)
+	}
+
+	entry = manifestEntry(header, hex.EncodeToString(h.Sum(nil)))
+	if readErr != nil {
+		entry.Inconsistent = true
+		return entry, nil, fileError(readErr, info.Path, StageRead)
+	}
+	if source.spooled {
+		return entry, source.change, nil
+	}
+
+	// Streamed files are checked once they have been read
+	changed, err := a.sourceChanged(info, source.before, read)
+	if err != nil {
+		return entry, nil, fileError(err, info.Path, StageRead)
+	}
+	if !changed {
+		return entry, nil, nil
+	}
+	if policy != ChangeMark {
+		entry.Inconsistent = true
+		return entry, nil, fileError(fmt.Errorf("%w: %s", ErrFileChanged, info.Path), info.Path, StageRead)
+	}
+	entry.Inconsistent = true
+	return entry, &FileChange{Path: info.Path, Name: header.Name, Policy: ChangeMark, Attempts: 1}, nil
 }
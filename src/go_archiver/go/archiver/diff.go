@@ -0,0 +1,340 @@
+package archiver
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DiffKind classifies a difference between two sets of entries
+type DiffKind string
+
+const (
+	DiffAdded    DiffKind = "added"
+	DiffRemoved  DiffKind = "removed"
+	DiffModified DiffKind = "modified"
+	DiffRenamed  DiffKind = "renamed" // Same content under a new name
+)
+
+// DiffEntry describes how a single entry differs
+type DiffEntry struct {
+	Kind DiffKind `json:"kind"`
+	Path string   `json:"path"`
+	// OldPath is the previous name of a renamed entry
+	OldPath string `json:"old_path,omitempty"`
+	// Changes lists the attributes of a modified entry that differ: "size",
+	// "mtime", "hash", "mode" or "linkname"
+	Changes []string `json:"changes,omitempty"`
+}
+
+// DiffReport lists the differences between an archive and another archive
+// or a directory, sorted by path
+type DiffReport struct {
+	Old     string      `json:"old"`
+	New     string      `json:"new"`
+	Entries []DiffEntry `json:"entries"`
+}
+
+// entryState is the part of an entry that Diff compares
+type entryState struct {
+	size     int64
+	modTime  time.Time
+	mode     os.FileMode
+	sha256   string
+	regular  bool   // a file with content, which may be paired as a rename
+	linkname string // target of a symbolic link
+}
+
+// Diff compares the archive at OutputPath with other, which is either a
+// second archive or a directory. Directories are scanned with the same
+// settings as Create, so entries are named as Create would store them.
+func (a *Archiver) Diff(other string) (*DiffReport, error) {
+	oldEntries, err := a.archiveState(a.config.OutputPath)
+	if err != nil {
+		return nil, err
+	}
+
+	stat, err := os.Stat(other)
+	if err != nil {
+		return nil, err
+	}
+	var newEntries map[string]entryState
+	if stat.IsDir() {
+		newEntries, err = a.directoryState(other)
+	} else {
+		newEntries, err = a.archiveState(other)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	report := &DiffReport{Old: a.config.OutputPath, New: other}
+	report.Entries = diffStates(oldEntries, newEntries)
+	return report, nil
+}
+
+// diffStates compares two sets of entries. An added regular file whose
+// content matches a removed one is reported as a rename instead. Empty files,
+// links and special files all share the same empty content, so they are
+// never paired.
+func diffStates(oldEntries, newEntries map[string]entryState) []DiffEntry {
+	var (
+		diffs   []DiffEntry
+		added   []string
+		removed = make(map[string][]string) // hash -> removed names
+	)
+
+	for name, old := range oldEntries {
+		if _, ok := newEntries[name]; ok {
+			continue
+		}
+		if !old.renamable() {
+			diffs = append(diffs, DiffEntry{Kind: DiffRemoved, Path: name})
+			continue
+		}
+		removed[old.sha256] = append(removed[old.sha256], name)
+	}
+	for _, names := range removed {
+		sort.Strings(names)
+	}
+
+	for name, cur := range newEntries {
+		old, ok := oldEntries[name]
+		if !ok {
+			added = append(added, name)
+			continue
+		}
+		if changes := compareStates(old, cur); len(changes) > 0 {
+			diffs = append(diffs, DiffEntry{Kind: DiffModified, Path: name, Changes: changes})
+		}
+	}
+
+	// Pair additions with removals of the same content in name order, so
+	// the result does not depend on map iteration
+	sort.Strings(added)
+	for _, name := range added {
+		cur := newEntries[name]
+		hash := cur.sha256
+		if candidates := removed[hash]; cur.renamable() && len(candidates) > 0 {
+			diffs = append(diffs, DiffEntry{Kind: DiffRenamed, Path: name, OldPath: candidates[0]})
+			removed[hash] = candidates[1:]
+			continue
+		}
+		diffs = append(diffs, DiffEntry{Kind: DiffAdded, Path: name})
+	}
+	for _, names := range removed {
+		for _, name := range names {
+			diffs = append(diffs, DiffEntry{Kind: DiffRemoved, Path: name})
+		}
+	}
+
+	sort.Slice(diffs, func(i, j int) bool {
+		return diffs[i].Path < diffs[j].Path
+	})
+	return diffs
+}
+
+// renamable reports whether the entry has content to pair a rename by
+func (s entryState) renamable() bool {
+	return s.regular && s.size > 0
+}
+
+// compareStates lists the attributes that differ between two entries.
+// Timestamps are compared rounded to whole seconds, as archives store them.
+func compareStates(old, cur entryState) []string {
+	var changes []string
+	if old.size != cur.size {
+		changes = append(changes, "size")
+	}
+	if !old.modTime.Round(time.Second).Equal(cur.modTime.Round(time.Second)) {
+		changes = append(changes, "mtime")
+	}
+	if old.sha256 != cur.sha256 {
+		changes = append(changes, "hash")
+	}
+	if old.mode != cur.mode {
+		changes = append(changes, "mode")
+	}
+	if old.linkname != cur.linkname {
+		changes = append(changes, "linkname")
+	}
+	return changes
+}
+
+// archiveState hashes every entry of the archive at path
+func (a *Archiver) archiveState(path string) (map[string]entryState, error) {
+	tr, err := a.openArchive(path)
+	if err != nil {
+		return nil, err
+	}
+	defer tr.Close()
+
+	entries := make(map[string]entryState)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if isMetaEntry(header.Name) {
+			continue
+		}
+		if header.Typeflag == tar.TypeLink {
+			// Hard links share the content of an earlier entry
+			if target, ok := entries[header.Linkname]; ok {
+				target.mode = os.FileMode(header.Mode).Perm()
+				target.modTime = header.ModTime
+				entries[header.Name] = target
+				continue
+			}
+		}
+
+		h := sha256.New()
+		if _, err := io.Copy(h, tr); err != nil {
+			return nil, err
+		}
+		state := entryState{
+			size:    header.Size,
+			modTime: header.ModTime,
+			mode:    os.FileMode(header.Mode).Perm(),
+			sha256:  hex.EncodeToString(h.Sum(nil)),
+			regular: header.FileInfo().Mode().IsRegular(),
+		}
+		if header.Typeflag == tar.TypeSymlink {
+			state.linkname = header.Linkname
+		}
+		entries[header.Name] = state
+	}
+}
+
+// directoryState scans and hashes the files below dir
+func (a *Archiver) directoryState(dir string) (map[string]entryState, error) {
+	config := a.config
+	config.SourcePath = dir
+	config.SourceFS = nil
+	config.Sources = nil
+	scanner := New(config)
+
+	scanResults, err := scanner.Scan()
+	if err != nil {
+		return nil, err
+	}
+
+	var firstErr error
+	entries := make(map[string]entryState)
+	for result := range scanner.Filter(scanResults) {
+		if result.Error != nil {
+			if firstErr == nil {
+				firstErr = result.Error
+			}
+			continue
+		}
+		if result.FileInfo.IsDir || firstErr != nil {
+			continue
+		}
+
+		state, err := scanner.fileState(result.FileInfo.Path)
+		if err != nil {
+			firstErr = err
+			continue
+		}
+		entries[entryName(result.FileInfo)] = state
+	}
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return entries, nil
+}
+
+// fileState hashes a single file. Links and special files are archived
+// without content, so unless links are followed they are not opened.
+func (a *Archiver) fileState(path string) (entryState, error) {
+	lstat, err := os.Lstat(path)
+	if err != nil {
+		return entryState{}, err
+	}
+	follow := lstat.Mode()&os.ModeSymlink != 0 && a.config.SymlinkPolicy == SymlinkFollow
+	if !lstat.Mode().IsRegular() && !follow {
+		state := entryState{
+			modTime: lstat.ModTime(),
+			mode:    lstat.Mode().Perm(),
+			sha256:  emptySHA256,
+		}
+		if lstat.Mode()&os.ModeSymlink != 0 {
+			if state.linkname, err = os.Readlink(path); err != nil {
+				return entryState{}, err
+			}
+		}
+		return state, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return entryState{}, err
+	}
+	defer f.Close()
+
+	stat, err := f.Stat()
+	if err != nil {
+		return entryState{}, err
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return entryState{}, err
+	}
+	return entryState{
+		size:    stat.Size(),
+		modTime: stat.ModTime(),
+		mode:    stat.Mode().Perm(),
+		sha256:  hex.EncodeToString(h.Sum(nil)),
+		regular: true,
+	}, nil
+}
+
+// Count returns the number of differences of the given kind
+func (r *DiffReport) Count(kind DiffKind) int {
+	n := 0
+	for _, entry := range r.Entries {
+		if entry.Kind == kind {
+			n++
+		}
+	}
+	return n
+}
+
+// JSON encodes the report for tooling
+func (r *DiffReport) JSON() ([]byte, error) {
+	return json.MarshalIndent(r, "", "  ")
+}
+
+// String formats the report for review, one line per difference followed
+// by a summary
+func (r *DiffReport) String() string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "--- %s\n+++ %s\n", r.Old, r.New)
+	for _, entry := range r.Entries {
+		switch entry.Kind {
+		case DiffAdded:
+			fmt.Fprintf(&b, "A %s\n", entry.Path)
+		case DiffRemoved:
+			fmt.Fprintf(&b, "D %s\n", entry.Path)
+		case DiffModified:
+			fmt.Fprintf(&b, "M %s (%s)\n", entry.Path, strings.Join(entry.Changes, ", "))
+		case DiffRenamed:
+			fmt.Fprintf(&b, "R %s -> %s\n", entry.OldPath, entry.Path)
+		}
+	}
+	fmt.Fprintf(&b, "%d added, %d removed, %d modified, %d renamed\n",
+		r.Count(DiffAdded), r.Count(DiffRemoved), r.Count(DiffModified), r.Count(DiffRenamed))
+	return b.String()
+}
@@ -0,0 +1,113 @@
+package archiver
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDiff(t *testing.T) {
+	source := t.TempDir()
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, name := range []string{"a.txt", "b.txt", "c.txt"} {
+		writeSourceFile(t, filepath.Join(source, name), name, base)
+	}
+	outputDir := t.TempDir()
+	a := createArchive(t, Config{
+		SourcePath: source,
+		OutputPath: filepath.Join(outputDir, "old.tar.gz"),
+		FilterMode: FilterAll,
+	})
+
+	if err := os.Rename(filepath.Join(source, "a.txt"), filepath.Join(source, "e.txt")); err != nil {
+		t.Fatal(err)
+	}
+	writeSourceFile(t, filepath.Join(source, "b.txt"), "changed", base)
+	if err := os.Remove(filepath.Join(source, "c.txt")); err != nil {
+		t.Fatal(err)
+	}
+	writeSourceFile(t, filepath.Join(source, "d.txt"), "new file", base)
+
+	want := []DiffEntry{
+		{Kind: DiffModified, Path: "b.txt", Changes: []string{"size", "hash"}},
+		{Kind: DiffRemoved, Path: "c.txt"},
+		{Kind: DiffAdded, Path: "d.txt"},
+		{Kind: DiffRenamed, Path: "e.txt", OldPath: "a.txt"},
+	}
+
+	report, err := a.Diff(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(report.Entries, want) {
+		t.Errorf("Unexpected diff against directory:\n%s", report)
+	}
+
+	// The same changes captured in a second archive
+	newPath := filepath.Join(outputDir, "new.zip")
+	createArchive(t, Config{
+		SourcePath: source,
+		OutputPath: newPath,
+		FilterMode: FilterAll,
+	})
+	report, err = a.Diff(newPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(report.Entries, want) {
+		t.Errorf("Unexpected diff against archive:\n%s", report)
+	}
+
+	data, err := report.JSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded DiffReport
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded.Entries, want) {
+		t.Errorf("JSON report does not round-trip: %s", data)
+	}
+	if !strings.Contains(report.String(), "R a.txt -> e.txt") {
+		t.Errorf("Expected rename in human output:\n%s", report)
+	}
+}
+
+func TestDiffRenamePairing(t *testing.T) {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	file := func(content string) entryState {
+		return entryState{size: int64(len(content)), modTime: base, mode: 0644, sha256: content, regular: true}
+	}
+	link := func(target string) entryState {
+		return entryState{modTime: base, mode: 0777, sha256: emptySHA256, linkname: target}
+	}
+
+	oldEntries := map[string]entryState{
+		"empty.txt": file(""),
+		"old.txt":   file("content"),
+		"link":      link("a.txt"),
+		"gone":      link("b.txt"),
+	}
+	newEntries := map[string]entryState{
+		"blank.txt": file(""),
+		"new.txt":   file("content"),
+		"link":      link("c.txt"),
+		"added":     link("b.txt"),
+	}
+	want := []DiffEntry{
+		{Kind: DiffAdded, Path: "added"},
+		{Kind: DiffAdded, Path: "blank.txt"},
+		{Kind: DiffRemoved, Path: "empty.txt"},
+		{Kind: DiffRemoved, Path: "gone"},
+		{Kind: DiffModified, Path: "link", Changes: []string{"linkname"}},
+		{Kind: DiffRenamed, Path: "new.txt", OldPath: "old.txt"},
+	}
+	if got := diffStates(oldEntries, newEntries); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %+v, got %+v", want, got)
+	}
+}
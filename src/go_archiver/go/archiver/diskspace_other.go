@@ -0,0 +1,10 @@
+//go:build !(linux || darwin || freebsd)
+
+package archiver
+
+import "errors"
+
+// FreeSpace is not available on this platform
+func FreeSpace(path string) (int64, error) {
+	return 0, errors.ErrUnsupported
+}
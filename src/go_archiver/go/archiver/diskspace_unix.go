@@ -0,0 +1,15 @@
+//go:build linux || darwin || freebsd
+
+package archiver
+
+import "syscall"
+
+// FreeSpace returns the bytes available to unprivileged users on the file
+// system holding path
+func FreeSpace(path string) (int64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0, err
+	}
+	return int64(stat.Bavail) * int64(stat.Bsize), nil
+}
@@ -0,0 +1,547 @@
+package archiver
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Encryption errors
+var (
+	ErrNoIdentity       = errors.New("no identity matches the archive recipients")
+	ErrNotEncrypted     = errors.New("archive is not encrypted")
+	ErrInvalidKey       = errors.New("invalid key encoding")
+	ErrCorruptEncrypted = errors.New("encrypted archive is corrupt or has been tampered with")
+	ErrLastRecipient    = errors.New("cannot remove every recipient from an archive")
+)
+
+const (
+	encMagic      = "GARCENC1"
+	encChunkSize  = 64 * 1024
+	encTagSize    = 8
+	encSaltSize   = 16
+	encKeySize    = 32
+	encStanzaSize = encTagSize + 32 + encKeySize + 16
+)
+
+// recipientStanza holds the file key wrapped for a single X25519 recipient.
+type recipientStanza struct {
+	tag       [encTagSize]byte // truncated hash of the recipient public key
+	ephemeral [32]byte
+	wrapped   [encKeySize + 16]byte
+}
+
+// encryptionHeader is the plaintext header at the start of an encrypted
+// archive. The payload following it only depends on fileKey and salt, so
+// stanzas can be added or removed without touching the payload.
+type encryptionHeader struct {
+	stanzas []recipientStanza
+	salt    [encSaltSize]byte
+	fileKey []byte
+}
+
+// GenerateX25519Identity creates a new key pair for archive encryption. The
+// identity is the private half and must be kept secret; the recipient is
+// handed to whoever creates archives for its owner.
+func GenerateX25519Identity() (identity, recipient string, err error) {
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return "", "", err
+	}
+	identity = base64.StdEncoding.EncodeToString(key.Bytes())
+	recipient = base64.StdEncoding.EncodeToString(key.PublicKey().Bytes())
+	return identity, recipient, nil
+}
+
+// X25519Recipient returns the recipient (public key) for an identity.
+func X25519Recipient(identity string) (string, error) {
+	key, err := parseIdentity(identity)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(key.PublicKey().Bytes()), nil
+}
+
+func parseRecipient(s string) (*ecdh.PublicKey, error) {
+	raw, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidKey, err)
+	}
+	key, err := ecdh.X25519().NewPublicKey(raw)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidKey, err)
+	}
+	return key, nil
+}
+
+func parseIdentity(s string) (*ecdh.PrivateKey, error) {
+	raw, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidKey, err)
+	}
+	key, err := ecdh.X25519().NewPrivateKey(raw)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidKey, err)
+	}
+	return key, nil
+}
+
+func recipientTag(pub *ecdh.PublicKey) [encTagSize]byte {
+	var tag [encTagSize]byte
+	sum := sha256.Sum256(pub.Bytes())
+	copy(tag[:], sum[:])
+	return tag
+}
+
+// hkdfSHA256 implements RFC 5869 with SHA-256.
+func hkdfSHA256(secret, salt []byte, info string, length int) []byte {
+	if salt == nil {
+		salt = make([]byte, sha256.Size)
+	}
+	extract := hmac.New(sha256.New, salt)
+	extract.Write(secret)
+	prk := extract.Sum(nil)
+
+	var out, prev []byte
+	for counter := byte(1); len(out) < length; counter++ {
+		expand := hmac.New(sha256.New, prk)
+		expand.Write(prev)
+		expand.Write([]byte(info))
+		expand.Write([]byte{counter})
+		prev = expand.Sum(nil)
+		out = append(out, prev...)
+	}
+	return out[:length]
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// wrapKey seals the file key for a recipient using a fresh ephemeral key.
+func wrapKey(fileKey []byte, pub *ecdh.PublicKey) (recipientStanza, error) {
+	var stanza recipientStanza
+
+	eph, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return stanza, err
+	}
+	shared, err := eph.ECDH(pub)
+	if err != nil {
+		return stanza, err
+	}
+
+	salt := append(eph.PublicKey().Bytes(), pub.Bytes()...)
+	aead, err := newGCM(hkdfSHA256(shared, salt, "go-archiver x25519 wrap", encKeySize))
+	if err != nil {
+		return stanza, err
+	}
+
+	stanza.tag = recipientTag(pub)
+	copy(stanza.ephemeral[:], eph.PublicKey().Bytes())
+	// The wrapping key is single-use, so a zero nonce is safe.
+	copy(stanza.wrapped[:], aead.Seal(nil, make([]byte, aead.NonceSize()), fileKey, nil))
+	return stanza, nil
+}
+
+// unwrapKey recovers the file key from a stanza addressed to the identity.
+func unwrapKey(stanza recipientStanza, id *ecdh.PrivateKey) ([]byte, error) {
+	eph, err := ecdh.X25519().NewPublicKey(stanza.ephemeral[:])
+	if err != nil {
+		return nil, err
+	}
+	shared, err := id.ECDH(eph)
+	if err != nil {
+		return nil, err
+	}
+
+	salt := append(eph.Bytes(), id.PublicKey().Bytes()...)
+	aead, err := newGCM(hkdfSHA256(shared, salt, "go-archiver x25519 wrap", encKeySize))
+	if err != nil {
+		return nil, err
+	}
+	return aead.Open(nil, make([]byte, aead.NonceSize()), stanza.wrapped[:], nil)
+}
+
+// newEncryptionHeader creates a header with a random file key wrapped for
+// every recipient.
+func newEncryptionHeader(recipients []string) (*encryptionHeader, error) {
+	hdr := &encryptionHeader{fileKey: make([]byte, encKeySize)}
+	if _, err := rand.Read(hdr.fileKey); err != nil {
+		return nil, err
+	}
+	if _, err := rand.Read(hdr.salt[:]); err != nil {
+		return nil, err
+	}
+	if err := hdr.addRecipients(recipients); err != nil {
+		return nil, err
+	}
+	return hdr, nil
+}
+
+// addRecipients wraps the file key for recipients not already present.
+func (h *encryptionHeader) addRecipients(recipients []string) error {
+	for _, r := range recipients {
+		pub, err := parseRecipient(r)
+		if err != nil {
+			return err
+		}
+		if h.hasRecipient(recipientTag(pub)) {
+			continue
+		}
+		stanza, err := wrapKey(h.fileKey, pub)
+		if err != nil {
+			return err
+		}
+		h.stanzas = append(h.stanzas, stanza)
+	}
+	return nil
+}
+
+// removeRecipients drops the stanzas belonging to recipients.
+func (h *encryptionHeader) removeRecipients(recipients []string) error {
+	remove := make(map[[encTagSize]byte]bool)
+	for _, r := range recipients {
+		pub, err := parseRecipient(r)
+		if err != nil {
+			return err
+		}
+		remove[recipientTag(pub)] = true
+	}
+
+	kept := h.stanzas[:0]
+	for _, stanza := range h.stanzas {
+		if !remove[stanza.tag] {
+			kept = append(kept, stanza)
+		}
+	}
+	if len(kept) == 0 {
+		return ErrLastRecipient
+	}
+	h.stanzas = kept
+	return nil
+}
+
+func (h *encryptionHeader) hasRecipient(tag [encTagSize]byte) bool {
+	for _, stanza := range h.stanzas {
+		if stanza.tag == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// withNewSalt returns a copy of the header for a fresh payload encrypted
+// under the same file key and recipients.
+func (h *encryptionHeader) withNewSalt() (*encryptionHeader, error) {
+	next := &encryptionHeader{
+		stanzas: append([]recipientStanza(nil), h.stanzas...),
+		fileKey: h.fileKey,
+	}
+	if _, err := rand.Read(next.salt[:]); err != nil {
+		return nil, err
+	}
+	return next, nil
+}
+
+func (h *encryptionHeader) marshal() []byte {
+	var buf bytes.Buffer
+	buf.WriteString(encMagic)
+	binary.Write(&buf, binary.BigEndian, uint16(len(h.stanzas)))
+	for _, stanza := range h.stanzas {
+		buf.Write(stanza.tag[:])
+		buf.Write(stanza.ephemeral[:])
+		buf.Write(stanza.wrapped[:])
+	}
+	buf.Write(h.salt[:])
+
+	mac := hmac.New(sha256.New, hkdfSHA256(h.fileKey, nil, "header", encKeySize))
+	mac.Write(buf.Bytes())
+	buf.Write(mac.Sum(nil))
+	return buf.Bytes()
+}
+
+// readEncryptionHeader parses the header from r and unwraps the file key with
+// the first matching identity.
+func readEncryptionHeader(r io.Reader, identities []string) (*encryptionHeader, error) {
+	var raw bytes.Buffer
+	body := io.TeeReader(r, &raw)
+
+	magic := make([]byte, len(encMagic))
+	if _, err := io.ReadFull(body, magic); err != nil || string(magic) != encMagic {
+		return nil, ErrNotEncrypted
+	}
+
+	var count uint16
+	if err := binary.Read(body, binary.BigEndian, &count); err != nil {
+		return nil, ErrCorruptEncrypted
+	}
+
+	hdr := &encryptionHeader{stanzas: make([]recipientStanza, count)}
+	for i := range hdr.stanzas {
+		stanza := &hdr.stanzas[i]
+		for _, field := range [][]byte{stanza.tag[:], stanza.ephemeral[:], stanza.wrapped[:]} {
+			if _, err := io.ReadFull(body, field); err != nil {
+				return nil, ErrCorruptEncrypted
+			}
+		}
+	}
+	if _, err := io.ReadFull(body, hdr.salt[:]); err != nil {
+		return nil, ErrCorruptEncrypted
+	}
+
+	mac := make([]byte, sha256.Size)
+	if _, err := io.ReadFull(r, mac); err != nil {
+		return nil, ErrCorruptEncrypted
+	}
+
+	for _, s := range identities {
+		id, err := parseIdentity(s)
+		if err != nil {
+			return nil, err
+		}
+		tag := recipientTag(id.PublicKey())
+		for _, stanza := range hdr.stanzas {
+			if stanza.tag != tag {
+				continue
+			}
+			fileKey, err := unwrapKey(stanza, id)
+			if err != nil {
+				continue
+			}
+			expected := hmac.New(sha256.New, hkdfSHA256(fileKey, nil, "header", encKeySize))
+			expected.Write(raw.Bytes())
+			if !hmac.Equal(mac, expected.Sum(nil)) {
+				return nil, ErrCorruptEncrypted
+			}
+			hdr.fileKey = fileKey
+			return hdr, nil
+		}
+	}
+	return nil, ErrNoIdentity
+}
+
+// isEncrypted reports whether the buffered stream starts with an encryption
+// header.
+func isEncrypted(br *bufio.Reader) bool {
+	magic, err := br.Peek(len(encMagic))
+	return err == nil && string(magic) == encMagic
+}
+
+// streamNonce builds the STREAM nonce for a payload chunk: a big-endian
+// chunk counter followed by a flag marking the final chunk.
+func streamNonce(counter uint64, last bool) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce[3:11], counter)
+	if last {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+// encryptWriter encrypts the payload in fixed-size authenticated chunks.
+type encryptWriter struct {
+	w       io.Writer
+	aead    cipher.AEAD
+	buf     []byte
+	counter uint64
+	closed  bool
+}
+
+// newEncryptWriter writes the header to w and returns a writer for the
+// payload. Close must be called to seal the final chunk.
+func newEncryptWriter(w io.Writer, hdr *encryptionHeader) (*encryptWriter, error) {
+	aead, err := newGCM(hkdfSHA256(hdr.fileKey, hdr.salt[:], "payload", encKeySize))
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(hdr.marshal()); err != nil {
+		return nil, err
+	}
+	return &encryptWriter{
+		w:    w,
+		aead: aead,
+		buf:  make([]byte, 0, encChunkSize),
+	}, nil
+}
+
+func (e *encryptWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		// Only seal a full chunk once more data arrives, so the final
+		// chunk is always the one sealed by Close.
+		if len(e.buf) == encChunkSize {
+			if err := e.flush(false); err != nil {
+				return written, err
+			}
+		}
+		n := copy(e.buf[len(e.buf):encChunkSize], p)
+		e.buf = e.buf[:len(e.buf)+n]
+		p = p[n:]
+		written += n
+	}
+	return written, nil
+}
+
+func (e *encryptWriter) flush(last bool) error {
+	sealed := e.aead.Seal(nil, streamNonce(e.counter, last), e.buf, nil)
+	e.counter++
+	e.buf = e.buf[:0]
+	_, err := e.w.Write(sealed)
+	return err
+}
+
+// Close seals the final chunk. It does not close the underlying writer.
+func (e *encryptWriter) Close() error {
+	if e.closed {
+		return nil
+	}
+	e.closed = true
+	return e.flush(true)
+}
+
+// decryptReader authenticates and decrypts the chunked payload.
+type decryptReader struct {
+	r       *bufio.Reader
+	aead    cipher.AEAD
+	chunk   []byte
+	plain   []byte
+	counter uint64
+	done    bool
+}
+
+func newDecryptReader(r *bufio.Reader, hdr *encryptionHeader) (*decryptReader, error) {
+	aead, err := newGCM(hkdfSHA256(hdr.fileKey, hdr.salt[:], "payload", encKeySize))
+	if err != nil {
+		return nil, err
+	}
+	return &decryptReader{
+		r:     r,
+		aead:  aead,
+		chunk: make([]byte, encChunkSize+aead.Overhead()),
+	}, nil
+}
+
+func (d *decryptReader) Read(p []byte) (int, error) {
+	for len(d.plain) == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+		if err := d.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, d.plain)
+	d.plain = d.plain[n:]
+	return n, nil
+}
+
+func (d *decryptReader) next() error {
+	n, err := io.ReadFull(d.r, d.chunk)
+	last := false
+	switch {
+	case err == io.ErrUnexpectedEOF:
+		last = true
+	case err == io.EOF:
+		// A well-formed payload always ends with a sealed final chunk.
+		return ErrCorruptEncrypted
+	case err != nil:
+		return err
+	default:
+		if _, err := d.r.Peek(1); err == io.EOF {
+			last = true
+		}
+	}
+
+	plain, err := d.aead.Open(d.chunk[:0], streamNonce(d.counter, last), d.chunk[:n], nil)
+	if err != nil {
+		return ErrCorruptEncrypted
+	}
+	d.counter++
+	d.plain = plain
+	d.done = last
+	return nil
+}
+
+// AddRecipients grants additional X25519 recipients access to the encrypted
+// archive at OutputPath. Only the header is rewritten; the payload is copied
+// as-is. One of the configured identities must already be a recipient.
+func (a *Archiver) AddRecipients(recipients []string) error {
+	return a.rewriteEncryptionHeader(func(hdr *encryptionHeader) error {
+		return hdr.addRecipients(recipients)
+	})
+}
+
+// RemoveRecipients revokes access for recipients from the encrypted archive
+// at OutputPath. Only the header is rewritten; the payload is copied as-is.
+// Note that a removed recipient who kept a copy of the file key can still
+// decrypt the payload.
+func (a *Archiver) RemoveRecipients(recipients []string) error {
+	return a.rewriteEncryptionHeader(func(hdr *encryptionHeader) error {
+		return hdr.removeRecipients(recipients)
+	})
+}
+
+// rewriteEncryptionHeader applies edit to the archive header and writes the
+// new header followed by the untouched payload.
+func (a *Archiver) rewriteEncryptionHeader(edit func(*encryptionHeader) error) error {
+	src, err := openRaw(a.config.OutputPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	br := bufio.NewReader(src)
+	if !isEncrypted(br) {
+		return ErrNotEncrypted
+	}
+	hdr, err := readEncryptionHeader(br, a.config.Identities)
+	if err != nil {
+		return err
+	}
+	if err := edit(hdr); err != nil {
+		return err
+	}
+
+	placeholder, err := os.CreateTemp(filepath.Dir(a.config.OutputPath), "temp_*.enc")
+	if err != nil {
+		return err
+	}
+	placeholder.Close()
+	tempPath := placeholder.Name()
+	defer removeOutput(tempPath)
+
+	tempFile, err := createOutput(tempPath, src.volumeSize)
+	if err != nil {
+		return err
+	}
+	if _, err := tempFile.Write(hdr.marshal()); err != nil {
+		tempFile.Close()
+		return err
+	}
+	if _, err := io.Copy(tempFile, br); err != nil {
+		tempFile.Close()
+		return err
+	}
+	if err := tempFile.Close(); err != nil {
+		return err
+	}
+
+	return commitOutput(tempPath, a.config.OutputPath, src.volumeSize)
+}
@@ -0,0 +1,141 @@
+package archiver
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// createArchive runs the full pipeline for config and fails the test on error
+func createArchive(t *testing.T, config Config) *Archiver {
+	t.Helper()
+
+	a := New(config)
+	scanResults, err := a.Scan()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for result := range a.Create(a.Filter(scanResults)) {
+		if result.Error != nil {
+			t.Fatalf("Create failed: %v", result.Error)
+		}
+	}
+	return a
+}
+
+func TestEncryptedArchive(t *testing.T) {
+	aliceID, alice, err := GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bobID, bob, err := GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	outputPath := filepath.Join(t.TempDir(), "enc.tar.gz")
+	config := Config{
+		SourcePath: "testdata/source",
+		OutputPath: outputPath,
+		Recursive:  true,
+		FilterMode: FilterPhotos,
+		Recipients: []string{alice},
+	}
+	createArchive(t, config)
+
+	listWith := func(identities ...string) ([]string, error) {
+		c := config
+		c.Identities = identities
+		files, err := New(c).ListFiles()
+		sort.Strings(files)
+		return files, err
+	}
+
+	files, err := listWith(aliceID)
+	if err != nil {
+		t.Fatalf("Expected alice to open the archive: %v", err)
+	}
+	if len(files) != 2 {
+		t.Errorf("Expected 2 files, got %v", files)
+	}
+
+	if _, err := listWith(bobID); !errors.Is(err, ErrNoIdentity) {
+		t.Errorf("Expected ErrNoIdentity for bob, got %v", err)
+	}
+
+	before, err := os.ReadFile(outputPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := config
+	c.Identities = []string{aliceID}
+	if err := New(c).AddRecipients([]string{bob}); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := listWith(bobID); err != nil || !reflect.DeepEqual(got, files) {
+		t.Errorf("Expected bob to list %v after being added, got %v (%v)", files, got, err)
+	}
+
+	// Adding a recipient must leave the payload untouched
+	after, err := os.ReadFile(outputPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	headerLen := len(encMagic) + 2 + encStanzaSize + encSaltSize + 32
+	if !bytes.HasSuffix(after, before[headerLen:]) {
+		t.Error("Expected payload to be copied unchanged")
+	}
+	if len(after)-len(before) != encStanzaSize {
+		t.Errorf("Expected header to grow by one stanza, grew by %d bytes", len(after)-len(before))
+	}
+
+	if err := New(c).RemoveRecipients([]string{alice}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := listWith(aliceID); !errors.Is(err, ErrNoIdentity) {
+		t.Errorf("Expected alice to be revoked, got %v", err)
+	}
+	if _, err := listWith(bobID); err != nil {
+		t.Errorf("Expected bob to keep access: %v", err)
+	}
+
+	c.Identities = []string{bobID}
+	if err := New(c).RemoveRecipients([]string{bob}); !errors.Is(err, ErrLastRecipient) {
+		t.Errorf("Expected ErrLastRecipient, got %v", err)
+	}
+}
+
+func TestEncryptedArchiveTampering(t *testing.T) {
+	id, recipient, err := GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	outputPath := filepath.Join(t.TempDir(), "enc.tar.gz")
+	createArchive(t, Config{
+		SourcePath: "testdata/source",
+		OutputPath: outputPath,
+		Recursive:  true,
+		FilterMode: FilterAll,
+		Recipients: []string{recipient},
+	})
+
+	data, err := os.ReadFile(outputPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)-1] ^= 0xff
+	if err := os.WriteFile(outputPath, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	a := New(Config{OutputPath: outputPath, Identities: []string{id}})
+	if _, err := a.ListFiles(); !errors.Is(err, ErrCorruptEncrypted) {
+		t.Errorf("Expected ErrCorruptEncrypted, got %v", err)
+	}
+}
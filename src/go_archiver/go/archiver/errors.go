@@ -0,0 +1,170 @@
+package archiver
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"sync"
+	"time"
+)
+
+// Stage names the pipeline step a file failed in
+type Stage string
+
+const (
+	StageScan  Stage = "scan"  // Listing or filtering the source
+	StageOpen  Stage = "open"  // Opening or statting the file
+	StageRead  Stage = "read"  // Reading the file after its entry was started
+	StageWrite Stage = "write" // Writing the archive; always fatal
+)
+
+// FileError is the error of a single file. It unwraps to the underlying
+// error, so errors.Is and errors.As see through it.
+type FileError struct {
+	Path  string
+	Stage Stage
+	Err   error
+}
+
+func (e *FileError) Error() string {
+	if e.Path == "" {
+		return fmt.Sprintf("%s: %v", e.Stage, e.Err)
+	}
+	return fmt.Sprintf("%s %s: %v", e.Stage, e.Path, e.Err)
+}
+
+func (e *FileError) Unwrap() error {
+	return e.Err
+}
+
+// fileError wraps err for path and stage. Errors that already are a
+// FileError keep their path and stage; a missing path is taken from an
+// fs.PathError.
+func fileError(err error, path string, stage Stage) *FileError {
+	var fe *FileError
+	if errors.As(err, &fe) {
+		return fe
+	}
+	if path == "" {
+		var pe *fs.PathError
+		if errors.As(err, &pe) {
+			path = pe.Path
+		}
+	}
+	return &FileError{Path: path, Stage: stage, Err: err}
+}
+
+// ErrorPolicy decides when Create gives up after files fail
+type ErrorPolicy string
+
+const (
+	ErrorFailFast  ErrorPolicy = "fail-fast"  // Stop at the first failed file; the default
+	ErrorContinue  ErrorPolicy = "continue"   // Skip failed files and finish the archive
+	ErrorMaxErrors ErrorPolicy = "max-errors" // Skip failed files until more than MaxErrors have failed
+)
+
+var (
+	// ErrAborted is returned when Create stops before finishing the archive
+	ErrAborted = errors.New("archive creation aborted")
+	// ErrTooManyErrors is returned when more than MaxErrors files failed
+	ErrTooManyErrors = errors.New("too many errors")
+)
+
+// Defaults for retrying transient IO errors
+const (
+	DefaultIORetries    = 2
+	DefaultIORetryDelay = 50 * time.Millisecond
+)
+
+// errorTracker collects the errors of a run and applies the error policy
+type errorTracker struct {
+	mu      sync.Mutex
+	policy  ErrorPolicy
+	max     int
+	errs    []*FileError
+	aborted error
+}
+
+// newErrorTracker validates the configured policy. The archives of a Route
+// share the tracker of the router.
+func (a *Archiver) newErrorTracker() (*errorTracker, error) {
+	if a.tracker != nil {
+		return a.tracker, nil
+	}
+	t := &errorTracker{policy: a.config.ErrorPolicy, max: a.config.MaxErrors}
+	switch t.policy {
+	case "":
+		t.policy = ErrorFailFast
+	case ErrorFailFast, ErrorContinue:
+	case ErrorMaxErrors:
+		if t.max < 0 {
+			return nil, fmt.Errorf("%w: max-errors needs MaxErrors >= 0", ErrUnknownPolicy)
+		}
+	default:
+		return nil, fmt.Errorf("%w: errors %s", ErrUnknownPolicy, t.policy)
+	}
+	return t, nil
+}
+
+// add records a failed file and reports whether the run must stop
+func (t *errorTracker) add(fe *FileError) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.errs = append(t.errs, fe)
+	if t.aborted != nil {
+		return true
+	}
+	switch {
+	case fe.Stage == StageWrite, t.policy == ErrorFailFast:
+		t.aborted = fmt.Errorf("%w: %w", ErrAborted, fe)
+	case t.policy == ErrorMaxErrors && len(t.errs) > t.max:
+		t.aborted = fmt.Errorf("%w: %w: %d files failed", ErrAborted, ErrTooManyErrors, len(t.errs))
+	}
+	return t.aborted != nil
+}
+
+// stopped returns the reason the run stopped, or nil
+func (t *errorTracker) stopped() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.aborted
+}
+
+// list returns every recorded error
+func (t *errorTracker) list() []*FileError {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]*FileError(nil), t.errs...)
+}
+
+// isTransient reports whether err is worth retrying, such as an
+// interrupted call or a timeout on a network mount
+func isTransient(err error) bool {
+	var temporary interface{ Temporary() bool }
+	if errors.As(err, &temporary) && temporary.Temporary() {
+		return true
+	}
+	var timeout interface{ Timeout() bool }
+	return errors.As(err, &timeout) && timeout.Timeout()
+}
+
+// withRetry runs op, retrying transient errors with exponential backoff
+func (a *Archiver) withRetry(op func() error) error {
+	retries := a.config.IORetries
+	if retries == 0 {
+		retries = DefaultIORetries
+	}
+	delay := a.config.IORetryDelay
+	if delay <= 0 {
+		delay = DefaultIORetryDelay
+	}
+
+	for attempt := 0; ; attempt++ {
+		err := op()
+		if err == nil || attempt >= retries || !isTransient(err) {
+			return err
+		}
+		time.Sleep(delay << attempt)
+	}
+}
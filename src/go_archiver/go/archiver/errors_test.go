@@ -0,0 +1,179 @@
+package archiver
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"testing/fstest"
+)
+
+var errBroken = errors.New("broken disk")
+
+// timeoutError is a transient error, as a network mount reports it
+type timeoutError struct{}
+
+func (timeoutError) Error() string { return "i/o timeout" }
+func (timeoutError) Timeout() bool { return true }
+
+// failingFS fails to open missing.txt, times out on the first opens of
+// flaky.txt and fails halfway through reading broken.txt
+type failingFS struct {
+	fstest.MapFS
+	timeouts int
+}
+
+func (f *failingFS) Open(name string) (fs.File, error) {
+	switch name {
+	case "missing.txt":
+		return nil, &fs.PathError{Op: "open", Path: name, Err: errBroken}
+	case "flaky.txt":
+		if f.timeouts > 0 {
+			f.timeouts--
+			return nil, &fs.PathError{Op: "open", Path: name, Err: timeoutError{}}
+		}
+	case "broken.txt":
+		file, err := f.MapFS.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		return &brokenFile{File: file}, nil
+	}
+	return f.MapFS.Open(name)
+}
+
+// brokenFile returns its first bytes, then errBroken
+type brokenFile struct {
+	fs.File
+	read bool
+}
+
+func (b *brokenFile) Read(p []byte) (int, error) {
+	if b.read {
+		return 0, errBroken
+	}
+	b.read = true
+	return b.File.Read(p[:2])
+}
+
+func newFailingFS(timeouts int) *failingFS {
+	fsys := fstest.MapFS{}
+	for _, name := range []string{"a.txt", "b.txt", "flaky.txt", "missing.txt", "broken.txt"} {
+		fsys[name] = &fstest.MapFile{Data: []byte("content of " + name)}
+	}
+	return &failingFS{MapFS: fsys, timeouts: timeouts}
+}
+
+// createWithErrors runs the pipeline and returns the per-file errors as
+// they were reported and the final result
+func createWithErrors(t *testing.T, config Config) (*Archiver, []*FileError, CreateResult) {
+	t.Helper()
+
+	a := New(config)
+	scanResults, err := a.Scan()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var (
+		reported []*FileError
+		final    CreateResult
+	)
+	for result := range a.Create(a.Filter(scanResults)) {
+		if fe, ok := result.Error.(*FileError); ok {
+			reported = append(reported, fe)
+			continue
+		}
+		final = result
+	}
+	return a, reported, final
+}
+
+func TestErrorPolicies(t *testing.T) {
+	config := func(policy ErrorPolicy, maxErrors int) Config {
+		return Config{
+			SourceFS:     newFailingFS(1),
+			OutputPath:   filepath.Join(t.TempDir(), "out.tar.gz"),
+			FilterMode:   FilterAll,
+			ErrorPolicy:  policy,
+			MaxErrors:    maxErrors,
+			IORetryDelay: 1,
+		}
+	}
+
+	// Continue archives every readable file and lists the failures
+	a, reported, final := createWithErrors(t, config(ErrorContinue, 0))
+	if final.Error != nil {
+		t.Fatal(final.Error)
+	}
+	if !reflect.DeepEqual(final.Errors, reported) {
+		t.Errorf("Expected the final result to list the reported errors, got %v and %v", final.Errors, reported)
+	}
+	var failed []string
+	for _, fe := range final.Errors {
+		failed = append(failed, fmt.Sprintf("%s:%s", fe.Stage, fe.Path))
+		if !errors.Is(fe, errBroken) {
+			t.Errorf("Expected %v to wrap errBroken", fe)
+		}
+	}
+	sort.Strings(failed)
+	if want := []string{"open:missing.txt", "read:broken.txt"}; !reflect.DeepEqual(failed, want) {
+		t.Errorf("Expected failures %v, got %v", want, failed)
+	}
+	contents := mergedContents(t, a)
+	if contents["flaky.txt"] != "content of flaky.txt" {
+		t.Errorf("Expected flaky.txt archived after a retry, got %q", contents["flaky.txt"])
+	}
+	if got := contents["broken.txt"]; len(got) != len("content of broken.txt") || got[:2] != "co" {
+		t.Errorf("Expected broken.txt padded to its size, got %q", got)
+	}
+	if _, ok := contents["missing.txt"]; ok {
+		t.Error("Expected missing.txt to be left out")
+	}
+
+	// Fail-fast stops at the first failure
+	_, reported, final = createWithErrors(t, config("", 0))
+	var fe *FileError
+	if !errors.Is(final.Error, ErrAborted) || !errors.As(final.Error, &fe) || !errors.Is(final.Error, errBroken) {
+		t.Errorf("Expected an aborted run wrapping the failed file, got %v", final.Error)
+	}
+	if len(reported) != 1 || len(final.Errors) != 1 {
+		t.Errorf("Expected one failed file, got %v", final.Errors)
+	}
+
+	// Max-errors tolerates a number of failures
+	if _, _, final = createWithErrors(t, config(ErrorMaxErrors, 1)); !errors.Is(final.Error, ErrTooManyErrors) {
+		t.Errorf("Expected ErrTooManyErrors, got %v", final.Error)
+	}
+	if _, _, final = createWithErrors(t, config(ErrorMaxErrors, 2)); final.Error != nil || len(final.Errors) != 2 {
+		t.Errorf("Expected a finished archive with two failures, got %v (%v)", final.Error, final.Errors)
+	}
+
+	// Without retries the transient error is a failure of its own
+	noRetry := config(ErrorContinue, 0)
+	noRetry.IORetries = -1
+	if _, _, final = createWithErrors(t, noRetry); len(final.Errors) != 3 {
+		t.Errorf("Expected flaky.txt to fail without retries, got %v", final.Errors)
+	}
+
+	if _, _, final = createWithErrors(t, config("sometimes", 0)); !errors.Is(final.Error, ErrUnknownPolicy) {
+		t.Errorf("Expected ErrUnknownPolicy, got %v", final.Error)
+	}
+}
+
+func TestUpdateResultKeepsEveryError(t *testing.T) {
+	a := New(Config{})
+	first := fileError(errBroken, "a.txt", StageOpen)
+	a.UpdateResult(0, 0, "", first)
+	a.UpdateResult(0, 0, "", ErrFileChanged)
+
+	if a.result.Error != first || len(a.result.Errors) != 2 {
+		t.Errorf("Expected the first error kept and both listed, got %v and %v", a.result.Error, a.result.Errors)
+	}
+	var fe *FileError
+	if err := a.GetErrors(); !errors.Is(err, ErrFileChanged) || !errors.As(err, &fe) || fe.Path != "a.txt" {
+		t.Errorf("Expected the joined errors to match both, got %v", err)
+	}
+}
@@ -0,0 +1,247 @@
+package archiver
+
+import (
+	"io"
+	"io/fs"
+	"sort"
+	"time"
+)
+
+const (
+	// estimateSamples is the number of files sampled per category
+	estimateSamples = 8
+	// estimateChunk is the size of the chunk read from each sampled file
+	estimateChunk = 1 << 20
+	// Container overhead per entry: a tar header and half a block of
+	// padding on average, or the local and central zip headers
+	tarEntryOverhead = 768
+	zipEntryOverhead = 128
+)
+
+// CategoryEstimate is the prediction for the files of one category
+type CategoryEstimate struct {
+	Files      int64   `json:"files"`
+	InputSize  int64   `json:"input_size"`
+	OutputSize int64   `json:"output_size"`
+	Ratio      float64 `json:"ratio"` // Compressed to original size of the sampled chunks
+	// CompressThroughput is the rate the chunks were compressed at, in
+	// bytes per second; zero when nothing was compressed
+	CompressThroughput float64 `json:"compress_throughput"`
+	Sampled            int64   `json:"sampled"` // Bytes sampled
+}
+
+// Estimate predicts the size and runtime of Create
+type Estimate struct {
+	Files      int64                       `json:"files"`
+	InputSize  int64                       `json:"input_size"`
+	OutputSize int64                       `json:"output_size"`
+	Duration   time.Duration               `json:"duration"`
+	Categories map[string]CategoryEstimate `json:"categories"`
+	// ReadThroughput is the rate the chunks were read from the source, in
+	// bytes per second
+	ReadThroughput float64 `json:"read_throughput"`
+}
+
+// Estimate runs Scan and Filter and predicts the archive Create would
+// write. A few files of each category are sampled the way Plan samples
+// every file, with a larger chunk, timing the source and the codec. The
+// ratio of each category is applied to all its files.
+func (a *Archiver) Estimate() (*Estimate, error) {
+	scanResults, err := a.Scan()
+	if err != nil {
+		return nil, err
+	}
+
+	byCategory := make(map[string][]FileInfo)
+	for result := range a.Filter(scanResults) {
+		if result.Error != nil || result.FileInfo.IsDir {
+			continue
+		}
+		category := fileCategory(result.FileInfo.Path)
+		byCategory[category] = append(byCategory[category], result.FileInfo)
+	}
+
+	// The end of a tar archive is marked by two empty blocks
+	estimate := &Estimate{Categories: make(map[string]CategoryEstimate), OutputSize: 1024}
+	overhead := int64(tarEntryOverhead)
+	if a.layout().format == FormatZip {
+		estimate.OutputSize = 22 // End of central directory record
+		overhead = zipEntryOverhead
+	}
+	var (
+		readBytes int64
+		readTime  time.Duration
+	)
+	for category, files := range byCategory {
+		ce := CategoryEstimate{Files: int64(len(files)), Ratio: 1}
+		for _, info := range files {
+			if specialHeader(info) == nil {
+				ce.InputSize += info.Size
+			}
+		}
+
+		var compressed float64
+		var compressTime time.Duration
+		for _, info := range representatives(files) {
+			sample, err := a.sampleCompression(info, estimateChunk)
+			if err != nil || sample.sampled == 0 {
+				continue
+			}
+			readBytes += sample.sampled
+			readTime += sample.readTime
+			ce.Sampled += sample.sampled
+			compressed += float64(sample.sampled) * sample.ratio
+			compressTime += sample.compressTime
+		}
+		if ce.Sampled > 0 {
+			ce.Ratio = compressed / float64(ce.Sampled)
+		}
+		if compressTime > 0 {
+			ce.CompressThroughput = float64(ce.Sampled) / compressTime.Seconds()
+		}
+		ce.OutputSize = int64(float64(ce.InputSize)*ce.Ratio) + ce.Files*overhead
+
+		estimate.Categories[category] = ce
+		estimate.Files += ce.Files
+		estimate.InputSize += ce.InputSize
+		estimate.OutputSize += ce.OutputSize
+	}
+
+	if readTime > 0 {
+		estimate.ReadThroughput = float64(readBytes) / readTime.Seconds()
+	}
+	for _, ce := range estimate.Categories {
+		seconds := 0.0
+		if estimate.ReadThroughput > 0 {
+			seconds += float64(ce.InputSize) / estimate.ReadThroughput
+		}
+		if ce.CompressThroughput > 0 {
+			seconds += float64(ce.InputSize) / ce.CompressThroughput
+		}
+		estimate.Duration += time.Duration(seconds * float64(time.Second))
+	}
+	return estimate, nil
+}
+
+// representatives picks up to estimateSamples files spread evenly over the
+// range of sizes
+func representatives(files []FileInfo) []FileInfo {
+	sorted := append([]FileInfo(nil), files...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Size < sorted[j].Size })
+	if len(sorted) <= estimateSamples {
+		return sorted
+	}
+	picked := make([]FileInfo, estimateSamples)
+	for i := range picked {
+		picked[i] = sorted[i*(len(sorted)-1)/(estimateSamples-1)]
+	}
+	return picked
+}
+
+// compressionSample is what sampling a file predicts for its entry
+type compressionSample struct {
+	ratio  float64 // Compressed to original size, at most 1
+	stored bool    // Written without compression
+	// sampled is the number of bytes read, and readTime and compressTime
+	// how long reading and compressing them took
+	sampled      int64
+	readTime     time.Duration
+	compressTime time.Duration
+}
+
+// sampleCompression reads up to chunkSize bytes from the middle of a file
+// and predicts how Create would store it: zip entries the store policy
+// picks and files of compressed formats keep their size, the others are
+// compressed with the configured codec and level. Links and special files
+// are not read.
+func (a *Archiver) sampleCompression(info FileInfo, chunkSize int64) (compressionSample, error) {
+	sample := compressionSample{ratio: 1}
+	if specialHeader(info) != nil {
+		return sample, nil
+	}
+
+	start := time.Now()
+	file, err := a.openSource(info)
+	if err != nil {
+		return sample, err
+	}
+	defer file.Close()
+	chunk, err := readChunk(file, info.Size, chunkSize)
+	if err != nil {
+		return sample, err
+	}
+	sample.sampled = int64(len(chunk))
+	sample.readTime = time.Since(start)
+
+	layout := a.layout()
+	codec := layout.codec
+	if layout.format == FormatZip {
+		// The store policy samples the start of the file, as Create does
+		if a.storeEntry(entryName(info), sampler(file)) {
+			sample.stored = true
+			return sample, nil
+		}
+		codec = CodecDeflate
+	}
+	if codec == CodecNone || isCompressedFormat(info.Path) || len(chunk) == 0 {
+		return sample, nil
+	}
+
+	start = time.Now()
+	n, err := compressedSize(chunk, codec, a.compressionLevel())
+	if err != nil {
+		return sample, err
+	}
+	sample.compressTime = time.Since(start)
+	if ratio := float64(n) / float64(len(chunk)); ratio < 1 {
+		sample.ratio = ratio
+	}
+	return sample, nil
+}
+
+// readChunk reads up to chunkSize bytes from the middle of a file of size
+// bytes, or from its start when it cannot be read at random
+func readChunk(file fs.File, size, chunkSize int64) ([]byte, error) {
+	length := size
+	if length > chunkSize {
+		length = chunkSize
+	}
+	if length < 0 {
+		length = 0
+	}
+	chunk := make([]byte, length)
+	var (
+		n   int
+		err error
+	)
+	if ra := sampler(file); ra != nil {
+		n, err = ra.ReadAt(chunk, (size-length)/2)
+	} else {
+		n, err = io.ReadFull(file, chunk)
+	}
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	return chunk[:n], nil
+}
+
+// compressedSize compresses chunk with codec at level and returns the
+// compressed size
+func compressedSize(chunk []byte, codec Codec, level CompressionLevel) (int64, error) {
+	c, err := compressorFor(codec)
+	if err != nil {
+		return 0, err
+	}
+	counter := &countingWriter{w: io.Discard}
+	cw, err := c.NewWriter(counter, level)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := cw.Write(chunk); err != nil {
+		return 0, err
+	}
+	if err := cw.Close(); err != nil {
+		return 0, err
+	}
+	return counter.n, nil
+}
@@ -0,0 +1,103 @@
+package archiver
+
+import (
+	"crypto/rand"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEstimate(t *testing.T) {
+	source := t.TempDir()
+	for i := 0; i < 12; i++ {
+		text := strings.Repeat(fmt.Sprintf("line %d of a log file\n", i), 2000*(i+1))
+		writeSourceFile(t, filepath.Join(source, fmt.Sprintf("log%02d.txt", i)), text, time.Now())
+	}
+	for i := 0; i < 3; i++ {
+		noise := make([]byte, 64*1024)
+		rand.Read(noise)
+		writeSourceFile(t, filepath.Join(source, fmt.Sprintf("photo%d.jpg", i)), string(noise), time.Now())
+	}
+
+	config := Config{
+		SourcePath: source,
+		OutputPath: filepath.Join(t.TempDir(), "out.tar.gz"),
+		FilterMode: FilterAll,
+	}
+	estimate, err := New(config).Estimate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if estimate.Files != 15 {
+		t.Errorf("Expected 15 files, got %d", estimate.Files)
+	}
+	if photos := estimate.Categories[CategoryPhotos]; photos.Files != 3 || photos.Ratio < 0.95 {
+		t.Errorf("Expected random photos not to compress, got %+v", photos)
+	}
+	if others := estimate.Categories[CategoryOthers]; others.Files != 12 || others.Ratio > 0.2 {
+		t.Errorf("Expected logs to compress well, got %+v", others)
+	}
+	if estimate.ReadThroughput <= 0 || estimate.Duration <= 0 {
+		t.Errorf("Expected a measured throughput and runtime, got %v and %v", estimate.ReadThroughput, estimate.Duration)
+	}
+
+	// The prediction is close to the archive actually written
+	createArchive(t, config)
+	stat, err := os.Stat(config.OutputPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ratio := float64(estimate.OutputSize) / float64(stat.Size()); ratio < 0.5 || ratio > 2 {
+		t.Errorf("Expected about %d bytes, estimated %d", stat.Size(), estimate.OutputSize)
+	}
+
+	// Uncompressed output is the input plus the container
+	config.OutputPath = filepath.Join(t.TempDir(), "out.tar")
+	estimate, err = New(config).Estimate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if estimate.OutputSize < estimate.InputSize {
+		t.Errorf("Expected plain tar to be larger than its input, got %d of %d", estimate.OutputSize, estimate.InputSize)
+	}
+}
+
+func TestEstimateMatchesPlan(t *testing.T) {
+	source := t.TempDir()
+	text := strings.Repeat("compressible text ", 5000)
+	// Media extensions are not compressed, whatever their content
+	writeSourceFile(t, filepath.Join(source, "scan.jpg"), text, time.Now())
+	writeSourceFile(t, filepath.Join(source, "notes.txt"), text, time.Now())
+
+	for _, output := range []string{"out.tar.gz", "out.tar", "out.zip"} {
+		config := Config{
+			SourcePath:        source,
+			OutputPath:        filepath.Join(t.TempDir(), output),
+			FilterMode:        FilterAll,
+			CompressionPolicy: PolicyAuto,
+		}
+		a := New(config)
+		plan, err := a.Plan()
+		if err != nil {
+			t.Fatal(err)
+		}
+		estimate, err := a.Estimate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, entry := range plan.Entries {
+			planned := float64(entry.EstimatedSize) / float64(entry.Size)
+			estimated := estimate.Categories[entry.Category].Ratio
+			if diff := planned - estimated; diff > 0.01 || diff < -0.01 {
+				t.Errorf("%s: Expected the same ratio for %s, planned %.3f and estimated %.3f",
+					output, entry.Name, planned, estimated)
+			}
+		}
+		if photos := estimate.Categories[CategoryPhotos]; photos.Ratio != 1 {
+			t.Errorf("%s: Expected the photo to keep its size, got %+v", output, photos)
+		}
+	}
+}
@@ -0,0 +1,215 @@
+package archiver
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrUnsafePath is returned for entries that would be written outside the
+// extraction directory
+var ErrUnsafePath = errors.New("entry path escapes extraction directory")
+
+// ExtractResult represents the result of extracting a single entry
+type ExtractResult struct {
+	Path  string
+	Size  int64
+	Error error
+}
+
+// Extract restores the archive at OutputPath into dest. When TrustedKeys are
+// configured the archive signature is verified first and nothing is
+// extracted unless it checks out.
+func (a *Archiver) Extract(dest string) <-chan ExtractResult {
+	out := make(chan ExtractResult)
+
+	go func() {
+		defer close(out)
+
+		if len(a.config.TrustedKeys) > 0 {
+			if err := a.Verify(); err != nil {
+				out <- ExtractResult{Error: err}
+				return
+			}
+		}
+
+		tr, err := a.openArchive(a.config.OutputPath)
+		if err != nil {
+			out <- ExtractResult{Error: err}
+			return
+		}
+		defer tr.Close()
+
+		for {
+			header, err := tr.Next()
+			if err == io.EOF {
+				return
+			}
+			if err != nil {
+				out <- ExtractResult{Error: err}
+				return
+			}
+			if isMetaEntry(header.Name) {
+				continue
+			}
+
+			err = extractEntry(tr, header, dest)
+			out <- ExtractResult{
+				Path:  header.Name,
+				Size:  header.Size,
+				Error: err,
+			}
+		}
+	}()
+
+	return out
+}
+
+// extractEntry writes a single tar entry below dest
+func extractEntry(r io.Reader, header *tar.Header, dest string) error {
+	target, err := safeJoin(dest, header.Name)
+	if err != nil {
+		return err
+	}
+
+	switch header.Typeflag {
+	case tar.TypeDir:
+		return os.MkdirAll(target, 0755)
+	case tar.TypeLink:
+		return extractLink(header, target, dest)
+	case tar.TypeSymlink:
+		return extractSymlink(header, target, dest)
+	case tar.TypeReg:
+	default:
+		// FIFOs and device nodes are not recreated
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+
+	if err := removeSymlink(target); err != nil {
+		return err
+	}
+
+	mode := os.FileMode(header.Mode).Perm()
+	if mode == 0 {
+		mode = 0644
+	}
+	f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Chtimes(target, header.ModTime, header.ModTime)
+}
+
+// extractLink recreates a hard link to an entry extracted earlier, falling
+// back to a copy where the file system has no hard links
+func extractLink(header *tar.Header, target, dest string) error {
+	source, err := safeJoin(dest, header.Linkname)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	if err := os.Remove(target); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if os.Link(source, target) == nil {
+		return nil
+	}
+
+	// The copy must not read through a link to outside dest
+	if info, err := os.Lstat(source); err == nil && info.Mode()&os.ModeSymlink != 0 {
+		return fmt.Errorf("%w: %s -> %s", ErrUnsafePath, header.Name, header.Linkname)
+	}
+	src, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	copied := *header
+	copied.Typeflag = tar.TypeReg
+	return extractEntry(src, &copied, dest)
+}
+
+// safeJoin joins an entry name to dest, rejecting names that escape it,
+// either as written or through a symbolic link extracted earlier
+func safeJoin(dest, name string) (string, error) {
+	target := filepath.Join(dest, filepath.FromSlash(name))
+	rel, err := filepath.Rel(dest, target)
+	if err != nil || escapes(rel) {
+		return "", fmt.Errorf("%w: %s", ErrUnsafePath, name)
+	}
+	if err := checkParents(dest, rel); err != nil {
+		return "", err
+	}
+	return target, nil
+}
+
+// escapes reports whether a relative path leaves its base directory
+func escapes(rel string) bool {
+	return rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// within reports whether path, with every link resolved, stays below dest
+func within(dest, path string) (bool, error) {
+	root, err := filepath.EvalSymlinks(dest)
+	if err != nil {
+		return false, err
+	}
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return false, err
+	}
+	rel, err := filepath.Rel(root, resolved)
+	return err == nil && !escapes(rel), nil
+}
+
+// checkParents rejects a path below dest whose parent directories include a
+// symbolic link that resolves outside dest, or does not resolve at all
+func checkParents(dest, rel string) error {
+	dir := dest
+	parts := strings.Split(rel, string(filepath.Separator))
+	for _, part := range parts[:len(parts)-1] {
+		dir = filepath.Join(dir, part)
+		info, err := os.Lstat(dir)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink == 0 {
+			continue
+		}
+		if ok, err := within(dest, dir); err != nil || !ok {
+			return fmt.Errorf("%w: %s", ErrUnsafePath, filepath.ToSlash(rel))
+		}
+	}
+	return nil
+}
+
+// removeSymlink removes target if it is a symbolic link, so that a file is
+// never written through a link that already exists
+func removeSymlink(target string) error {
+	info, err := os.Lstat(target)
+	if err != nil || info.Mode()&os.ModeSymlink == 0 {
+		return nil
+	}
+	return os.Remove(target)
+}
@@ -30,14 +30,16 @@ func (a *Archiver) Filter(results <-chan ScanResult) <-chan FilterResult {
 				ext = ext[1:] // Remove the dot
 			}
 
-			// Check if file should be included based on filter mode
+			// Check if file should be included based on the filter mode of
+			// its source root
+			filterMode, fileTypes := a.filterFor(result.FileInfo)
 			include := false
-			switch a.config.FilterMode {
+			switch filterMode {
 			case FilterAll:
 				include = true
-				if len(a.config.FileTypes) > 0 {
+				if len(fileTypes) > 0 {
 					include = false
-					for _, allowedType := range a.config.FileTypes {
+					for _, allowedType := range fileTypes {
 						if ext == allowedType {
 							include = true
 							break
@@ -75,3 +77,26 @@ func isVideoFile(ext string) bool {
 	}
 	return videoExts[ext]
 }
+
+// Category names used when routing files to separate archives
+const (
+	CategoryPhotos = "photos"
+	CategoryVideos = "videos"
+	CategoryOthers = "others"
+)
+
+// fileCategory classifies a file the same way the filter modes do
+func fileCategory(path string) string {
+	ext := strings.ToLower(filepath.Ext(path))
+	if len(ext) > 0 {
+		ext = ext[1:]
+	}
+	switch {
+	case isPhotoFile(ext):
+		return CategoryPhotos
+	case isVideoFile(ext):
+		return CategoryVideos
+	default:
+		return CategoryOthers
+	}
+}
@@ -0,0 +1,381 @@
+package archiver
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// ErrNotSeekable is returned when Config.Seekable is combined with a layout
+// that cannot be read at random: anything but unencrypted, single-file gzip
+// tar archives.
+var ErrNotSeekable = errors.New("archive layout cannot be seekable")
+
+const (
+	indexEntry = metaPrefix + "index.json"
+
+	// The locator is an empty gzip member closing a seekable archive. Its
+	// extra field holds the offset of the member with the index entry:
+	// header(10) + XLEN(2) + subfield(4+8) + empty deflate block(2) + CRC
+	// and size(8).
+	locatorSize = 34
+	locatorID   = "GI"
+)
+
+// locatorPrefix is the fixed start of a locator member, up to the offset
+var locatorPrefix = []byte{
+	0x1f, 0x8b, 8, 0x04, 0, 0, 0, 0, 0, 0xff, // gzip header with FEXTRA
+	12, 0, // XLEN
+	locatorID[0], locatorID[1], 8, 0, // subfield id and length
+}
+
+// IndexEntry describes an entry in the index of a seekable archive or in a
+// cached listing
+type IndexEntry struct {
+	Name     string    `json:"name"`
+	Typeflag byte      `json:"type"`
+	Linkname string    `json:"link,omitempty"`
+	Size     int64     `json:"size"`
+	Mode     int64     `json:"mode"`
+	ModTime  time.Time `json:"mtime"`
+	// Offset is where the gzip member holding the entry starts, or where
+	// the data of a zip entry starts; -1 when it cannot be addressed
+	Offset int64 `json:"offset"`
+}
+
+func (e IndexEntry) header() *tar.Header {
+	return &tar.Header{
+		Name:     e.Name,
+		Typeflag: e.Typeflag,
+		Linkname: e.Linkname,
+		Size:     e.Size,
+		Mode:     e.Mode,
+		ModTime:  e.ModTime,
+	}
+}
+
+// archiveIndex is the trailing index of a seekable archive
+type archiveIndex struct {
+	Entries []IndexEntry `json:"entries"`
+}
+
+// countingWriter tracks the number of bytes written through it
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// seekableGzipWriter compresses the archive as a series of gzip members
+// that can each be decompressed on their own. Plain gzip readers see a
+// single multi-member stream.
+type seekableGzipWriter struct {
+	w           *countingWriter
+	gz          *gzip.Writer
+	dirty       bool // data written since the member started
+	indexOffset int64
+}
+
+func newSeekableGzipWriter(w io.Writer, level CompressionLevel) (*seekableGzipWriter, error) {
+	cw := &countingWriter{w: w}
+	gz, err := gzip.NewWriterLevel(cw, level.flateLevel())
+	if err != nil {
+		return nil, err
+	}
+	return &seekableGzipWriter{w: cw, gz: gz}, nil
+}
+
+func (s *seekableGzipWriter) Write(p []byte) (int, error) {
+	s.dirty = true
+	return s.gz.Write(p)
+}
+
+// cut ends the current member and returns the offset of the next one
+func (s *seekableGzipWriter) cut() (int64, error) {
+	if s.dirty {
+		if err := s.gz.Close(); err != nil {
+			return 0, err
+		}
+		s.gz.Reset(s.w)
+		s.dirty = false
+	}
+	return s.w.n, nil
+}
+
+// Close ends the last member and appends the locator. It does not close
+// the underlying writer.
+func (s *seekableGzipWriter) Close() error {
+	if err := s.gz.Close(); err != nil {
+		return err
+	}
+	locator := binary.LittleEndian.AppendUint64(append([]byte{}, locatorPrefix...), uint64(s.indexOffset))
+	locator = append(locator, 0x03, 0, 0, 0, 0, 0, 0, 0, 0, 0)
+	_, err := s.w.Write(locator)
+	return err
+}
+
+// indexWriter starts every tar entry in a new gzip member and records where
+// it starts. On Close the index is written as the last entry.
+type indexWriter struct {
+	tw      *tar.Writer
+	gz      *seekableGzipWriter
+	index   archiveIndex
+	modTime time.Time // of the index entry
+}
+
+func (w *indexWriter) WriteHeader(hdr *tar.Header) error {
+	// Flush the padding of the previous entry into its member
+	if err := w.tw.Flush(); err != nil {
+		return err
+	}
+	offset, err := w.gz.cut()
+	if err != nil {
+		return err
+	}
+	if !isMetaEntry(hdr.Name) {
+		w.index.Entries = append(w.index.Entries, indexEntryFor(hdr, offset))
+	}
+	return w.tw.WriteHeader(hdr)
+}
+
+func (w *indexWriter) Write(p []byte) (int, error) {
+	return w.tw.Write(p)
+}
+
+// Close writes the index entry and the end of the tar stream
+func (w *indexWriter) Close() error {
+	data, err := json.Marshal(w.index)
+	if err != nil {
+		return err
+	}
+	if err := w.tw.Flush(); err != nil {
+		return err
+	}
+	if w.gz.indexOffset, err = w.gz.cut(); err != nil {
+		return err
+	}
+	if err := w.tw.WriteHeader(&tar.Header{
+		Name:    indexEntry,
+		Size:    int64(len(data)),
+		Mode:    0644,
+		ModTime: w.modTime,
+	}); err != nil {
+		return err
+	}
+	if _, err := w.tw.Write(data); err != nil {
+		return err
+	}
+	return w.tw.Close()
+}
+
+// newSeekableWriter layers a seekable gzip tar writer over w
+func newSeekableWriter(w io.Writer, compression CompressionLevel, modTime time.Time) (*indexWriter, *seekableGzipWriter, error) {
+	gz, err := newSeekableGzipWriter(w, compression)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &indexWriter{tw: tar.NewWriter(gz), gz: gz, modTime: modTime}, gz, nil
+}
+
+// readLocator returns the offset of the index member if f ends with a
+// locator
+func readLocator(f io.ReaderAt, size int64) (int64, bool) {
+	if size < locatorSize {
+		return 0, false
+	}
+	buf := make([]byte, locatorSize)
+	if _, err := f.ReadAt(buf, size-locatorSize); err != nil {
+		return 0, false
+	}
+	if !bytes.HasPrefix(buf, locatorPrefix) {
+		return 0, false
+	}
+	offset := int64(binary.LittleEndian.Uint64(buf[len(locatorPrefix):]))
+	if offset < 0 || offset >= size {
+		return 0, false
+	}
+	return offset, true
+}
+
+// readIndex loads the index of the seekable archive at path. It returns nil
+// without an error for archives that have no index.
+func readIndex(path string) (*archiveIndex, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			// Volume sets have no index
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	offset, ok := readLocator(f, info.Size())
+	if !ok {
+		return nil, nil
+	}
+
+	tr, closer, err := openMember(f, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer closer.Close()
+
+	hdr, err := tr.Next()
+	if err != nil {
+		return nil, err
+	}
+	if hdr.Name != indexEntry {
+		return nil, fmt.Errorf("%w: index member holds %s", ErrUnknownFormat, hdr.Name)
+	}
+	var index archiveIndex
+	if err := json.NewDecoder(tr).Decode(&index); err != nil {
+		return nil, err
+	}
+	return &index, nil
+}
+
+// openMember returns a tar reader over the single gzip member at offset
+func openMember(f io.ReaderAt, offset int64) (*tar.Reader, io.Closer, error) {
+	zr, err := gzip.NewReader(io.NewSectionReader(f, offset, 1<<62))
+	if err != nil {
+		return nil, nil, err
+	}
+	zr.Multistream(false)
+	return tar.NewReader(zr), zr, nil
+}
+
+// lookup returns the index entry for name
+func (idx *archiveIndex) lookup(name string) (IndexEntry, bool) {
+	for _, entry := range idx.Entries {
+		if entry.Name == name {
+			return entry, true
+		}
+	}
+	return IndexEntry{}, false
+}
+
+// entryFile is an entry opened for reading together with the file it is
+// read from
+type entryFile struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (e *entryFile) Close() error {
+	var first error
+	for i := len(e.closers) - 1; i >= 0; i-- {
+		if err := e.closers[i].Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// OpenEntry opens a single entry of the archive at OutputPath. Seekable
+// archives jump straight to the entry; other archives are read up to it.
+// Hard links are resolved to their target. When TrustedKeys are configured
+// the archive signature is verified first, as Extract does.
+func (a *Archiver) OpenEntry(name string) (io.ReadCloser, *tar.Header, error) {
+	if len(a.config.TrustedKeys) > 0 {
+		if err := a.Verify(); err != nil {
+			return nil, nil, err
+		}
+	}
+	return a.openLinked(name)
+}
+
+// openLinked opens an entry without verifying the archive, following hard
+// links to their target
+func (a *Archiver) openLinked(name string) (io.ReadCloser, *tar.Header, error) {
+	for hops := 0; hops < 8; hops++ {
+		rc, hdr, err := a.openEntry(name)
+		if err != nil || hdr.Typeflag != tar.TypeLink {
+			return rc, hdr, err
+		}
+		rc.Close()
+		name = hdr.Linkname
+	}
+	return nil, nil, fmt.Errorf("%w: too many links", ErrFileNotFound)
+}
+
+func (a *Archiver) openEntry(name string) (io.ReadCloser, *tar.Header, error) {
+	index, err := readIndex(a.config.OutputPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	if index != nil {
+		entry, ok := index.lookup(name)
+		if !ok {
+			return nil, nil, ErrFileNotFound
+		}
+		f, err := os.Open(a.config.OutputPath)
+		if err != nil {
+			return nil, nil, err
+		}
+		tr, zr, err := openMember(f, entry.Offset)
+		if err != nil {
+			f.Close()
+			return nil, nil, err
+		}
+		hdr, err := tr.Next()
+		if err == nil && hdr.Name != name {
+			err = fmt.Errorf("%w: index is out of date", ErrUnknownFormat)
+		}
+		if err != nil {
+			zr.Close()
+			f.Close()
+			return nil, nil, err
+		}
+		return &entryFile{Reader: tr, closers: []io.Closer{f, zr}}, hdr, nil
+	}
+
+	ar, err := a.openArchive(a.config.OutputPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	for {
+		hdr, err := ar.Next()
+		if err == io.EOF {
+			ar.Close()
+			return nil, nil, ErrFileNotFound
+		}
+		if err != nil {
+			ar.Close()
+			return nil, nil, err
+		}
+		if hdr.Name == name {
+			return &entryFile{Reader: ar, closers: []io.Closer{ar}}, hdr, nil
+		}
+	}
+}
+
+// ExtractFile restores a single entry of the archive into dest
+func (a *Archiver) ExtractFile(name, dest string) error {
+	rc, hdr, err := a.OpenEntry(name)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	// Links are extracted as a copy of their target under their own name
+	copied := *hdr
+	copied.Name = name
+	return extractEntry(rc, &copied, dest)
+}
@@ -0,0 +1,259 @@
+package archiver
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSeekableArchive(t *testing.T) {
+	outputPath := filepath.Join(t.TempDir(), "seekable.tar.gz")
+	a := createArchive(t, Config{
+		SourcePath: "testdata/source",
+		OutputPath: outputPath,
+		Recursive:  true,
+		FilterMode: FilterAll,
+		Modifiable: true,
+		Seekable:   true,
+	})
+
+	index, err := readIndex(outputPath)
+	if err != nil || index == nil {
+		t.Fatalf("Expected an index, got %v", err)
+	}
+	if len(index.Entries) != 7 {
+		t.Errorf("Expected 7 indexed entries, got %d", len(index.Entries))
+	}
+
+	// The archive is still a plain gzip stream for other tools
+	f, err := os.Open(outputPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.Copy(io.Discard, zr); err != nil {
+		t.Errorf("Expected a valid multi-member gzip stream: %v", err)
+	}
+	f.Close()
+
+	entry, err := a.GetFileInfo("photo1.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.Offset <= 0 {
+		t.Errorf("Expected an offset for photo1.jpg, got %d", entry.Offset)
+	}
+
+	rc, _, err := a.OpenEntry("doc1.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(rc)
+	rc.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := os.ReadFile("testdata/source/documents/doc1.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(want) {
+		t.Errorf("Expected doc1.txt content %q, got %q", want, got)
+	}
+
+	dest := t.TempDir()
+	if err := a.ExtractFile("video1.mp4", dest); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dest, "video1.mp4")); err != nil {
+		t.Errorf("Expected extracted file: %v", err)
+	}
+
+	// A rewrite keeps the archive seekable with a fresh index
+	for result := range a.Modify([]ModifyRequest{{Operation: OperationRemove, Path: "doc1.txt"}}, CompressionDefault) {
+		if result.Error != nil {
+			t.Fatal(result.Error)
+		}
+	}
+	index, err = readIndex(outputPath)
+	if err != nil || index == nil {
+		t.Fatalf("Expected an index after Modify, got %v", err)
+	}
+	if _, ok := index.lookup("doc1.txt"); ok || len(index.Entries) != 6 {
+		t.Errorf("Expected doc1.txt to be dropped from the index, got %+v", index.Entries)
+	}
+	if _, _, err := a.OpenEntry("doc1.txt"); !errors.Is(err, ErrFileNotFound) {
+		t.Errorf("Expected ErrFileNotFound, got %v", err)
+	}
+}
+
+func TestSeekableLayouts(t *testing.T) {
+	_, recipient, err := GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, config := range []Config{
+		{OutputPath: "out.zip"},
+		{OutputPath: "out.tar.gz", Codec: CodecZlib},
+		{OutputPath: "out.tar.gz", Recipients: []string{recipient}},
+		{OutputPath: "out.tar.gz", VolumeSize: 1 << 20},
+	} {
+		config.SourcePath = "testdata/source"
+		config.OutputPath = filepath.Join(t.TempDir(), config.OutputPath)
+		config.FilterMode = FilterAll
+		config.Seekable = true
+
+		a := New(config)
+		scanResults, err := a.Scan()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for result := range a.Create(a.Filter(scanResults)) {
+			if !errors.Is(result.Error, ErrNotSeekable) {
+				t.Errorf("Expected ErrNotSeekable for %s, got %v", filepath.Base(config.OutputPath), result.Error)
+			}
+		}
+	}
+
+	// Zip entries can be addressed without an index
+	a := createArchive(t, Config{
+		SourcePath: "testdata/source",
+		OutputPath: filepath.Join(t.TempDir(), "photos.zip"),
+		Recursive:  true,
+		FilterMode: FilterAll,
+	})
+	entry, err := a.GetFileInfo("photo1.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.Offset <= 0 {
+		t.Errorf("Expected a data offset for zip entries, got %d", entry.Offset)
+	}
+}
+
+func TestCachedListing(t *testing.T) {
+	outputPath := filepath.Join(t.TempDir(), "cached.tar.gz")
+	config := Config{
+		SourcePath: "testdata/source",
+		OutputPath: outputPath,
+		Recursive:  true,
+		FilterMode: FilterAll,
+		Modifiable: true,
+	}
+	a := createArchive(t, config)
+
+	files, err := a.ListFiles()
+	if err != nil || len(files) != 7 {
+		t.Fatalf("Expected 7 files, got %v (%v)", files, err)
+	}
+	sidecar := outputPath + indexCacheExtension
+	data, err := os.ReadFile(sidecar)
+	if err != nil {
+		t.Fatalf("Expected a cache sidecar: %v", err)
+	}
+
+	// A fresh Archiver trusts the sidecar while the key matches
+	var cached cachedIndex
+	if err := json.Unmarshal(data, &cached); err != nil {
+		t.Fatal(err)
+	}
+	cached.Entries = []IndexEntry{{Name: "from-cache.txt", Offset: -1}}
+	data, err = json.Marshal(cached)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(sidecar, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	b := New(config)
+	if files, err := b.ListFiles(); err != nil || len(files) != 1 || files[0] != "from-cache.txt" {
+		t.Errorf("Expected the cached listing, got %v (%v)", files, err)
+	}
+
+	// Modify updates the cache to match the rewritten archive
+	for result := range b.Modify([]ModifyRequest{{Operation: OperationRemove, Path: "doc1.txt"}}, CompressionDefault) {
+		if result.Error != nil {
+			t.Fatal(result.Error)
+		}
+	}
+	key, err := archiveKeyFor(outputPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.index == nil || b.index.Key != key || len(b.index.Entries) != 6 {
+		t.Errorf("Expected Modify to refresh the cache, got %+v", b.index)
+	}
+	if files, err := New(config).ListFiles(); err != nil || len(files) != 6 {
+		t.Errorf("Expected 6 files after Modify, got %v (%v)", files, err)
+	}
+
+	// Rewriting the archive invalidates the cache through its key
+	createArchive(t, config)
+	if files, err := b.ListFiles(); err != nil || len(files) != 7 {
+		t.Errorf("Expected a stale cache to be rebuilt, got %v (%v)", files, err)
+	}
+}
+
+func TestListFilesPage(t *testing.T) {
+	a := createArchive(t, Config{
+		SourcePath: "testdata/source",
+		OutputPath: filepath.Join(t.TempDir(), "paged.tar.gz"),
+		Recursive:  true,
+		FilterMode: FilterAll,
+	})
+
+	names := func(page ListPage) []string {
+		var out []string
+		for _, entry := range page.Entries {
+			out = append(out, entry.Header.Name)
+		}
+		return out
+	}
+
+	page, err := a.ListFilesPage(ListOptions{Offset: 2, Limit: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := names(page); page.Total != 7 || !reflect.DeepEqual(got, []string{"photo1.jpg", "photo2.png", "photo3.webp"}) {
+		t.Errorf("Unexpected page %v of %d", got, page.Total)
+	}
+
+	page, err = a.ListFilesPage(ListOptions{Prefix: "photo", Pattern: "*.jpg"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := names(page); !reflect.DeepEqual(got, []string{"photo1.jpg"}) {
+		t.Errorf("Expected filtered listing, got %v", got)
+	}
+
+	page, err = a.ListFilesPage(ListOptions{SortBy: SortBySize, Descending: true, Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := a.scanTarball()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range info.Files {
+		if entry.Size > page.Entries[0].Size {
+			t.Errorf("Expected the largest entry first, %s is larger than %s", entry.Header.Name, page.Entries[0].Header.Name)
+		}
+	}
+
+	if _, err := a.ListFilesPage(ListOptions{Offset: 100}); err != nil {
+		t.Errorf("Expected an empty page past the end, got %v", err)
+	}
+	page, err = a.ListFilesPage(ListOptions{Offset: -1, Limit: 1})
+	if err != nil || len(page.Entries) != 1 {
+		t.Errorf("Expected a negative offset to start at the first entry, got %+v (%v)", page.Entries, err)
+	}
+}
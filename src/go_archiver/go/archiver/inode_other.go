@@ -0,0 +1,22 @@
+//go:build !unix
+
+package archiver
+
+import "os"
+
+// fileInode returns zero; inode numbers are not available on this platform
+func fileInode(info os.FileInfo) uint64 {
+	return 0
+}
+
+// fileDevice returns zeros; device numbers are not available on this
+// platform
+func fileDevice(info os.FileInfo) (dev, rdev uint64) {
+	return 0, 0
+}
+
+// deviceNumbers returns zeros; device nodes are not scanned on this
+// platform
+func deviceNumbers(rdev uint64) (major, minor int64) {
+	return 0, 0
+}
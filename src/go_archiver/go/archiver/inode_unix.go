@@ -0,0 +1,38 @@
+//go:build unix
+
+package archiver
+
+import (
+	"os"
+	"runtime"
+	"syscall"
+)
+
+// fileInode returns the inode number of a file, or zero if unknown
+func fileInode(info os.FileInfo) uint64 {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		return uint64(st.Ino)
+	}
+	return 0
+}
+
+// fileDevice returns the device a file lives on and, for device nodes, the
+// device it represents; zero if unknown
+func fileDevice(info os.FileInfo) (dev, rdev uint64) {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		return uint64(st.Dev), uint64(st.Rdev)
+	}
+	return 0, 0
+}
+
+// deviceNumbers splits a device number into its major and minor parts
+func deviceNumbers(rdev uint64) (major, minor int64) {
+	switch runtime.GOOS {
+	case "darwin", "ios", "openbsd", "netbsd":
+		return int64(rdev >> 24 & 0xff), int64(rdev & 0xffffff)
+	}
+	// The glibc encoding used by Linux
+	major = int64(rdev>>8&0xfff | rdev>>32&^0xfff)
+	minor = int64(rdev&0xff | rdev>>12&^0xff)
+	return major, minor
+}
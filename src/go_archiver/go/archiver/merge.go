@@ -0,0 +1,375 @@
+package archiver
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// ConflictPolicy decides which entry Merge keeps when several input
+// archives contain the same path
+type ConflictPolicy string
+
+const (
+	ConflictFirst    ConflictPolicy = "first"     // Entry from the earliest input
+	ConflictNewest   ConflictPolicy = "newest"    // Latest modification time
+	ConflictLargest  ConflictPolicy = "largest"   // Largest size
+	ConflictKeepBoth ConflictPolicy = "keep-both" // Later entries get a numbered suffix
+)
+
+// ErrUnknownPolicy is returned for a conflict, symlink or special file
+// policy the archiver does not know
+var ErrUnknownPolicy = errors.New("unknown policy")
+
+// MergeResult represents the result of merging archives
+type MergeResult struct {
+	FilesProcessed int64
+	TotalSize      int64
+	Conflicts      int64 // Paths found in more than one input
+	Deduplicated   int64 // Entries stored as links to identical content
+	Error          error
+}
+
+// mergeCandidate is an entry of an input archive
+type mergeCandidate struct {
+	input   int
+	ordinal int // position among the entries of the input
+	header  *tar.Header
+	sha256  string
+	size    int64 // content size, also for hard links
+	outName string
+
+	inconsistent bool // marked in the stored manifest of the input
+}
+
+// Merge streams the input archives into a new archive at OutputPath, which
+// is written with the same container, codec, encryption and signing as
+// Create. Duplicate paths are resolved with policy. With dedup, entries
+// whose content was already written are stored as hard links; zip output
+// has no links and keeps full copies.
+func (a *Archiver) Merge(inputs []string, policy ConflictPolicy, dedup bool) <-chan MergeResult {
+	out := make(chan MergeResult)
+
+	go func() {
+		defer close(out)
+
+		// First pass: index and hash every entry so conflicts can be
+		// resolved before anything is written
+		selected, conflicts, err := a.planMerge(inputs, policy)
+		if err != nil {
+			out <- MergeResult{Error: err}
+			return
+		}
+
+		f, err := createOutput(a.config.OutputPath, a.config.VolumeSize)
+		if err != nil {
+			out <- MergeResult{Error: err}
+			return
+		}
+		defer f.Close()
+
+		aw, err := a.newArchiveWriter(f, a.compressionLevel(), nil)
+		if err != nil {
+			out <- MergeResult{Error: err}
+			return
+		}
+
+		m := &merger{
+			a:        a,
+			tw:       aw.entryWriter,
+			links:    a.layout().format != FormatZip,
+			dedup:    dedup,
+			written:  make(map[string]string),
+			result:   MergeResult{Conflicts: conflicts},
+			selected: selected,
+		}
+
+		// Second pass: copy the selected entries, one stream per input
+		for i, input := range inputs {
+			if err := m.copyInput(i, input); err != nil {
+				out <- MergeResult{Error: err}
+				return
+			}
+		}
+
+		if err := a.finishArchive(f, aw, m.manifest, nil); err != nil {
+			out <- MergeResult{Error: err}
+			return
+		}
+		out <- m.result
+	}()
+
+	return out
+}
+
+// planMerge resolves conflicts between the inputs and returns the selected
+// entries keyed by input and ordinal
+func (a *Archiver) planMerge(inputs []string, policy ConflictPolicy) (map[[2]int]*mergeCandidate, int64, error) {
+	switch policy {
+	case ConflictFirst, ConflictNewest, ConflictLargest, ConflictKeepBoth:
+	default:
+		return nil, 0, fmt.Errorf("%w: conflicts %s", ErrUnknownPolicy, policy)
+	}
+
+	var (
+		order  []string // names in order of first appearance
+		byName = make(map[string][]*mergeCandidate)
+	)
+	for i, input := range inputs {
+		candidates, err := a.indexInput(i, input)
+		if err != nil {
+			return nil, 0, err
+		}
+		for _, c := range candidates {
+			if _, ok := byName[c.header.Name]; !ok {
+				order = append(order, c.header.Name)
+			}
+			byName[c.header.Name] = append(byName[c.header.Name], c)
+		}
+	}
+
+	var conflicts int64
+	selected := make(map[[2]int]*mergeCandidate)
+	taken := make(map[string]bool, len(byName))
+	for name := range byName {
+		taken[name] = true
+	}
+	for _, name := range order {
+		candidates := byName[name]
+		if len(candidates) > 1 {
+			conflicts++
+		}
+
+		if policy == ConflictKeepBoth {
+			for n, c := range candidates {
+				c.outName = name
+				if n > 0 {
+					c.outName = suffixedName(name, taken)
+				}
+				selected[[2]int{c.input, c.ordinal}] = c
+			}
+			continue
+		}
+
+		winner := candidates[0]
+		for _, c := range candidates[1:] {
+			switch {
+			case policy == ConflictNewest && c.header.ModTime.After(winner.header.ModTime),
+				policy == ConflictLargest && c.size > winner.size:
+				winner = c
+			}
+		}
+		winner.outName = name
+		selected[[2]int{winner.input, winner.ordinal}] = winner
+	}
+	return selected, conflicts, nil
+}
+
+// suffixedName returns an unused variant of name with a numbered suffix
+// before the extension, such as photo-2.jpg
+func suffixedName(name string, taken map[string]bool) string {
+	ext := filepath.Ext(name)
+	stem := strings.TrimSuffix(name, ext)
+	for n := 2; ; n++ {
+		candidate := fmt.Sprintf("%s-%d%s", stem, n, ext)
+		if !taken[candidate] {
+			taken[candidate] = true
+			return candidate
+		}
+	}
+}
+
+// indexInput hashes the entries of an input archive. Hard links take the
+// size and hash of their target, and entries keep the inconsistent marks of
+// the stored manifest.
+func (a *Archiver) indexInput(input int, path string) ([]*mergeCandidate, error) {
+	tr, err := a.openArchive(path)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	defer tr.Close()
+
+	var (
+		candidates []*mergeCandidate
+		byName     = make(map[string]*mergeCandidate)
+	)
+	for ordinal := 0; ; ordinal++ {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return candidates, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		if header.Name == manifestMetaEntry {
+			var stored Manifest
+			if err := json.NewDecoder(tr).Decode(&stored); err != nil {
+				return nil, fmt.Errorf("%s: %w", path, err)
+			}
+			for _, entry := range stored.Entries {
+				if c, ok := byName[entry.Name]; ok {
+					c.inconsistent = entry.Inconsistent
+				}
+			}
+			continue
+		}
+		if isMetaEntry(header.Name) || header.Typeflag == tar.TypeDir {
+			continue
+		}
+
+		c := &mergeCandidate{input: input, ordinal: ordinal, header: header, size: header.Size}
+		if header.Typeflag == tar.TypeLink {
+			target, ok := byName[header.Linkname]
+			if !ok {
+				return nil, fmt.Errorf("%s: %w: link target %s", path, ErrFileNotFound, header.Linkname)
+			}
+			c.sha256, c.size = target.sha256, target.size
+		} else {
+			h := sha256.New()
+			if _, err := io.Copy(h, tr); err != nil {
+				return nil, fmt.Errorf("%s: %w", path, err)
+			}
+			c.sha256 = hex.EncodeToString(h.Sum(nil))
+		}
+		byName[header.Name] = c
+		candidates = append(candidates, c)
+	}
+}
+
+// merger writes the selected entries of the inputs to the merged archive
+type merger struct {
+	a        *Archiver
+	tw       entryWriter
+	links    bool              // the output container supports hard links
+	dedup    bool              // link all repeated content, not just input links
+	written  map[string]string // content hash -> name written with it
+	selected map[[2]int]*mergeCandidate
+	manifest Manifest
+	result   MergeResult
+}
+
+// copyInput streams one input archive, copying its selected entries
+func (m *merger) copyInput(input int, path string) error {
+	tr, err := m.a.openArchive(path)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	defer tr.Close()
+
+	for ordinal := 0; ; ordinal++ {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		c, ok := m.selected[[2]int{input, ordinal}]
+		if !ok {
+			continue
+		}
+
+		var content io.Reader = tr
+		if header.Typeflag == tar.TypeLink {
+			content = nil
+		}
+		if err := m.writeEntry(c, content, path); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	}
+}
+
+// writeEntry writes a selected entry. content is nil for hard links in the
+// input, whose content is taken from an earlier entry.
+func (m *merger) writeEntry(c *mergeCandidate, content io.Reader, input string) error {
+	header := &tar.Header{
+		Name:     c.outName,
+		Mode:     c.header.Mode,
+		ModTime:  c.header.ModTime,
+		Typeflag: c.header.Typeflag,
+		Linkname: c.header.Linkname,
+		Devmajor: c.header.Devmajor,
+		Devminor: c.header.Devminor,
+		Size:     c.size,
+	}
+	if c.header.Typeflag != tar.TypeReg && c.header.Typeflag != tar.TypeLink {
+		// Links and special files are copied as they are, without content
+		header.Size = 0
+		return m.write(header, nil, c)
+	}
+	header.Typeflag, header.Linkname = tar.TypeReg, ""
+
+	// Only content is deduplicated; empty files stay separate entries
+	target, seen := m.written[c.sha256]
+	switch {
+	case seen && m.links && c.size > 0 && (m.dedup || content == nil):
+		// Identical content is already in the output
+		header.Typeflag = tar.TypeLink
+		header.Linkname = target
+		header.Size = 0
+		content = nil
+		m.result.Deduplicated++
+	case content == nil:
+		// The link target of the input was not written, or the output
+		// cannot hold links; read the content again
+		return m.copyLinkTarget(header, c, input)
+	}
+
+	return m.write(header, content, c)
+}
+
+// copyLinkTarget writes header with the content of the target of the hard
+// link c, read from a fresh stream of the input
+func (m *merger) copyLinkTarget(header *tar.Header, c *mergeCandidate, input string) error {
+	tr, err := m.a.openArchive(input)
+	if err != nil {
+		return err
+	}
+	defer tr.Close()
+
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return fmt.Errorf("%w: link target %s", ErrFileNotFound, c.header.Linkname)
+		}
+		if err != nil {
+			return err
+		}
+		if h.Name == c.header.Linkname && h.Typeflag != tar.TypeLink {
+			return m.write(header, tr, c)
+		}
+	}
+}
+
+// write adds an entry of candidate c to the output and records it in the
+// manifest
+func (m *merger) write(header *tar.Header, content io.Reader, c *mergeCandidate) error {
+	if err := m.a.writeEntryHeader(m.tw, header, nil); err != nil {
+		return err
+	}
+
+	h := sha256.New()
+	if content != nil {
+		if _, err := io.Copy(io.MultiWriter(m.tw, h), content); err != nil {
+			return err
+		}
+	}
+	entry := manifestEntry(header, hex.EncodeToString(h.Sum(nil)))
+	entry.Inconsistent = c.inconsistent
+	m.manifest.Entries = append(m.manifest.Entries, entry)
+
+	if header.Typeflag == tar.TypeReg {
+		if _, ok := m.written[c.sha256]; !ok {
+			m.written[c.sha256] = header.Name
+		}
+		m.result.TotalSize += header.Size
+	}
+	m.result.FilesProcessed++
+	return nil
+}
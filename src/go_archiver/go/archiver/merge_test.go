@@ -0,0 +1,218 @@
+package archiver
+
+import (
+	"archive/tar"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+// mergeInputs creates two archives that both contain shared.txt, with the
+// second copy newer but smaller, and the same content under two names
+func mergeInputs(t *testing.T) []string {
+	t.Helper()
+
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	inputs := make([]string, 2)
+	for i, files := range []map[string]string{
+		{"shared.txt": "laptop version", "photo.jpg": "same bytes"},
+		{"shared.txt": "phone", "copy.jpg": "same bytes"},
+	} {
+		source := t.TempDir()
+		for name, content := range files {
+			writeSourceFile(t, filepath.Join(source, name), content, base.Add(time.Duration(i)*time.Hour))
+		}
+		inputs[i] = filepath.Join(t.TempDir(), "input.tar.gz")
+		createArchive(t, Config{SourcePath: source, OutputPath: inputs[i], FilterMode: FilterAll})
+	}
+	return inputs
+}
+
+// mergedContents extracts an archive and returns the content of each file
+func mergedContents(t *testing.T, a *Archiver) map[string]string {
+	t.Helper()
+
+	dest := t.TempDir()
+	for result := range a.Extract(dest) {
+		if result.Error != nil {
+			t.Fatal(result.Error)
+		}
+	}
+	contents := make(map[string]string)
+	entries, err := os.ReadDir(dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		data, err := os.ReadFile(filepath.Join(dest, entry.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		contents[entry.Name()] = string(data)
+	}
+	return contents
+}
+
+func TestMergeConflictPolicies(t *testing.T) {
+	inputs := mergeInputs(t)
+
+	tests := []struct {
+		policy ConflictPolicy
+		want   map[string]string
+	}{
+		{ConflictFirst, map[string]string{"shared.txt": "laptop version"}},
+		{ConflictNewest, map[string]string{"shared.txt": "phone"}},
+		{ConflictLargest, map[string]string{"shared.txt": "laptop version"}},
+		{ConflictKeepBoth, map[string]string{"shared.txt": "laptop version", "shared-2.txt": "phone"}},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.policy), func(t *testing.T) {
+			a := New(Config{OutputPath: filepath.Join(t.TempDir(), "merged.zip")})
+			for result := range a.Merge(inputs, tt.policy, false) {
+				if result.Error != nil {
+					t.Fatal(result.Error)
+				}
+				if result.Conflicts != 1 {
+					t.Errorf("Expected 1 conflict, got %d", result.Conflicts)
+				}
+			}
+
+			want := map[string]string{"photo.jpg": "same bytes", "copy.jpg": "same bytes"}
+			for name, content := range tt.want {
+				want[name] = content
+			}
+			if got := mergedContents(t, a); !reflect.DeepEqual(got, want) {
+				t.Errorf("Expected %v, got %v", want, got)
+			}
+		})
+	}
+
+	a := New(Config{OutputPath: filepath.Join(t.TempDir(), "merged.tar.gz")})
+	for result := range a.Merge(inputs, "oldest", false) {
+		if !errors.Is(result.Error, ErrUnknownPolicy) {
+			t.Errorf("Expected ErrUnknownPolicy, got %v", result.Error)
+		}
+	}
+}
+
+func TestMergeDedup(t *testing.T) {
+	inputs := mergeInputs(t)
+	output := filepath.Join(t.TempDir(), "merged.tar.gz")
+	private, public, err := GenerateSigningKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a := New(Config{OutputPath: output, SigningKey: private, TrustedKeys: []string{public}})
+	for result := range a.Merge(inputs, ConflictFirst, true) {
+		if result.Error != nil {
+			t.Fatal(result.Error)
+		}
+		if result.Deduplicated != 1 || result.FilesProcessed != 3 {
+			t.Errorf("Expected 3 files with 1 deduplicated, got %+v", result)
+		}
+	}
+
+	// The second copy is stored as a hard link
+	tr, err := a.openArchive(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var links []string
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if header.Typeflag == tar.TypeLink {
+			links = append(links, header.Name+"->"+header.Linkname)
+		}
+	}
+	tr.Close()
+	sort.Strings(links)
+	if !reflect.DeepEqual(links, []string{"copy.jpg->photo.jpg"}) {
+		t.Errorf("Unexpected links: %v", links)
+	}
+
+	if err := a.Verify(); err != nil {
+		t.Errorf("Expected merged archive to verify, got %v", err)
+	}
+	want := map[string]string{"shared.txt": "laptop version", "photo.jpg": "same bytes", "copy.jpg": "same bytes"}
+	if got := mergedContents(t, a); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+}
+
+func TestMergeSpecialEntries(t *testing.T) {
+	input := filepath.Join(t.TempDir(), "input.tar")
+	f, err := os.Create(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tw := tar.NewWriter(f)
+	for _, hdr := range []*tar.Header{
+		{Name: "a.txt", Typeflag: tar.TypeReg, Mode: 0600},
+		{Name: "b.txt", Typeflag: tar.TypeReg, Mode: 0600},
+		{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "a.txt", Mode: 0777},
+		{Name: "pipe", Typeflag: tar.TypeFifo, Mode: 0640},
+		{Name: "null", Typeflag: tar.TypeChar, Devmajor: 1, Devminor: 3, Mode: 0666},
+	} {
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tw.Close()
+	f.Close()
+
+	output := filepath.Join(t.TempDir(), "merged.tar")
+	a := New(Config{OutputPath: output})
+	for result := range a.Merge([]string{input}, ConflictFirst, true) {
+		if result.Error != nil {
+			t.Fatal(result.Error)
+		}
+		if result.Deduplicated != 0 {
+			t.Errorf("Expected empty files not to be deduplicated, got %d", result.Deduplicated)
+		}
+	}
+
+	tr, err := a.openArchive(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tr.Close()
+	got := make(map[string]tar.Header)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !isMetaEntry(header.Name) {
+			got[header.Name] = *header
+		}
+	}
+	for _, name := range []string{"a.txt", "b.txt"} {
+		if got[name].Typeflag != tar.TypeReg || got[name].Mode != 0600 {
+			t.Errorf("Expected %s as a regular file, got %+v", name, got[name])
+		}
+	}
+	if link := got["link"]; link.Typeflag != tar.TypeSymlink || link.Linkname != "a.txt" {
+		t.Errorf("Expected a symlink to a.txt, got %+v", link)
+	}
+	if pipe := got["pipe"]; pipe.Typeflag != tar.TypeFifo || pipe.Mode != 0640 {
+		t.Errorf("Expected a fifo, got %+v", pipe)
+	}
+	if dev := got["null"]; dev.Typeflag != tar.TypeChar || dev.Devmajor != 1 || dev.Devminor != 3 {
+		t.Errorf("Expected a character device 1,3, got %+v", dev)
+	}
+}
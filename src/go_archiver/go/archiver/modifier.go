@@ -3,6 +3,9 @@ package archiver
 import (
 	"archive/tar"
 	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"io"
 	"os"
@@ -43,7 +46,9 @@ type ModifyResult struct {
 	Error     error
 }
 
-// FileEntry represents a file in the tarball
+// FileEntry represents a file in the tarball. Offset is where the entry
+// can be read from in the archive file without decompressing the entries
+// before it, or -1 when the archive has no random access.
 type FileEntry struct {
 	Header  *tar.Header
 	Offset  int64
@@ -57,16 +62,29 @@ type TarballInfo struct {
 	mu    sync.RWMutex
 }
 
-// CompressionLevel represents gzip compression levels
+// CompressionLevel represents gzip compression levels. The zero value is
+// CompressionUnset, which selects the configured or default level.
 type CompressionLevel int
 
 const (
+	CompressionUnset   = CompressionLevel(0)
 	CompressionDefault = CompressionLevel(gzip.DefaultCompression)
 	CompressionBest    = CompressionLevel(gzip.BestCompression)
 	CompressionFast    = CompressionLevel(gzip.BestSpeed)
-	CompressionNone    = CompressionLevel(gzip.NoCompression)
+	CompressionNone    = CompressionLevel(-3) // Entries are stored without compression
 )
 
+// flateLevel returns the level passed to the flate based codecs
+func (l CompressionLevel) flateLevel() int {
+	switch l {
+	case CompressionUnset:
+		return gzip.DefaultCompression
+	case CompressionNone:
+		return gzip.NoCompression
+	}
+	return int(l)
+}
+
 // validateRequest validates a modification request
 func (a *Archiver) validateRequest(req ModifyRequest) error {
 	if !a.config.Modifiable {
@@ -78,7 +96,7 @@ func (a *Archiver) validateRequest(req ModifyRequest) error {
 		if req.FileInfo.Path == "" {
 			return errors.New("path required for add operation")
 		}
-		if _, err := os.Stat(req.FileInfo.Path); err != nil {
+		if _, err := a.statSource(req.FileInfo); err != nil {
 			return err
 		}
 
@@ -91,7 +109,7 @@ func (a *Archiver) validateRequest(req ModifyRequest) error {
 		if req.Path == "" || req.FileInfo.Path == "" {
 			return errors.New("both old and new paths required for update operation")
 		}
-		if _, err := os.Stat(req.FileInfo.Path); err != nil {
+		if _, err := a.statSource(req.FileInfo); err != nil {
 			return err
 		}
 
@@ -135,144 +153,231 @@ func (a *Archiver) ListFiles() ([]string, error) {
 	return files, nil
 }
 
-// scanTarball scans the tarball and builds an index of files
+// scanTarball scans the tarball and builds an index of files. Seekable
+// archives are listed from their index without decompressing any entry;
+// other archives are listed once and then served from a cache that is
+// checked against the archive on every call.
 func (a *Archiver) scanTarball() (*TarballInfo, error) {
-	f, err := os.Open(a.config.OutputPath)
+	index, err := readIndex(a.config.OutputPath)
 	if err != nil {
 		return nil, err
 	}
-	defer f.Close()
+	if index != nil {
+		return tarballInfo(index.Entries), nil
+	}
 
-	gzr, err := gzip.NewReader(f)
+	key, err := archiveKeyFor(a.config.OutputPath)
 	if err != nil {
 		return nil, err
 	}
-	defer gzr.Close()
+	if info := a.cachedTarballInfo(key); info != nil {
+		return info, nil
+	}
 
-	tr := tar.NewReader(gzr)
-	info := &TarballInfo{
-		Files: make(map[string]FileEntry),
+	entries, layout, err := a.readEntries()
+	if err != nil {
+		return nil, err
 	}
+	a.storeTarballInfo(key, entries, layout.enc == nil)
+	return tarballInfo(entries), nil
+}
+
+// readEntries reads the headers of every entry of the archive
+func (a *Archiver) readEntries() ([]IndexEntry, archiveLayout, error) {
+	tr, err := a.openArchive(a.config.OutputPath)
+	if err != nil {
+		return nil, archiveLayout{}, err
+	}
+	defer tr.Close()
+
+	// Zip entries read in place can be addressed directly
+	zr, inPlace := tr.entryReader.(*zipEntryReader)
+	inPlace = inPlace && tr.layout.enc == nil && tr.layout.volumeSize == 0
 
+	var entries []IndexEntry
 	for {
 		header, err := tr.Next()
 		if err == io.EOF {
 			break
 		}
 		if err != nil {
-			return nil, err
+			return nil, tr.layout, err
+		}
+		if isMetaEntry(header.Name) {
+			continue
 		}
 
-		info.Files[header.Name] = FileEntry{
-			Header:  header,
-			Size:    header.Size,
-			ModTime: header.ModTime,
+		offset := int64(-1)
+		if inPlace {
+			if offset, err = zr.offset(); err != nil {
+				return nil, tr.layout, err
+			}
 		}
+		entries = append(entries, indexEntryFor(header, offset))
 	}
 
-	return info, nil
+	return entries, tr.layout, nil
 }
 
 // Helper methods for file operations
-func (a *Archiver) addFile(tw *tar.Writer, info FileInfo) error {
-	file, err := os.Open(info.Path)
+func (a *Archiver) addFile(tw entryWriter, info FileInfo) (ManifestEntry, error) {
+	if header := specialHeader(info); header != nil {
+		return manifestEntry(header, emptySHA256), a.writeEntryHeader(tw, header, nil)
+	}
+
+	file, err := a.openSource(info)
 	if err != nil {
-		return err
+		return ManifestEntry{}, err
 	}
 	defer file.Close()
 
 	stat, err := file.Stat()
 	if err != nil {
-		return err
+		return ManifestEntry{}, err
 	}
 
 	header := &tar.Header{
-		Name:    filepath.Base(info.Path),
+		Name:    entryName(info),
 		Size:    stat.Size(),
 		Mode:    int64(stat.Mode()),
 		ModTime: stat.ModTime(),
 	}
 
-	if err := tw.WriteHeader(header); err != nil {
-		return err
+	if err := a.writeEntryHeader(tw, header, sampler(file)); err != nil {
+		return ManifestEntry{}, err
 	}
 
-	_, err = io.Copy(tw, file)
-	return err
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(tw, h), file); err != nil {
+		return ManifestEntry{}, err
+	}
+	return manifestEntry(header, hex.EncodeToString(h.Sum(nil))), nil
+}
+
+// pendingEdits indexes the remove and update requests of a Modify call by
+// entry name, so they can all be applied in a single pass over the archive.
+// It also collects the manifest of the rewritten archive.
+type pendingEdits struct {
+	removes map[string]int // entry name -> request index
+	updates map[string]int
+	adds    map[string]int
+	found   map[int]bool
+
+	manifest Manifest
+	kept     map[string]bool // entries copied unchanged
+	marked   map[string]bool // entries the original manifest marks inconsistent
 }
 
-func (a *Archiver) removeFile(tr *tar.Reader, tw *tar.Writer, path string) error {
+func newPendingEdits(requests []ModifyRequest) *pendingEdits {
+	p := &pendingEdits{
+		removes: make(map[string]int),
+		updates: make(map[string]int),
+		adds:    make(map[string]int),
+		found:   make(map[int]bool),
+		kept:    make(map[string]bool),
+		marked:  make(map[string]bool),
+	}
+	for i, req := range requests {
+		switch req.Operation {
+		case OperationRemove:
+			p.removes[req.Path] = i
+		case OperationUpdate:
+			p.updates[req.Path] = i
+		case OperationAdd:
+			// Adding a name that already exists replaces the entry
+			p.adds[entryName(req.FileInfo)] = i
+		}
+	}
+	return p
+}
+
+// rewriteEntries copies the source archive to tw, dropping removed entries
+// and replacing updated ones. It returns the error of each update that could
+// not be written, keyed by request index.
+func (a *Archiver) rewriteEntries(tr entryReader, tw entryWriter, requests []ModifyRequest, edits *pendingEdits) (map[int]error, error) {
+	failed := make(map[int]error)
 	if tr == nil {
-		return errors.New("tar reader is nil")
+		return failed, nil
 	}
-	
-	// Read through the archive
+
 	for {
 		header, err := tr.Next()
 		if err == io.EOF {
-			break
+			return failed, nil
 		}
 		if err != nil {
-			return err
+			return failed, err
 		}
-		
-		// Skip the file we want to remove
-		if header.Name == path {
+
+		if i, ok := edits.removes[header.Name]; ok {
+			edits.found[i] = true
 			continue
 		}
-		
-		// Copy other files to the new archive
-		if err := tw.WriteHeader(header); err != nil {
-			return err
-		}
-		
-		if _, err := io.Copy(tw, tr); err != nil {
-			return err
+		if i, ok := edits.updates[header.Name]; ok {
+			edits.found[i] = true
+			entry, err := a.addFile(tw, requests[i].FileInfo)
+			if err != nil {
+				failed[i] = err
+				continue
+			}
+			edits.manifest.Entries = append(edits.manifest.Entries, entry)
+			continue
 		}
-	}
-	
-	return nil
-}
-
-func (a *Archiver) updateFile(tr *tar.Reader, tw *tar.Writer, req ModifyRequest) error {
-	updated := false
-	for {
-		header, err := tr.Next()
-		if err == io.EOF {
-			break
+		if _, ok := edits.adds[header.Name]; ok {
+			continue
 		}
-		if err != nil {
-			return err
+		// The index of a seekable archive is rebuilt by the writer
+		if header.Name == indexEntry {
+			continue
 		}
-
-		if header.Name == req.Path {
-			// Replace with new file
-			if err := a.addFile(tw, req.FileInfo); err != nil {
-				return err
+		// The stored manifest is written again for the new contents
+		if header.Name == manifestMetaEntry {
+			var stored Manifest
+			if err := json.NewDecoder(tr).Decode(&stored); err != nil {
+				return failed, err
+			}
+			for _, entry := range stored.Entries {
+				edits.marked[entry.Name] = entry.Inconsistent
 			}
-			updated = true
 			continue
 		}
 
-		// Copy other files
-		if err := tw.WriteHeader(header); err != nil {
-			return err
+		// Copy other entries unchanged
+		if err := a.writeEntryHeader(tw, header, nil); err != nil {
+			return failed, err
 		}
-
-		if _, err := io.Copy(tw, tr); err != nil {
-			return err
+		h := sha256.New()
+		if _, err := io.Copy(io.MultiWriter(tw, h), tr); err != nil {
+			return failed, err
+		}
+		// Other bookkeeping is left out, as in readManifest
+		if !isMetaEntry(header.Name) || header.Name == backupEntry {
+			edits.kept[header.Name] = true
+			edits.manifest.Entries = append(edits.manifest.Entries, manifestEntry(header, hex.EncodeToString(h.Sum(nil))))
 		}
 	}
+}
 
-	if !updated {
-		return ErrFileNotFound
+// storedManifest returns the manifest of the rewritten archive. Copied
+// entries keep their inconsistent marks; written ones are read afresh.
+func (p *pendingEdits) storedManifest() Manifest {
+	manifest := Manifest{Entries: append([]ManifestEntry(nil), p.manifest.Entries...)}
+	for i, entry := range manifest.Entries {
+		manifest.Entries[i].Inconsistent = p.kept[entry.Name] && p.marked[entry.Name]
 	}
-	return nil
+	manifest.sort()
+	return manifest
 }
 
-// Modify modifies an existing tarball with concurrent processing
+// Modify applies the requests to the archive in a single rewrite. The
+// rewritten archive keeps the container and codec of the original, except
+// that archives in a read-only codec such as bzip2 are rewritten as gzip.
+// CompressionUnset rewrites with the configured level.
 func (a *Archiver) Modify(requests []ModifyRequest, compression CompressionLevel) <-chan ModifyResult {
 	out := make(chan ModifyResult)
+	if compression == CompressionUnset {
+		compression = a.compressionLevel()
+	}
 	
 	go func() {
 		defer close(out)
@@ -290,94 +395,136 @@ func (a *Archiver) Modify(requests []ModifyRequest, compression CompressionLevel
 		}
 
 		// Open original archive for reading
-		srcFile, err := os.Open(a.config.OutputPath)
+		src, err := a.openArchive(a.config.OutputPath)
 		if err != nil && !os.IsNotExist(err) {
 			out <- ModifyResult{Error: err}
 			return
 		}
-		defer srcFile.Close()
+
+		// Set up reader if source file exists, keeping its container,
+		// recipients and volume size
+		var (
+			tr         entryReader
+			layout     *archiveLayout
+			volumeSize = a.config.VolumeSize
+		)
+		if src != nil {
+			defer src.Close()
+			tr = src.entryReader
+			layout = &src.layout
+			volumeSize = src.layout.volumeSize
+		}
 
 		// Create temporary file for the modified archive
-		tempFile, err := os.CreateTemp(filepath.Dir(a.config.OutputPath), "temp_*.tar.gz")
+		placeholder, err := os.CreateTemp(filepath.Dir(a.config.OutputPath), "temp_*.tar.gz")
 		if err != nil {
 			out <- ModifyResult{Error: err}
 			return
 		}
-		tempPath := tempFile.Name()
-		defer os.Remove(tempPath)
+		placeholder.Close()
+		tempPath := placeholder.Name()
+		defer removeOutput(tempPath)
 
-		// Set up writers
-		gzw, err := gzip.NewWriterLevel(tempFile, int(compression))
+		tempFile, err := createOutput(tempPath, volumeSize)
 		if err != nil {
 			out <- ModifyResult{Error: err}
 			return
 		}
-		defer gzw.Close()
+		defer tempFile.Close()
 
-		tw := tar.NewWriter(gzw)
-		defer tw.Close()
+		// Set up writers
+		aw, err := a.newArchiveWriter(tempFile, compression, layout)
+		if err != nil {
+			out <- ModifyResult{Error: err}
+			return
+		}
+		tw := aw.entryWriter
 
-		// Set up reader if source file exists
-		var tr *tar.Reader
-		if srcFile != nil {
-			gzr, err := gzip.NewReader(srcFile)
-			if err != nil {
-				out <- ModifyResult{Error: err}
-				return
-			}
-			defer gzr.Close()
-			tr = tar.NewReader(gzr)
+		// Plain tar output is recorded to update the cached listing
+		var recorder *recordingWriter
+		if _, ok := tw.(*tar.Writer); ok {
+			recorder = &recordingWriter{entryWriter: tw}
+			tw = recorder
 		}
 
-		// Process modifications
-		for _, req := range requests {
+		// Copy the archive once, applying removals and updates on the way,
+		// then append the added files
+		edits := newPendingEdits(requests)
+		failed, err := a.rewriteEntries(tr, tw, requests, edits)
+		if err != nil {
+			out <- ModifyResult{Error: err}
+			return
+		}
+		for i, req := range requests {
 			switch req.Operation {
 			case OperationAdd:
-				if err := a.addFile(tw, req.FileInfo); err != nil {
-					out <- ModifyResult{
-						Operation: req.Operation,
-						Path:      req.Path,
-						Error:     err,
-					}
-					continue
-				}
-			case OperationRemove:
-				if err := a.removeFile(tr, tw, req.Path); err != nil {
-					out <- ModifyResult{
-						Operation: req.Operation,
-						Path:      req.Path,
-						Error:     err,
-					}
+				entry, err := a.addFile(tw, req.FileInfo)
+				if err != nil {
+					failed[i] = err
 					continue
 				}
-			case OperationUpdate:
-				if err := a.updateFile(tr, tw, req); err != nil {
-					out <- ModifyResult{
-						Operation: req.Operation,
-						Path:      req.Path,
-						Error:     err,
-					}
-					continue
+				edits.manifest.Entries = append(edits.manifest.Entries, entry)
+			case OperationRemove, OperationUpdate:
+				if !edits.found[i] {
+					failed[i] = ErrFileNotFound
 				}
 			}
+		}
 
-			out <- ModifyResult{
-				Operation: req.Operation,
-				Path:      req.Path,
-				Success:   true,
-			}
+		if _, err := writeMetaEntry(tw, manifestMetaEntry, edits.storedManifest(), a.metaTime()); err != nil {
+			out <- ModifyResult{Error: err}
+			return
+		}
+
+		// Flush every layer before the temporary file replaces the original
+		if err := aw.Close(); err != nil {
+			out <- ModifyResult{Error: err}
+			return
+		}
+		if err := tempFile.Close(); err != nil {
+			out <- ModifyResult{Error: err}
+			return
 		}
 
 		// Replace original with modified version
-		if err := os.Rename(tempPath, a.config.OutputPath); err != nil {
+		if err := commitOutput(tempPath, a.config.OutputPath, volumeSize); err != nil {
 			out <- ModifyResult{Error: err}
 			return
 		}
+		a.updateTarballInfo(recorder, layout)
+
+		for i, req := range requests {
+			out <- ModifyResult{
+				Operation: req.Operation,
+				Path:      req.Path,
+				Success:   failed[i] == nil,
+				Error:     failed[i],
+			}
+		}
 	}()
 
 	return out
 }
 
+// updateTarballInfo refreshes the cached listing after Modify. Without a
+// recording of the written entries the cache is dropped instead.
+func (a *Archiver) updateTarballInfo(recorder *recordingWriter, layout *archiveLayout) {
+	if recorder == nil {
+		a.invalidateTarballInfo()
+		return
+	}
+	key, err := archiveKeyFor(a.config.OutputPath)
+	if err != nil {
+		a.invalidateTarballInfo()
+		return
+	}
+	encrypted := len(a.config.Recipients) > 0
+	if layout != nil {
+		encrypted = layout.enc != nil
+	}
+	a.storeTarballInfo(key, recorder.entries, !encrypted)
+}
+
 // BulkModifyResult represents the result of a bulk modification operation
 type BulkModifyResult struct {
 	Successful int
@@ -0,0 +1,97 @@
+package archiver
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+	"strings"
+)
+
+// StdinPathList names standard input as the source of a path list
+const StdinPathList = "-"
+
+// openPathList opens a path list file, or standard input for "-"
+func openPathList(name string) (io.ReadCloser, error) {
+	if name == StdinPathList {
+		return io.NopCloser(os.Stdin), nil
+	}
+	return os.Open(name)
+}
+
+// newPathListScanner splits a path list into paths. Lists are
+// newline-delimited, as written by find and fd, or NUL-delimited, as written
+// by find -print0 and fd -0. Empty entries are skipped.
+func newPathListScanner(r io.Reader, nul bool) *bufio.Scanner {
+	sep := byte('\n')
+	if nul {
+		sep = 0
+	}
+	scanner := bufio.NewScanner(r)
+	scanner.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+		if i := bytes.IndexByte(data, sep); i >= 0 {
+			return i + 1, data[:i], nil
+		}
+		if atEOF && len(data) > 0 {
+			return len(data), data, nil
+		}
+		return 0, nil, nil
+	})
+	return scanner
+}
+
+// nextPath returns the next non-empty path of a list
+func nextPath(scanner *bufio.Scanner, nul bool) (string, bool) {
+	for scanner.Scan() {
+		path := scanner.Text()
+		if !nul {
+			path = strings.TrimSuffix(path, "\r")
+		}
+		if path != "" {
+			return path, true
+		}
+	}
+	return "", false
+}
+
+// ReadPathList reads a newline- or NUL-delimited list of paths
+func ReadPathList(r io.Reader, nul bool) ([]string, error) {
+	var paths []string
+	scanner := newPathListScanner(r, nul)
+	for {
+		path, ok := nextPath(scanner, nul)
+		if !ok {
+			return paths, scanner.Err()
+		}
+		paths = append(paths, path)
+	}
+}
+
+// readPathListFile reads the path list in the named file, "-" for stdin
+func readPathListFile(name string, nul bool) ([]string, error) {
+	r, err := openPathList(name)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ReadPathList(r, nul)
+}
+
+// BatchAddFilesFrom adds the files named in a path list, "-" for stdin
+func (a *Archiver) BatchAddFilesFrom(list string, nul bool, batchSize int, compression CompressionLevel) BulkModifyResult {
+	paths, err := readPathListFile(list, nul)
+	if err != nil {
+		return BulkModifyResult{Failed: 1, Errors: []error{err}}
+	}
+	return a.BatchAddFiles(paths, batchSize, compression)
+}
+
+// BatchRemoveFilesFrom removes the entries named in a path list, "-" for
+// stdin
+func (a *Archiver) BatchRemoveFilesFrom(list string, nul bool, batchSize int, compression CompressionLevel) BulkModifyResult {
+	paths, err := readPathListFile(list, nul)
+	if err != nil {
+		return BulkModifyResult{Failed: 1, Errors: []error{err}}
+	}
+	return a.BatchRemoveFiles(paths, batchSize, compression)
+}
@@ -0,0 +1,293 @@
+package archiver
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// PlanEntry is a file Create would write
+type PlanEntry struct {
+	Path     string `json:"path"`
+	Name     string `json:"name"`             // Entry name in the archive
+	Output   string `json:"output,omitempty"` // Archive the entry is routed to under SplitBy
+	Category string `json:"category"`
+	Size     int64  `json:"size"`
+	// EstimatedSize is the compressed size predicted from a sample
+	EstimatedSize int64 `json:"estimated_size"`
+	Stored        bool  `json:"stored,omitempty"` // Written without compression
+}
+
+// PlanCollision lists files that would be stored under the same name in
+// the same archive
+type PlanCollision struct {
+	Name   string   `json:"name"`
+	Output string   `json:"output,omitempty"`
+	Paths  []string `json:"paths"`
+}
+
+// PlanCategory sums the entries of one category
+type PlanCategory struct {
+	Files int64 `json:"files"`
+	Bytes int64 `json:"bytes"`
+}
+
+// CreatePlan describes what Create would write, sorted by output and name
+type CreatePlan struct {
+	Entries    []PlanEntry             `json:"entries"`
+	Collisions []PlanCollision         `json:"collisions,omitempty"`
+	Categories map[string]PlanCategory `json:"categories"`
+	TotalSize  int64                   `json:"total_size"`
+	// EstimatedSize is the predicted size of the entry content once
+	// compressed, without container overhead
+	EstimatedSize int64 `json:"estimated_size"`
+	// Unchanged counts files a backup would skip
+	Unchanged int64 `json:"unchanged,omitempty"`
+	// Errors lists files the scan could not read or map
+	Errors []string `json:"errors,omitempty"`
+}
+
+// Plan runs Scan and Filter and reports what Create, or Route under
+// SplitBy, would write without writing anything. Every file is opened to
+// sample its compression.
+func (a *Archiver) Plan() (*CreatePlan, error) {
+	// Routed backups keep a snapshot per route
+	backups := make(map[string]*backupRun)
+	backupFor := func(info FileInfo) (*backupRun, error) {
+		key := a.routeKey(info)
+		if backup, ok := backups[key]; ok {
+			return backup, nil
+		}
+		config := a.config
+		if a.config.SplitBy != SplitNone && config.SnapshotPath != "" {
+			config.SnapshotPath = routeOutputPath(config.SnapshotPath, key)
+		}
+		backup, err := New(config).startBackup()
+		backups[key] = backup
+		return backup, err
+	}
+	if a.config.SplitBy == SplitNone {
+		if _, err := backupFor(FileInfo{}); err != nil {
+			return nil, err
+		}
+	}
+	scanResults, err := a.Scan()
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &CreatePlan{Categories: make(map[string]PlanCategory)}
+	for result := range a.Filter(scanResults) {
+		if result.Error != nil {
+			plan.Errors = append(plan.Errors, result.Error.Error())
+			continue
+		}
+		info := result.FileInfo
+		if info.IsDir {
+			continue
+		}
+		backup, err := backupFor(info)
+		if err != nil {
+			plan.Errors = append(plan.Errors, err.Error())
+			continue
+		}
+		if backup != nil && !backup.changed(entryName(info), info) {
+			plan.Unchanged++
+			continue
+		}
+
+		entry := PlanEntry{
+			Path:     info.Path,
+			Name:     entryName(info),
+			Category: fileCategory(info.Path),
+			Size:     info.Size,
+		}
+		if specialHeader(info) != nil {
+			entry.Size = 0
+		}
+		if a.config.SplitBy != SplitNone {
+			entry.Output = routeOutputPath(a.config.OutputPath, a.routeKey(info))
+		}
+		// A file that cannot be read is expected to keep its size
+		sample, _ := a.sampleCompression(info, sampleSize)
+		entry.EstimatedSize = int64(float64(entry.Size) * sample.ratio)
+		entry.Stored = sample.stored
+
+		plan.Entries = append(plan.Entries, entry)
+		category := plan.Categories[entry.Category]
+		category.Files++
+		category.Bytes += entry.Size
+		plan.Categories[entry.Category] = category
+		plan.TotalSize += entry.Size
+		plan.EstimatedSize += entry.EstimatedSize
+	}
+
+	sort.SliceStable(plan.Entries, func(i, j int) bool {
+		ei, ej := plan.Entries[i], plan.Entries[j]
+		if ei.Output != ej.Output {
+			return ei.Output < ej.Output
+		}
+		return ei.Name < ej.Name
+	})
+	for i := 0; i < len(plan.Entries); {
+		j := i + 1
+		for j < len(plan.Entries) && plan.Entries[j].Output == plan.Entries[i].Output &&
+			plan.Entries[j].Name == plan.Entries[i].Name {
+			j++
+		}
+		if j-i > 1 {
+			collision := PlanCollision{Name: plan.Entries[i].Name, Output: plan.Entries[i].Output}
+			for _, entry := range plan.Entries[i:j] {
+				collision.Paths = append(collision.Paths, entry.Path)
+			}
+			plan.Collisions = append(plan.Collisions, collision)
+		}
+		i = j
+	}
+	return plan, nil
+}
+
+// JSON encodes the plan for tooling
+func (p *CreatePlan) JSON() ([]byte, error) {
+	return json.MarshalIndent(p, "", "  ")
+}
+
+// String formats the plan for a dry run
+func (p *CreatePlan) String() string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "Entries (%d):\n", len(p.Entries))
+	for _, entry := range p.Entries {
+		target := entry.Name
+		if entry.Output != "" {
+			target = entry.Output + ": " + entry.Name
+		}
+		fmt.Fprintf(&b, "  %s <- %s (%d bytes, ~%d compressed)\n", target, entry.Path, entry.Size, entry.EstimatedSize)
+	}
+	if len(p.Collisions) > 0 {
+		fmt.Fprintf(&b, "Collisions (%d):\n", len(p.Collisions))
+		for _, collision := range p.Collisions {
+			fmt.Fprintf(&b, "  %s <- %s\n", collision.Name, strings.Join(collision.Paths, ", "))
+		}
+	}
+	if len(p.Errors) > 0 {
+		fmt.Fprintf(&b, "Errors (%d):\n", len(p.Errors))
+		for _, err := range p.Errors {
+			fmt.Fprintf(&b, "  %s\n", err)
+		}
+	}
+	for _, name := range []string{CategoryPhotos, CategoryVideos, CategoryOthers} {
+		if category, ok := p.Categories[name]; ok {
+			fmt.Fprintf(&b, "%s: %d files, %d bytes\n", name, category.Files, category.Bytes)
+		}
+	}
+	fmt.Fprintf(&b, "%d bytes, about %d compressed", p.TotalSize, p.EstimatedSize)
+	if p.Unchanged > 0 {
+		fmt.Fprintf(&b, ", %d unchanged files skipped", p.Unchanged)
+	}
+	b.WriteString("\n")
+	return b.String()
+}
+
+// ModifyPlanItem describes what a single request of Modify would do
+type ModifyPlanItem struct {
+	Operation ModifyOperation `json:"operation"`
+	Path      string          `json:"path"`
+	// Entry is the existing entry the request removes, updates or
+	// replaces; empty when it matches none
+	Entry string `json:"entry,omitempty"`
+	// Name is the entry an add or update writes
+	Name  string `json:"name,omitempty"`
+	Error string `json:"error,omitempty"` // Why the request would fail
+}
+
+// ModifyPlan describes what Modify would do, request by request
+type ModifyPlan struct {
+	Requests []ModifyPlanItem `json:"requests"`
+	// Kept counts the existing entries copied unchanged
+	Kept int `json:"kept"`
+}
+
+// PlanModify reports which entries of the archive at OutputPath the
+// requests would match, without rewriting it
+func (a *Archiver) PlanModify(requests []ModifyRequest) (*ModifyPlan, error) {
+	entries, _, err := a.readEntries()
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	// Match the entries the way rewriteEntries does
+	edits := newPendingEdits(requests)
+	matched := make(map[int]string)
+	plan := &ModifyPlan{}
+	for _, entry := range entries {
+		if isMetaEntry(entry.Name) {
+			continue
+		}
+		if i, ok := edits.removes[entry.Name]; ok {
+			matched[i] = entry.Name
+			continue
+		}
+		if i, ok := edits.updates[entry.Name]; ok {
+			matched[i] = entry.Name
+			continue
+		}
+		if i, ok := edits.adds[entry.Name]; ok {
+			matched[i] = entry.Name
+			continue
+		}
+		plan.Kept++
+	}
+
+	for i, req := range requests {
+		item := ModifyPlanItem{Operation: req.Operation, Path: req.Path, Entry: matched[i]}
+		if req.Operation == OperationAdd || req.Operation == OperationUpdate {
+			item.Name = entryName(req.FileInfo)
+			if item.Path == "" {
+				item.Path = req.FileInfo.Path
+			}
+		}
+		if err := a.validateRequest(req); err != nil {
+			item.Error = err.Error()
+		} else if item.Entry == "" && req.Operation != OperationAdd {
+			item.Error = ErrFileNotFound.Error()
+		}
+		plan.Requests = append(plan.Requests, item)
+	}
+	return plan, nil
+}
+
+// JSON encodes the plan for tooling
+func (p *ModifyPlan) JSON() ([]byte, error) {
+	return json.MarshalIndent(p, "", "  ")
+}
+
+// String formats the plan for a dry run
+func (p *ModifyPlan) String() string {
+	var b strings.Builder
+
+	for _, item := range p.Requests {
+		switch item.Operation {
+		case OperationAdd:
+			if item.Entry != "" {
+				fmt.Fprintf(&b, "A %s <- %s (replaces %s)", item.Name, item.Path, item.Entry)
+			} else {
+				fmt.Fprintf(&b, "A %s <- %s", item.Name, item.Path)
+			}
+		case OperationRemove:
+			fmt.Fprintf(&b, "D %s", item.Path)
+		case OperationUpdate:
+			fmt.Fprintf(&b, "M %s -> %s", item.Path, item.Name)
+		default:
+			fmt.Fprintf(&b, "? %s", item.Path)
+		}
+		if item.Error != "" {
+			fmt.Fprintf(&b, ": %s", item.Error)
+		}
+		b.WriteString("\n")
+	}
+	fmt.Fprintf(&b, "%d requests, %d entries kept\n", len(p.Requests), p.Kept)
+	return b.String()
+}
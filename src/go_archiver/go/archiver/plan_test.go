@@ -0,0 +1,137 @@
+package archiver
+
+import (
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPlan(t *testing.T) {
+	source := t.TempDir()
+	if err := os.Mkdir(filepath.Join(source, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	text := strings.Repeat("compressible text ", 1000)
+	writeSourceFile(t, filepath.Join(source, "notes.txt"), text, time.Now())
+	writeSourceFile(t, filepath.Join(source, "sub", "notes.txt"), text, time.Now())
+	noise := make([]byte, 8192)
+	rand.Read(noise)
+	writeSourceFile(t, filepath.Join(source, "photo.jpg"), string(noise), time.Now())
+
+	config := Config{
+		SourcePath: source,
+		OutputPath: filepath.Join(t.TempDir(), "out.tar.gz"),
+		Recursive:  true,
+		FilterMode: FilterAll,
+	}
+	plan, err := New(config).Plan()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(config.OutputPath); !os.IsNotExist(err) {
+		t.Errorf("Expected Plan to write nothing, got %v", err)
+	}
+
+	if len(plan.Entries) != 3 {
+		t.Fatalf("Expected 3 entries, got %+v", plan.Entries)
+	}
+	if len(plan.Collisions) != 1 || plan.Collisions[0].Name != "notes.txt" || len(plan.Collisions[0].Paths) != 2 {
+		t.Errorf("Expected both notes.txt to collide, got %+v", plan.Collisions)
+	}
+	if got := plan.Categories[CategoryPhotos]; got.Files != 1 || got.Bytes != int64(len(noise)) {
+		t.Errorf("Expected one photo of %d bytes, got %+v", len(noise), got)
+	}
+	if got := plan.Categories[CategoryOthers]; got.Files != 2 {
+		t.Errorf("Expected two other files, got %+v", got)
+	}
+	if want := int64(2*len(text) + len(noise)); plan.TotalSize != want {
+		t.Errorf("Expected %d bytes, got %d", want, plan.TotalSize)
+	}
+	for _, entry := range plan.Entries {
+		switch entry.Name {
+		case "photo.jpg":
+			if entry.EstimatedSize != entry.Size {
+				t.Errorf("Expected the photo to stay %d bytes, got %d", entry.Size, entry.EstimatedSize)
+			}
+		default:
+			if entry.EstimatedSize <= 0 || entry.EstimatedSize >= entry.Size/10 {
+				t.Errorf("Expected text to compress well, got %d of %d bytes", entry.EstimatedSize, entry.Size)
+			}
+		}
+	}
+
+	// Routed files are planned per output, so names only collide within one
+	config.SplitBy = SplitCategory
+	plan, err = New(config).Plan()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range plan.Entries {
+		if want := routeOutputPath(config.OutputPath, fileCategory(entry.Path)); entry.Output != want {
+			t.Errorf("Expected %s routed to %s, got %s", entry.Name, want, entry.Output)
+		}
+	}
+	if len(plan.Collisions) != 1 {
+		t.Errorf("Expected one collision, got %+v", plan.Collisions)
+	}
+}
+
+func TestPlanModify(t *testing.T) {
+	source := t.TempDir()
+	for _, name := range []string{"keep.txt", "old.txt", "edit.txt"} {
+		writeSourceFile(t, filepath.Join(source, name), "content of "+name, time.Now())
+	}
+	config := Config{
+		SourcePath: source,
+		OutputPath: filepath.Join(t.TempDir(), "out.tar.gz"),
+		FilterMode: FilterAll,
+		Modifiable: true,
+	}
+	a := createArchive(t, config)
+	before, err := os.ReadFile(config.OutputPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	extra := filepath.Join(t.TempDir(), "new.txt")
+	writeSourceFile(t, extra, "new", time.Now())
+	plan, err := a.PlanModify([]ModifyRequest{
+		{Operation: OperationRemove, Path: "old.txt"},
+		{Operation: OperationRemove, Path: "gone.txt"},
+		{Operation: OperationUpdate, Path: "edit.txt", FileInfo: FileInfo{Path: extra}},
+		{Operation: OperationAdd, FileInfo: FileInfo{Path: filepath.Join(source, "keep.txt")}},
+		{Operation: OperationAdd, FileInfo: FileInfo{Path: filepath.Join(source, "missing.txt")}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []ModifyPlanItem{
+		{Operation: OperationRemove, Path: "old.txt", Entry: "old.txt"},
+		{Operation: OperationRemove, Path: "gone.txt", Error: ErrFileNotFound.Error()},
+		{Operation: OperationUpdate, Path: "edit.txt", Entry: "edit.txt", Name: "new.txt"},
+		{Operation: OperationAdd, Path: filepath.Join(source, "keep.txt"), Entry: "keep.txt", Name: "keep.txt"},
+	}
+	for i, item := range want {
+		if plan.Requests[i] != item {
+			t.Errorf("Request %d: Expected %+v, got %+v", i, item, plan.Requests[i])
+		}
+	}
+	if last := plan.Requests[4]; last.Entry != "" || last.Error == "" {
+		t.Errorf("Expected adding a missing file to fail, got %+v", last)
+	}
+	if plan.Kept != 0 {
+		t.Errorf("Expected no entries kept as they are, got %d", plan.Kept)
+	}
+
+	after, err := os.ReadFile(config.OutputPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(before) != string(after) {
+		t.Error("Expected PlanModify to leave the archive untouched")
+	}
+}
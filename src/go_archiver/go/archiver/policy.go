@@ -0,0 +1,160 @@
+package archiver
+
+import (
+	"archive/tar"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// CompressionPolicy decides which entries are compressed in containers that
+// choose the method per entry
+type CompressionPolicy string
+
+const (
+	PolicyDeflate CompressionPolicy = "deflate" // Compress everything (default)
+	PolicyAuto    CompressionPolicy = "auto"    // Store entries that would not shrink
+)
+
+const (
+	// DefaultStoreThreshold is the sampled compression ratio above which an
+	// entry is stored
+	DefaultStoreThreshold = 0.95
+	sampleSize            = 64 * 1024
+)
+
+// CompressedFormats lists extensions whose content is already compressed
+var CompressedFormats = map[string]bool{
+	"jpg": true, "jpeg": true, "png": true, "webp": true, "heic": true, "heif": true, "gif": true,
+	"mp4": true, "webm": true, "mkv": true, "mov": true, "avi": true, "m4v": true,
+	"mp3": true, "aac": true, "m4a": true, "ogg": true, "opus": true, "flac": true,
+	"zip": true, "gz": true, "tgz": true, "bz2": true, "xz": true, "zst": true, "7z": true, "rar": true,
+}
+
+// isCompressedFormat reports whether the extension of name is known to hold
+// compressed data
+func isCompressedFormat(name string) bool {
+	return CompressedFormats[extension(name)]
+}
+
+// extension returns the lower-case extension of name without the dot
+func extension(name string) string {
+	return strings.TrimPrefix(strings.ToLower(filepath.Ext(name)), ".")
+}
+
+// storeEntry reports whether an entry should be stored uncompressed in
+// containers that choose the method per entry. sample gives access to the
+// entry content and may be nil.
+func (a *Archiver) storeEntry(name string, sample io.ReaderAt) bool {
+	ext := extension(name)
+	for _, stored := range a.config.StoreExtensions {
+		if ext == strings.ToLower(stored) {
+			return true
+		}
+	}
+
+	if a.config.CompressionPolicy != PolicyAuto {
+		return false
+	}
+	if isCompressedFormat(name) {
+		return true
+	}
+	if sample == nil {
+		return false
+	}
+
+	threshold := a.config.StoreThreshold
+	if threshold <= 0 {
+		threshold = DefaultStoreThreshold
+	}
+	ratio, err := sampleRatio(sample, CompressionFast)
+	return err == nil && ratio > threshold
+}
+
+// sampleRatio compresses the start of r and returns compressed/original size
+func sampleRatio(r io.ReaderAt, level CompressionLevel) (float64, error) {
+	buf := make([]byte, sampleSize)
+	n, err := r.ReadAt(buf, 0)
+	if err != nil && err != io.EOF {
+		return 0, err
+	}
+	if n == 0 {
+		return 0, nil
+	}
+	compressed, err := compressedSize(buf[:n], CodecDeflate, level)
+	if err != nil {
+		return 0, err
+	}
+	return float64(compressed) / float64(n), nil
+}
+
+// methodWriter is implemented by containers that choose the compression
+// method per entry
+type methodWriter interface {
+	WriteHeaderMethod(hdr *tar.Header, store bool) error
+}
+
+// writeEntryHeader starts an entry, applying the compression policy where
+// the container supports it. sample may be nil.
+func (a *Archiver) writeEntryHeader(w entryWriter, hdr *tar.Header, sample io.ReaderAt) error {
+	a.normaliseHeader(hdr)
+	if mw, ok := w.(methodWriter); ok {
+		return mw.WriteHeaderMethod(hdr, a.storeEntry(hdr.Name, sample))
+	}
+	return w.WriteHeader(hdr)
+}
+
+// orderedOutput reports whether Create must write entries in the order
+// produced by orderEntries rather than as they finish
+func (a *Archiver) orderedOutput() bool {
+	if a.config.Reproducible {
+		return true
+	}
+	format := a.layout().format
+	return a.config.CompressionPolicy == PolicyAuto && format != FormatZip
+}
+
+// orderEntries buffers the filtered files and groups them for a shared
+// compression stream: compressible files first, grouped by extension, and
+// already-compressed media last. Files are sorted by name within a group,
+// and reproducible archives without that grouping are sorted by name alone.
+// Errors are passed through immediately.
+func (a *Archiver) orderEntries(in <-chan FilterResult) <-chan FilterResult {
+	if !a.orderedOutput() {
+		return in
+	}
+
+	out := make(chan FilterResult)
+	go func() {
+		defer close(out)
+
+		var files []FilterResult
+		for result := range in {
+			if result.Error != nil {
+				out <- result
+				continue
+			}
+			files = append(files, result)
+		}
+
+		grouped := a.config.CompressionPolicy == PolicyAuto && a.layout().format != FormatZip
+		sort.SliceStable(files, func(i, j int) bool {
+			pi, pj := files[i].FileInfo.Path, files[j].FileInfo.Path
+			if grouped {
+				ci, cj := isCompressedFormat(pi), isCompressedFormat(pj)
+				if ci != cj {
+					return !ci
+				}
+				if ei, ej := extension(pi), extension(pj); ei != ej {
+					return ei < ej
+				}
+			}
+			return entryName(files[i].FileInfo) < entryName(files[j].FileInfo)
+		})
+		for _, result := range files {
+			out <- result
+		}
+	}()
+	return out
+}
@@ -0,0 +1,55 @@
+package archiver
+
+import (
+	"archive/tar"
+	"os"
+	"strconv"
+	"time"
+)
+
+// sourceDateEpochEnv names the variable that sets the clamp time of
+// reproducible archives, as defined by reproducible-builds.org
+const sourceDateEpochEnv = "SOURCE_DATE_EPOCH"
+
+// sourceDateEpoch returns the time reproducible archives are clamped to:
+// Config.SourceDateEpoch, or $SOURCE_DATE_EPOCH when that is unset. ok is
+// false when neither is set.
+func (a *Archiver) sourceDateEpoch() (epoch time.Time, ok bool) {
+	if !a.config.SourceDateEpoch.IsZero() {
+		return a.config.SourceDateEpoch.UTC(), true
+	}
+	if value := os.Getenv(sourceDateEpochEnv); value != "" {
+		if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+			return time.Unix(seconds, 0).UTC(), true
+		}
+	}
+	return time.Time{}, false
+}
+
+// metaTime returns the modification time of bookkeeping entries. In
+// reproducible archives it is the clamp time, or the Unix epoch.
+func (a *Archiver) metaTime() time.Time {
+	if !a.config.Reproducible {
+		return time.Now()
+	}
+	if epoch, ok := a.sourceDateEpoch(); ok {
+		return epoch
+	}
+	return time.Unix(0, 0).UTC()
+}
+
+// normaliseHeader strips the parts of a header that vary between runs over
+// the same tree: ownership, access and change times, sub-second precision,
+// and modification times after the clamp time
+func (a *Archiver) normaliseHeader(hdr *tar.Header) {
+	if !a.config.Reproducible {
+		return
+	}
+	hdr.Uid, hdr.Gid = 0, 0
+	hdr.Uname, hdr.Gname = "", ""
+	hdr.AccessTime, hdr.ChangeTime = time.Time{}, time.Time{}
+	hdr.ModTime = hdr.ModTime.Round(time.Second).UTC()
+	if epoch, ok := a.sourceDateEpoch(); ok && hdr.ModTime.After(epoch) {
+		hdr.ModTime = epoch
+	}
+}
@@ -0,0 +1,68 @@
+package archiver
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestReproducibleArchive(t *testing.T) {
+	source := t.TempDir()
+	future := time.Now().Add(24 * time.Hour)
+	for i := 0; i < 20; i++ {
+		writeSourceFile(t, filepath.Join(source, fmt.Sprintf("file%02d.txt", i)),
+			fmt.Sprintf("content %d", i), future.Add(time.Duration(i)*time.Millisecond))
+	}
+
+	signingKey, _, err := GenerateSigningKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	epoch := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	t.Setenv(sourceDateEpochEnv, fmt.Sprint(epoch.Unix()))
+
+	for _, config := range []Config{
+		{OutputPath: "out.tar.gz"},
+		{OutputPath: "out.tar.gz", Seekable: true, SigningKey: signingKey},
+		{OutputPath: "out.zip", CompressionPolicy: PolicyAuto},
+		{OutputPath: "out.tar.gz", Codec: CodecPgzip},
+	} {
+		var archives [][]byte
+		for run := 0; run < 3; run++ {
+			config := config
+			config.SourcePath = source
+			config.OutputPath = filepath.Join(t.TempDir(), config.OutputPath)
+			config.FilterMode = FilterAll
+			config.Reproducible = true
+			a := createArchive(t, config)
+
+			data, err := os.ReadFile(config.OutputPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			archives = append(archives, data)
+
+			if run == 0 {
+				names, err := a.ListFiles()
+				if err != nil {
+					t.Fatal(err)
+				}
+				info, err := a.GetFileInfo(names[0])
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !info.ModTime.Equal(epoch) {
+					t.Errorf("%s: Expected mtimes clamped to %v, got %v", config.OutputPath, epoch, info.ModTime)
+				}
+			}
+		}
+		for run := 1; run < len(archives); run++ {
+			if !bytes.Equal(archives[0], archives[run]) {
+				t.Errorf("%s: Expected byte-identical archives across runs", filepath.Base(config.OutputPath))
+			}
+		}
+	}
+}
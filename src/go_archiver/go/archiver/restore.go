@@ -0,0 +1,288 @@
+package archiver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Restore errors
+var (
+	ErrNoFullBackup = errors.New("no full backup before the restore point")
+	ErrBrokenChain  = errors.New("backup chain is incomplete")
+)
+
+// BackupArchive is a backup archive found while planning a restore
+type BackupArchive struct {
+	Path string
+	BackupInfo
+	// Entries lists the files stored in the archive
+	Entries []string
+}
+
+// RestorePlan describes which archive each file of the restored state comes
+// from. Archives are listed in the order they are applied.
+type RestorePlan struct {
+	Target   time.Time
+	Archives []BackupArchive
+	// Sources maps every restored entry to the path of its archive
+	Sources map[string]string
+	// Deleted lists entries removed by tombstones along the chain
+	Deleted []string
+}
+
+// PlanRestore builds the chain of backup archives in dir that reconstructs
+// the source as of target. A zero target restores the latest state. Files
+// in dir that are not backup archives are ignored.
+func (a *Archiver) PlanRestore(dir string, target time.Time) (*RestorePlan, error) {
+	archives, err := a.findBackups(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	// Start from the last full backup at or before the target
+	var chain []BackupArchive
+	for _, archive := range archives {
+		if !target.IsZero() && archive.Time.After(target) {
+			break
+		}
+		switch {
+		case archive.Level == LevelFull:
+			chain = []BackupArchive{archive}
+		case len(chain) == 0:
+			// Increments of an earlier chain without its full backup
+		case archive.Level == LevelDifferential:
+			if !archive.BaseTime.Equal(chain[0].Time) {
+				return nil, fmt.Errorf("%w: %s does not follow the full backup %s",
+					ErrBrokenChain, archive.Path, chain[0].Path)
+			}
+			// A differential supersedes every increment since the full backup
+			chain = append(chain[:1], archive)
+		default:
+			if last := chain[len(chain)-1]; !archive.BaseTime.Equal(last.Time) {
+				return nil, fmt.Errorf("%w: %s does not follow %s",
+					ErrBrokenChain, archive.Path, last.Path)
+			}
+			chain = append(chain, archive)
+		}
+	}
+	if len(chain) == 0 {
+		return nil, ErrNoFullBackup
+	}
+
+	plan := &RestorePlan{
+		Target:   target,
+		Archives: chain,
+		Sources:  make(map[string]string),
+	}
+	deleted := make(map[string]bool)
+	for _, archive := range chain {
+		for _, name := range archive.Tombstones {
+			if _, ok := plan.Sources[name]; ok {
+				delete(plan.Sources, name)
+				deleted[name] = true
+			}
+		}
+		for _, name := range archive.Entries {
+			plan.Sources[name] = archive.Path
+			delete(deleted, name)
+		}
+	}
+	for name := range deleted {
+		plan.Deleted = append(plan.Deleted, name)
+	}
+	sort.Strings(plan.Deleted)
+	return plan, nil
+}
+
+// findBackups reads the backup metadata of every archive in dir, sorted by
+// backup time
+func (a *Archiver) findBackups(dir string) ([]BackupArchive, error) {
+	dirEntries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var archives []BackupArchive
+	for _, dirEntry := range dirEntries {
+		if dirEntry.IsDir() {
+			continue
+		}
+		name := dirEntry.Name()
+		switch {
+		case strings.HasSuffix(name, ".001"):
+			// Volume sets are opened through their base name
+			name = strings.TrimSuffix(name, ".001")
+		case filepath.Ext(name) == signatureExtension, filepath.Ext(name) == ".json":
+			continue
+		case isVolumePart(name):
+			continue
+		}
+
+		if archive, ok := a.readBackup(filepath.Join(dir, name)); ok {
+			archives = append(archives, archive)
+		}
+	}
+
+	sort.SliceStable(archives, func(i, j int) bool {
+		return archives[i].Time.Before(archives[j].Time)
+	})
+	return archives, nil
+}
+
+// isVolumePart reports whether name is a later volume of a volume set
+func isVolumePart(name string) bool {
+	ext := filepath.Ext(name)
+	if len(ext) != 4 {
+		return false
+	}
+	for _, c := range ext[1:] {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// readBackup lists an archive and reads its backup metadata. ok is false
+// for files that are not backup archives, including stray files that cannot
+// be read as an archive at all, such as caches or truncated archives.
+func (a *Archiver) readBackup(path string) (archive BackupArchive, ok bool) {
+	tr, err := a.openArchive(path)
+	if err != nil {
+		return archive, false
+	}
+	defer tr.Close()
+
+	archive.Path = path
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return archive, false
+		}
+		if header.Name == backupEntry {
+			if err := json.NewDecoder(tr).Decode(&archive.BackupInfo); err != nil {
+				return archive, false
+			}
+			ok = true
+			continue
+		}
+		if !isMetaEntry(header.Name) {
+			archive.Entries = append(archive.Entries, header.Name)
+		}
+	}
+	return archive, ok
+}
+
+// String formats the plan for a dry run
+func (p *RestorePlan) String() string {
+	var b strings.Builder
+
+	target := "latest"
+	if !p.Target.IsZero() {
+		target = p.Target.Format(time.RFC3339)
+	}
+	fmt.Fprintf(&b, "Restore to %s from %d archive(s):\n", target, len(p.Archives))
+
+	counts := make(map[string]int)
+	for _, path := range p.Sources {
+		counts[path]++
+	}
+	for i, archive := range p.Archives {
+		fmt.Fprintf(&b, "  %d. %-12s %s  %s (%d entries)\n", i+1, archive.Level,
+			archive.Time.Format(time.RFC3339), archive.Path, counts[archive.Path])
+	}
+
+	names := make([]string, 0, len(p.Sources))
+	for name := range p.Sources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Fprintf(&b, "Entries (%d):\n", len(names))
+	for _, name := range names {
+		fmt.Fprintf(&b, "  %s <- %s\n", name, p.Sources[name])
+	}
+
+	if len(p.Deleted) > 0 {
+		fmt.Fprintf(&b, "Deleted (%d):\n", len(p.Deleted))
+		for _, name := range p.Deleted {
+			fmt.Fprintf(&b, "  %s\n", name)
+		}
+	}
+	return b.String()
+}
+
+// Restore extracts the state described by plan into dest, reading each
+// archive of the chain once. When TrustedKeys are configured every archive
+// is verified before anything is extracted.
+func (a *Archiver) Restore(plan *RestorePlan, dest string) <-chan ExtractResult {
+	out := make(chan ExtractResult)
+
+	go func() {
+		defer close(out)
+
+		if len(a.config.TrustedKeys) > 0 {
+			for _, archive := range plan.Archives {
+				if err := a.forArchive(archive.Path).Verify(); err != nil {
+					out <- ExtractResult{Path: archive.Path, Error: err}
+					return
+				}
+			}
+		}
+
+		for _, archive := range plan.Archives {
+			if err := a.restoreFrom(plan, archive.Path, dest, out); err != nil {
+				out <- ExtractResult{Path: archive.Path, Error: err}
+				return
+			}
+		}
+	}()
+
+	return out
+}
+
+// restoreFrom extracts the entries plan takes from the archive at path
+func (a *Archiver) restoreFrom(plan *RestorePlan, path, dest string, out chan<- ExtractResult) error {
+	tr, err := a.openArchive(path)
+	if err != nil {
+		return err
+	}
+	defer tr.Close()
+
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if isMetaEntry(header.Name) || plan.Sources[header.Name] != path {
+			continue
+		}
+
+		err = extractEntry(tr, header, dest)
+		out <- ExtractResult{
+			Path:  header.Name,
+			Size:  header.Size,
+			Error: err,
+		}
+	}
+}
+
+// forArchive returns an archiver with the same settings for the archive at
+// path
+func (a *Archiver) forArchive(path string) *Archiver {
+	config := a.config
+	config.OutputPath = path
+	return New(config)
+}
@@ -0,0 +1,223 @@
+package archiver
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// SplitMode selects how files are routed to separate archives
+type SplitMode string
+
+const (
+	SplitNone     SplitMode = ""
+	SplitCategory SplitMode = "category" // photos, videos and others
+	SplitMonth    SplitMode = "month"    // by modification month, e.g. 2024-05
+)
+
+// routeKeyPlaceholder is replaced by the route key in OutputPath
+const routeKeyPlaceholder = "{key}"
+
+// RouteResult reports the archive written for a single route
+type RouteResult struct {
+	Key        string
+	OutputPath string
+	CreateResult
+}
+
+// SplitReport combines the results of every routed archive
+type SplitReport struct {
+	Outputs        []RouteResult // Final result of each route, sorted by key
+	FilesProcessed int64
+	TotalSize      int64
+	Errors         []error // Input errors, failed files and failed routes
+}
+
+// routeKey returns the route of a file under the configured split mode
+func (a *Archiver) routeKey(info FileInfo) string {
+	switch a.config.SplitBy {
+	case SplitCategory:
+		return fileCategory(info.Path)
+	case SplitMonth:
+		if info.ModTime.IsZero() {
+			return "unknown"
+		}
+		return info.ModTime.Format("2006-01")
+	default:
+		return ""
+	}
+}
+
+// routeOutputPath expands the OutputPath template for a route key. Without
+// a {key} placeholder the key is appended to the file name, before the
+// archive extension.
+func routeOutputPath(template, key string) string {
+	if strings.Contains(template, routeKeyPlaceholder) {
+		return strings.ReplaceAll(template, routeKeyPlaceholder, key)
+	}
+
+	dir, name := filepath.Split(template)
+	stem, ext := name, ""
+	for _, known := range []string{".tar.gz", ".tar.bz2", ".tgz", ".tar", ".zip"} {
+		if strings.HasSuffix(strings.ToLower(name), known) {
+			stem, ext = name[:len(name)-len(known)], name[len(name)-len(known):]
+			break
+		}
+	}
+	if ext == "" {
+		ext = filepath.Ext(name)
+		stem = strings.TrimSuffix(name, ext)
+	}
+	return filepath.Join(dir, stem+"-"+key+ext)
+}
+
+// Route fans filtered files out to one archive per route key, as selected
+// by Config.SplitBy, and writes the archives concurrently. A result is sent
+// for each archive once it is complete; input errors are passed through
+// without a key.
+//
+// The error policy applies to the job: MaxErrors counts the failed files of
+// every route, and a route that stops the run stops the others.
+//
+// Every route of a backup keeps its own chain, with the snapshot at
+// SnapshotPath expanded like OutputPath. Routes with a snapshot from an
+// earlier run are written even when none of their files are left, so the
+// removals are recorded.
+func (a *Archiver) Route(in <-chan FilterResult) <-chan RouteResult {
+	out := make(chan RouteResult)
+
+	go func() {
+		defer close(out)
+
+		var (
+			wg     sync.WaitGroup
+			routes = make(map[string]chan FilterResult)
+		)
+
+		// An invalid policy is reported by each route
+		tracker, _ := a.newErrorTracker()
+
+		// open starts the archive of a route
+		open := func(key string) chan FilterResult {
+			config := a.config
+			config.SplitBy = SplitNone
+			config.OutputPath = routeOutputPath(a.config.OutputPath, key)
+			if a.config.CheckpointPath != "" {
+				config.CheckpointPath = routeOutputPath(a.config.CheckpointPath, key)
+			}
+			if a.config.SnapshotPath != "" {
+				config.SnapshotPath = routeOutputPath(a.config.SnapshotPath, key)
+			}
+			// The key may name a directory; Create reports any failure
+			os.MkdirAll(filepath.Dir(config.OutputPath), 0755)
+
+			sub := New(config)
+			sub.tracker = tracker
+
+			route := make(chan FilterResult)
+			routes[key] = route
+
+			wg.Add(1)
+			go func(key string, sub *Archiver, files <-chan FilterResult) {
+				defer wg.Done()
+				for created := range sub.Create(files) {
+					out <- RouteResult{
+						Key:          key,
+						OutputPath:   sub.config.OutputPath,
+						CreateResult: created,
+					}
+				}
+				// Create stops reading early if the output cannot be
+				// created; keep the router from blocking on this route
+				for range files {
+				}
+			}(key, sub, route)
+			return route
+		}
+
+		for _, key := range a.backupRoutes() {
+			open(key)
+		}
+		for result := range in {
+			if result.Error != nil {
+				out <- RouteResult{CreateResult: CreateResult{Error: result.Error}}
+				continue
+			}
+
+			key := a.routeKey(result.FileInfo)
+			route, ok := routes[key]
+			if !ok {
+				route = open(key)
+			}
+			route <- result
+		}
+
+		for _, route := range routes {
+			close(route)
+		}
+		wg.Wait()
+	}()
+
+	return out
+}
+
+// backupRoutes returns the keys of the routes an incremental or
+// differential backup continues, found by their snapshot files. Keys are
+// only found in the file name of SnapshotPath.
+func (a *Archiver) backupRoutes() []string {
+	if a.config.BackupLevel == "" || a.config.BackupLevel == LevelFull || a.config.SnapshotPath == "" {
+		return nil
+	}
+	const marker = "\x00"
+	template := routeOutputPath(a.config.SnapshotPath, marker)
+	dir, name := filepath.Split(template)
+	if strings.Contains(dir, marker) {
+		return nil
+	}
+	prefix, suffix, _ := strings.Cut(name, marker)
+	if dir == "" {
+		dir = "."
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	var keys []string
+	for _, entry := range entries {
+		base := entry.Name()
+		if entry.IsDir() || len(base) <= len(prefix)+len(suffix) ||
+			!strings.HasPrefix(base, prefix) || !strings.HasSuffix(base, suffix) {
+			continue
+		}
+		keys = append(keys, base[len(prefix):len(base)-len(suffix)])
+	}
+	return keys
+}
+
+// CollectRoutes waits for every routed archive and combines the results
+func CollectRoutes(results <-chan RouteResult) SplitReport {
+	var report SplitReport
+	for result := range results {
+		if result.Error != nil {
+			report.Errors = append(report.Errors, result.Error)
+		}
+		// Failed files are reported on their own, before the final result
+		if _, ok := result.Error.(*FileError); ok {
+			continue
+		}
+		if result.Key == "" && result.OutputPath == "" {
+			continue
+		}
+		report.Outputs = append(report.Outputs, result)
+		report.FilesProcessed += result.FilesProcessed
+		report.TotalSize += result.TotalSize
+	}
+
+	sort.SliceStable(report.Outputs, func(i, j int) bool {
+		return report.Outputs[i].Key < report.Outputs[j].Key
+	})
+	return report
+}
@@ -0,0 +1,177 @@
+package archiver
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRouteByCategory(t *testing.T) {
+	outputDir := t.TempDir()
+	a := New(Config{
+		SourcePath: "testdata/source",
+		OutputPath: filepath.Join(outputDir, "backup-{key}.zip"),
+		Recursive:  true,
+		FilterMode: FilterAll,
+		SplitBy:    SplitCategory,
+	})
+
+	scanResults, err := a.Scan()
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := CollectRoutes(a.Route(a.Filter(scanResults)))
+	if len(report.Errors) > 0 {
+		t.Fatalf("Unexpected errors: %v", report.Errors)
+	}
+	if report.FilesProcessed != 7 {
+		t.Errorf("Expected 7 files in total, got %d", report.FilesProcessed)
+	}
+
+	expected := map[string]int{
+		CategoryOthers: 3,
+		CategoryPhotos: 2,
+		CategoryVideos: 2,
+	}
+	if len(report.Outputs) != len(expected) {
+		t.Fatalf("Expected %d outputs, got %+v", len(expected), report.Outputs)
+	}
+	for _, output := range report.Outputs {
+		if want := filepath.Join(outputDir, "backup-"+output.Key+".zip"); output.OutputPath != want {
+			t.Errorf("Expected output path %s, got %s", want, output.OutputPath)
+		}
+		files, err := New(Config{OutputPath: output.OutputPath}).ListFiles()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(files) != expected[output.Key] || output.FilesProcessed != int64(expected[output.Key]) {
+			t.Errorf("Expected %d files in %s, got %v", expected[output.Key], output.Key, files)
+		}
+	}
+}
+
+func TestRouteOutputPath(t *testing.T) {
+	tests := []struct {
+		template, key, want string
+	}{
+		{"out/backup.tar.gz", "photos", "out/backup-photos.tar.gz"},
+		{"out/backup.zip", "2024-05", "out/backup-2024-05.zip"},
+		{"out/{key}/backup.tar", "videos", "out/videos/backup.tar"},
+		{"backup", "others", "backup-others"},
+	}
+	for _, tt := range tests {
+		if got := routeOutputPath(tt.template, tt.key); got != tt.want {
+			t.Errorf("routeOutputPath(%q, %q) = %q, want %q", tt.template, tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestRouteBackup(t *testing.T) {
+	source := t.TempDir()
+	outputDir := t.TempDir()
+	snapshot := filepath.Join(t.TempDir(), "snapshot.json")
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	writeSourceFile(t, filepath.Join(source, "a.txt"), "a", base)
+	writeSourceFile(t, filepath.Join(source, "b.txt"), "b", base)
+	writeSourceFile(t, filepath.Join(source, "photo.jpg"), "photo", base)
+
+	backup := func(level BackupLevel, name string) SplitReport {
+		t.Helper()
+		a := New(Config{
+			SourcePath:   source,
+			OutputPath:   filepath.Join(outputDir, name+"-{key}.tar.gz"),
+			FilterMode:   FilterAll,
+			SplitBy:      SplitCategory,
+			BackupLevel:  level,
+			SnapshotPath: snapshot,
+		})
+		scanResults, err := a.Scan()
+		if err != nil {
+			t.Fatal(err)
+		}
+		report := CollectRoutes(a.Route(a.Filter(scanResults)))
+		if len(report.Errors) > 0 {
+			t.Fatalf("Unexpected errors: %v", report.Errors)
+		}
+		return report
+	}
+
+	backup(LevelFull, "full")
+	for _, key := range []string{CategoryOthers, CategoryPhotos} {
+		if _, err := os.Stat(routeOutputPath(snapshot, key)); err != nil {
+			t.Errorf("Expected a snapshot for %s: %v", key, err)
+		}
+	}
+
+	// Each route only tombstones its own files, and a route without files
+	// still records their removal
+	writeSourceFile(t, filepath.Join(source, "a.txt"), "changed", base.Add(time.Hour))
+	if err := os.Remove(filepath.Join(source, "photo.jpg")); err != nil {
+		t.Fatal(err)
+	}
+	report := backup(LevelIncremental, "inc")
+	if len(report.Outputs) != 2 {
+		t.Fatalf("Expected an increment per route, got %+v", report.Outputs)
+	}
+	names, info := backupArchive(t, filepath.Join(outputDir, "inc-"+CategoryOthers+".tar.gz"))
+	if !reflect.DeepEqual(names, []string{"a.txt"}) || len(info.Tombstones) != 0 {
+		t.Errorf("Expected only a.txt in others, got %v and tombstones %v", names, info.Tombstones)
+	}
+	names, info = backupArchive(t, filepath.Join(outputDir, "inc-"+CategoryPhotos+".tar.gz"))
+	if len(names) != 0 || !reflect.DeepEqual(info.Tombstones, []string{"photo.jpg"}) {
+		t.Errorf("Expected a tombstone for photo.jpg, got %v and tombstones %v", names, info.Tombstones)
+	}
+}
+
+func TestRouteErrors(t *testing.T) {
+	route := func(policy ErrorPolicy, maxErrors int) SplitReport {
+		t.Helper()
+		// The failing files go to different months
+		source := newFailingFS(0)
+		for name, file := range source.MapFS {
+			file.ModTime = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+			if name == "broken.txt" {
+				file.ModTime = file.ModTime.AddDate(0, 1, 0)
+			}
+		}
+		a := New(Config{
+			SourceFS:    source,
+			OutputPath:  filepath.Join(t.TempDir(), "out-{key}.tar.gz"),
+			FilterMode:  FilterAll,
+			SplitBy:     SplitMonth,
+			ErrorPolicy: policy,
+			MaxErrors:   maxErrors,
+		})
+		scanResults, err := a.Scan()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return CollectRoutes(a.Route(a.Filter(scanResults)))
+	}
+
+	report := route(ErrorContinue, 0)
+	if len(report.Outputs) != 2 {
+		t.Errorf("Expected one output per route, got %+v", report.Outputs)
+	}
+	for _, output := range report.Outputs {
+		if output.Error != nil {
+			t.Errorf("Expected %s to complete, got %v", output.Key, output.Error)
+		}
+	}
+	if len(report.Errors) != 2 {
+		t.Errorf("Expected the two failed files, got %v", report.Errors)
+	}
+
+	// One failed file per route still exceeds the limit of the job
+	report = route(ErrorMaxErrors, 1)
+	aborted := false
+	for _, output := range report.Outputs {
+		aborted = aborted || errors.Is(output.Error, ErrTooManyErrors)
+	}
+	if !aborted {
+		t.Errorf("Expected MaxErrors to count every route, got %+v", report.Outputs)
+	}
+}
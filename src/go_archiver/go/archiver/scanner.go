@@ -1,8 +1,10 @@
 package archiver
 
 import (
-	"os"
-	"path/filepath"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
 	"sync"
 )
 
@@ -12,63 +14,236 @@ type ScanResult struct {
 	Error    error
 }
 
-// Scan starts the file scanning process
+// Scan starts the file scanning process. Files are read from SourceFS
+// when it is set, with SourcePath naming the directory to scan within it.
+// With Sources, every root is scanned and its files are named by prefix and
+// relative path; a file whose name another root already produced is
+// reported as an ErrSourceCollision.
+//
+// With FilesFrom, the listed paths are archived instead and no source root
+// is scanned; listed directories are walked when Recursive is set.
+//
+// Symbolic links and special files are handled by SymlinkPolicy and
+// SpecialFiles. A followed link to one of the directories it is in is
+// reported as an ErrSymlinkCycle, and a followed link to a directory within
+// its root on the disk is skipped, as the directory is archived under its
+// own path. A SourceFS that cannot read link targets has its links followed
+// under SymlinkStore, or skipped when their target cannot be read either.
+// OneFileSystem keeps the walk on the device of each root.
 func (a *Archiver) Scan() (<-chan ScanResult, error) {
 	out := make(chan ScanResult)
 	
-	// Validate source path
-	_, err := os.Stat(a.config.SourcePath)
+	if err := a.checkScanPolicies(); err != nil {
+		return nil, err
+	}
+	
+	roots, err := a.roots()
 	if err != nil {
 		return nil, err
 	}
 	
+	var list io.ReadCloser
+	if a.config.FilesFrom != "" {
+		if list, err = openPathList(a.config.FilesFrom); err != nil {
+			return nil, err
+		}
+		roots = []scanRoot{{Source: Source{FS: a.config.SourceFS, Recursive: a.config.Recursive}}}
+	}
+	
+	// Validate source paths
+	rootInfos := make([]fs.FileInfo, len(roots))
+	if list == nil {
+		for i, root := range roots {
+			if rootInfos[i], err = root.stat(root.Path); err != nil {
+				return nil, err
+			}
+		}
+	}
+	
 	go func() {
 		defer close(out)
 		
 		var wg sync.WaitGroup
 		semaphore := make(chan struct{}, 10) // Limit concurrent goroutines
 		
-		var scan func(string)
-		scan = func(path string) {
+		var namesMu sync.Mutex
+		names := make(map[string]string) // archive name -> source path
+		
+		// emit sends a file found under root
+		emit := func(root scanRoot, entryPath string, info fs.FileInfo, linkname string) {
+			name := root.entryName(entryPath)
+			if name != "" {
+				namesMu.Lock()
+				other, taken := names[name]
+				if !taken {
+					names[name] = entryPath
+				}
+				namesMu.Unlock()
+				if taken {
+					out <- ScanResult{Error: fmt.Errorf("%w: %s and %s both map to %s",
+						ErrSourceCollision, other, entryPath, name)}
+					return
+				}
+			}
+			
+			// Send file info through channel
+			_, rdev := fileDevice(info)
+			out <- ScanResult{
+				FileInfo: FileInfo{
+					Path:     entryPath,
+					Size:     info.Size(),
+					IsDir:    info.IsDir(),
+					ModTime:  info.ModTime(),
+					Inode:    fileInode(info),
+					Name:     name,
+					Mode:     info.Mode(),
+					Linkname: linkname,
+					Rdev:     rdev,
+					root:     root.index,
+				},
+			}
+		}
+		
+		var scan func(scanRoot, string, uint64, []dirKey)
+		
+		// visit applies the link and special file policies to an entry,
+		// given its lstat metadata, and walks or emits it. dev is the
+		// device of the root the walk started from and parents are the
+		// directories the entry is in.
+		visit := func(root scanRoot, entryPath string, info fs.FileInfo, dev uint64, parents []dirKey) {
+			var linkname string
+			followed := false
+			if info.Mode()&fs.ModeSymlink != 0 {
+				switch a.config.SymlinkPolicy {
+				case SymlinkSkip:
+					return
+				case SymlinkFollow:
+					target, err := root.stat(entryPath)
+					if err != nil {
+						out <- ScanResult{Error: err}
+						return
+					}
+					info = target
+					followed = true
+				default:
+					target, err := root.readlink(entryPath)
+					if err == nil {
+						linkname = target
+						break
+					}
+					if !errors.Is(err, errors.ErrUnsupported) {
+						out <- ScanResult{Error: err}
+						return
+					}
+					// A file system that cannot read links has them
+					// followed, and skipped where that fails too
+					stat, err := root.stat(entryPath)
+					if err != nil || stat.Mode()&fs.ModeSymlink != 0 {
+						return
+					}
+					info = stat
+					followed = true
+				}
+			}
+			
+			if mode := info.Mode(); mode&specialModes != 0 {
+				switch a.config.SpecialFiles {
+				case SpecialStore:
+					if mode&fs.ModeSocket != 0 {
+						return
+					}
+				case SpecialError:
+					out <- ScanResult{Error: fmt.Errorf("%w: %s (%s)", ErrSpecialFile, entryPath, mode.Type())}
+					return
+				default:
+					return
+				}
+			}
+			
+			if info.IsDir() && root.Recursive {
+				if d, _ := fileDevice(info); a.config.OneFileSystem && d != dev {
+					return
+				}
+				key, ok := dirKeyOf(info)
+				if ok && containsKey(parents, key) {
+					out <- ScanResult{Error: fmt.Errorf("%w: %s", ErrSymlinkCycle, entryPath)}
+					return
+				}
+				if followed && root.contains(entryPath) {
+					return
+				}
+				if ok {
+					parents = append(parents[:len(parents):len(parents)], key)
+				}
+				wg.Add(1)
+				go func(p string) {
+					semaphore <- struct{}{} // Acquire
+					scan(root, p, dev, parents)
+					<-semaphore // Release
+				}(entryPath)
+				return
+			}
+			
+			emit(root, entryPath, info, linkname)
+		}
+		
+		scan = func(root scanRoot, path string, dev uint64, parents []dirKey) {
 			defer wg.Done()
 			
-			entries, err := os.ReadDir(path)
+			entries, err := root.readDir(path)
 			if err != nil {
 				out <- ScanResult{Error: err}
 				return
 			}
 			
 			for _, entry := range entries {
-				entryPath := filepath.Join(path, entry.Name())
+				entryPath := root.join(path, entry.Name())
+				if root.ignored(entryPath) {
+					continue
+				}
 				info, err := entry.Info()
 				if err != nil {
 					out <- ScanResult{Error: err}
 					continue
 				}
 				
-				if info.IsDir() && a.config.Recursive {
-					wg.Add(1)
-					go func(p string) {
-						semaphore <- struct{}{} // Acquire
-						scan(p)
-						<-semaphore // Release
-					}(entryPath)
+				visit(root, entryPath, info, dev, parents)
+			}
+		}
+		
+		if list != nil {
+			defer list.Close()
+			
+			root := roots[0]
+			paths := newPathListScanner(list, a.config.FilesFromNull)
+			for {
+				path, ok := nextPath(paths, a.config.FilesFromNull)
+				if !ok {
+					break
+				}
+				info, err := root.lstat(path)
+				if err != nil {
+					out <- ScanResult{Error: err}
 					continue
 				}
-				
-				// Send file info through channel
-				out <- ScanResult{
-					FileInfo: FileInfo{
-						Path:  entryPath,
-						Size:  info.Size(),
-						IsDir: info.IsDir(),
-					},
+				// Every listed path starts its own walk
+				dev, _ := fileDevice(info)
+				visit(root, path, info, dev, nil)
+			}
+			if err := paths.Err(); err != nil {
+				out <- ScanResult{Error: err}
+			}
+		} else {
+			for i, root := range roots {
+				var parents []dirKey
+				if key, ok := dirKeyOf(rootInfos[i]); ok {
+					parents = []dirKey{key}
 				}
+				dev, _ := fileDevice(rootInfos[i])
+				wg.Add(1)
+				scan(root, root.Path, dev, parents)
 			}
 		}
-		
-		wg.Add(1)
-		scan(a.config.SourcePath)
 		wg.Wait()
 	}()
 	
@@ -0,0 +1,340 @@
+package archiver
+
+import (
+	"archive/tar"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Signature errors
+var (
+	ErrNotSigned        = errors.New("archive is not signed")
+	ErrUntrustedKey     = errors.New("archive is signed by an untrusted key")
+	ErrBadSignature     = errors.New("archive signature is invalid")
+	ErrDigestMismatch   = errors.New("archive digest does not match signature")
+	ErrManifestMismatch = errors.New("archive contents do not match signed manifest")
+	ErrNoManifest       = errors.New("archive has no stored manifest")
+)
+
+const (
+	// metaPrefix marks entries the archiver stores for its own bookkeeping.
+	// They are hidden from listings and extraction.
+	metaPrefix = ".archiver/"
+
+	signatureEntry     = metaPrefix + "signature.json"
+	manifestMetaEntry  = metaPrefix + "manifest.json"
+	signatureExtension = ".sig"
+)
+
+// ManifestEntry records the content digest of a single archive entry,
+// along with the type, link target and permissions of its header
+type ManifestEntry struct {
+	Name     string `json:"name"`
+	Size     int64  `json:"size"`
+	SHA256   string `json:"sha256"`
+	Type     string `json:"type"` // tar type flag, such as "0" for a regular file
+	Linkname string `json:"linkname,omitempty"`
+	Mode     int64  `json:"mode"` // Permission bits
+	// Inconsistent marks entries whose file changed while it was read
+	Inconsistent bool `json:"inconsistent,omitempty"`
+}
+
+// manifestEntry describes an entry written with hdr and content digest
+// sha. Headers of regular files without a type flag read back as
+// tar.TypeReg.
+func manifestEntry(hdr *tar.Header, sha string) ManifestEntry {
+	typeflag := hdr.Typeflag
+	if typeflag == 0 {
+		typeflag = tar.TypeReg
+	}
+	return ManifestEntry{
+		Name:     hdr.Name,
+		Size:     hdr.Size,
+		SHA256:   sha,
+		Type:     string([]byte{typeflag}),
+		Linkname: hdr.Linkname,
+		Mode:     int64(os.FileMode(hdr.Mode).Perm()),
+	}
+}
+
+// Manifest lists every entry of an archive, sorted by name
+type Manifest struct {
+	Entries []ManifestEntry `json:"entries"`
+}
+
+// Signature is an Ed25519 signature over a manifest and, for detached
+// signatures, the digest of the archive file itself
+type Signature struct {
+	Manifest      Manifest `json:"manifest"`
+	ArchiveSHA256 string   `json:"archive_sha256,omitempty"`
+	PublicKey     string   `json:"public_key"`
+	Signature     string   `json:"signature"`
+}
+
+// GenerateSigningKey creates a new Ed25519 key pair. The private key is
+// used as Config.SigningKey, the public key goes into Config.TrustedKeys.
+func GenerateSigningKey() (privateKey, publicKey string, err error) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return "", "", err
+	}
+	privateKey = base64.StdEncoding.EncodeToString(priv.Seed())
+	publicKey = base64.StdEncoding.EncodeToString(pub)
+	return privateKey, publicKey, nil
+}
+
+func parseSigningKey(s string) (ed25519.PrivateKey, error) {
+	seed, err := base64.StdEncoding.DecodeString(s)
+	if err != nil || len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("%w: signing key", ErrInvalidKey)
+	}
+	return ed25519.NewKeyFromSeed(seed), nil
+}
+
+// isMetaEntry reports whether name is an internal bookkeeping entry
+func isMetaEntry(name string) bool {
+	return strings.HasPrefix(name, metaPrefix)
+}
+
+// sort orders the manifest entries by name
+func (m *Manifest) sort() {
+	sort.Slice(m.Entries, func(i, j int) bool {
+		return m.Entries[i].Name < m.Entries[j].Name
+	})
+}
+
+// signedMessage is the canonical encoding covered by the signature
+func (s *Signature) signedMessage() ([]byte, error) {
+	return json.Marshal(struct {
+		Manifest      Manifest `json:"manifest"`
+		ArchiveSHA256 string   `json:"archive_sha256,omitempty"`
+	}{s.Manifest, s.ArchiveSHA256})
+}
+
+// newSignature signs the manifest and optional archive digest
+func (a *Archiver) newSignature(manifest Manifest, archiveSHA256 string) (*Signature, error) {
+	key, err := parseSigningKey(a.config.SigningKey)
+	if err != nil {
+		return nil, err
+	}
+
+	manifest.sort()
+	sig := &Signature{
+		Manifest:      manifest,
+		ArchiveSHA256: archiveSHA256,
+		PublicKey:     base64.StdEncoding.EncodeToString(key.Public().(ed25519.PublicKey)),
+	}
+	msg, err := sig.signedMessage()
+	if err != nil {
+		return nil, err
+	}
+	sig.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(key, msg))
+	return sig, nil
+}
+
+// writeDetachedSignature signs the finished archive into OutputPath.sig
+func (a *Archiver) writeDetachedSignature(manifest Manifest) error {
+	digest, err := archiveSHA256(a.config.OutputPath)
+	if err != nil {
+		return err
+	}
+	sig, err := a.newSignature(manifest, digest)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(sig, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(a.config.OutputPath+signatureExtension, data, 0644)
+}
+
+// Sign writes a detached signature for the existing archive at OutputPath,
+// for example after it was changed with Modify.
+func (a *Archiver) Sign() error {
+	manifest, _, err := a.readManifest()
+	if err != nil {
+		return err
+	}
+	return a.writeDetachedSignature(manifest)
+}
+
+// Verify checks the archive at OutputPath against its detached or embedded
+// signature. The signing key must be one of Config.TrustedKeys, the signature
+// must be valid and the archive contents must match the signed manifest.
+func (a *Archiver) Verify() error {
+	manifest, embedded, err := a.readManifest()
+	if err != nil {
+		return err
+	}
+
+	sig := embedded
+	detached, err := os.ReadFile(a.config.OutputPath + signatureExtension)
+	switch {
+	case err == nil:
+		sig = &Signature{}
+		if err := json.Unmarshal(detached, sig); err != nil {
+			return fmt.Errorf("%w: %v", ErrBadSignature, err)
+		}
+	case !os.IsNotExist(err):
+		return err
+	}
+	if sig == nil {
+		return ErrNotSigned
+	}
+
+	trusted := false
+	for _, key := range a.config.TrustedKeys {
+		if key == sig.PublicKey {
+			trusted = true
+			break
+		}
+	}
+	if !trusted {
+		return ErrUntrustedKey
+	}
+
+	pub, err := base64.StdEncoding.DecodeString(sig.PublicKey)
+	if err != nil || len(pub) != ed25519.PublicKeySize {
+		return ErrBadSignature
+	}
+	signature, err := base64.StdEncoding.DecodeString(sig.Signature)
+	if err != nil {
+		return ErrBadSignature
+	}
+	msg, err := sig.signedMessage()
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(ed25519.PublicKey(pub), msg, signature) {
+		return ErrBadSignature
+	}
+
+	if sig != embedded {
+		digest, err := archiveSHA256(a.config.OutputPath)
+		if err != nil {
+			return err
+		}
+		if digest != sig.ArchiveSHA256 {
+			return ErrDigestMismatch
+		}
+	}
+
+	return compareManifests(sig.Manifest, manifest)
+}
+
+// StoredManifest returns the manifest the writer stored in the archive at
+// OutputPath. Unlike the manifest of a signature it is written for every
+// archive, so it also carries the inconsistent marks of unsigned archives.
+func (a *Archiver) StoredManifest() (Manifest, error) {
+	tr, err := a.openArchive(a.config.OutputPath)
+	if err != nil {
+		return Manifest{}, err
+	}
+	defer tr.Close()
+
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return Manifest{}, ErrNoManifest
+		}
+		if err != nil {
+			return Manifest{}, err
+		}
+		if header.Name == manifestMetaEntry {
+			var manifest Manifest
+			err := json.NewDecoder(tr).Decode(&manifest)
+			return manifest, err
+		}
+	}
+}
+
+// readManifest hashes every entry of the archive and returns the resulting
+// manifest together with the embedded signature, if any.
+func (a *Archiver) readManifest() (Manifest, *Signature, error) {
+	var (
+		manifest Manifest
+		embedded *Signature
+	)
+
+	tr, err := a.openArchive(a.config.OutputPath)
+	if err != nil {
+		return manifest, nil, err
+	}
+	defer tr.Close()
+
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return manifest, nil, err
+		}
+
+		if header.Name == signatureEntry {
+			embedded = &Signature{}
+			if err := json.NewDecoder(tr).Decode(embedded); err != nil {
+				return manifest, nil, fmt.Errorf("%w: %v", ErrBadSignature, err)
+			}
+			continue
+		}
+		// Backup metadata is signed, other bookkeeping is not
+		if isMetaEntry(header.Name) && header.Name != backupEntry {
+			continue
+		}
+
+		h := sha256.New()
+		if _, err := io.Copy(h, tr); err != nil {
+			return manifest, nil, err
+		}
+		manifest.Entries = append(manifest.Entries, manifestEntry(header, hex.EncodeToString(h.Sum(nil))))
+	}
+
+	manifest.sort()
+	return manifest, embedded, nil
+}
+
+// compareManifests reports the first difference between the signed manifest
+// and the archive contents
+func compareManifests(signed, actual Manifest) error {
+	if len(signed.Entries) != len(actual.Entries) {
+		return fmt.Errorf("%w: signed %d entries, archive has %d",
+			ErrManifestMismatch, len(signed.Entries), len(actual.Entries))
+	}
+	for i, want := range signed.Entries {
+		// Inconsistent is a note of the writer, not part of the entry
+		got := actual.Entries[i]
+		got.Inconsistent, want.Inconsistent = false, false
+		if got != want {
+			return fmt.Errorf("%w: %s", ErrManifestMismatch, want.Name)
+		}
+	}
+	return nil
+}
+
+// archiveSHA256 hashes the stored byte stream of an archive, joining the
+// volumes of a volume set
+func archiveSHA256(path string) (string, error) {
+	raw, err := openRaw(path)
+	if err != nil {
+		return "", err
+	}
+	defer raw.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, raw); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,167 @@
+package archiver
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSignAndVerify(t *testing.T) {
+	signingKey, publicKey, err := GenerateSigningKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, otherKey, err := GenerateSigningKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, detached := range []bool{false, true} {
+		name := "embedded"
+		if detached {
+			name = "detached"
+		}
+		t.Run(name, func(t *testing.T) {
+			outputPath := filepath.Join(t.TempDir(), "signed.tar.gz")
+			config := Config{
+				SourcePath:        "testdata/source",
+				OutputPath:        outputPath,
+				Recursive:         true,
+				FilterMode:        FilterAll,
+				SigningKey:        signingKey,
+				DetachedSignature: detached,
+				TrustedKeys:       []string{publicKey},
+				Modifiable:        true,
+			}
+			a := createArchive(t, config)
+
+			if _, err := os.Stat(outputPath + signatureExtension); (err == nil) != detached {
+				t.Errorf("Expected detached signature file to exist: %v", detached)
+			}
+			if err := a.Verify(); err != nil {
+				t.Fatalf("Expected valid signature, got %v", err)
+			}
+
+			files, err := a.ListFiles()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(files) != 7 {
+				t.Errorf("Expected signature entry to be hidden, got %v", files)
+			}
+
+			untrusted := config
+			untrusted.TrustedKeys = []string{otherKey}
+			if err := New(untrusted).Verify(); !errors.Is(err, ErrUntrustedKey) {
+				t.Errorf("Expected ErrUntrustedKey, got %v", err)
+			}
+
+			dest := t.TempDir()
+			for result := range a.Extract(dest) {
+				if result.Error != nil {
+					t.Errorf("Unexpected extract error: %v", result.Error)
+				}
+			}
+			if _, err := os.Stat(filepath.Join(dest, "photo1.jpg")); err != nil {
+				t.Errorf("Expected photo1.jpg to be extracted: %v", err)
+			}
+
+			// Tamper with the archive contents
+			for result := range a.Modify([]ModifyRequest{{
+				Operation: OperationRemove,
+				Path:      "doc1.txt",
+			}}, CompressionDefault) {
+				if result.Error != nil {
+					t.Fatal(result.Error)
+				}
+			}
+
+			err = a.Verify()
+			if detached && !errors.Is(err, ErrDigestMismatch) {
+				t.Errorf("Expected ErrDigestMismatch, got %v", err)
+			}
+			if !detached && !errors.Is(err, ErrManifestMismatch) {
+				t.Errorf("Expected ErrManifestMismatch, got %v", err)
+			}
+
+			refused := t.TempDir()
+			for result := range a.Extract(refused) {
+				if result.Error == nil {
+					t.Errorf("Expected extraction of tampered archive to be refused, got %s", result.Path)
+				}
+			}
+			if entries, _ := os.ReadDir(refused); len(entries) != 0 {
+				t.Errorf("Expected nothing extracted, found %d entries", len(entries))
+			}
+
+			// Single entries are refused as well
+			if err := a.ExtractFile("photo1.jpg", refused); err == nil {
+				t.Error("Expected ExtractFile from a tampered archive to be refused")
+			}
+			if _, err := a.FS(); err == nil {
+				t.Error("Expected FS of a tampered archive to be refused")
+			}
+			if _, err := os.Stat(filepath.Join(refused, "photo1.jpg")); !os.IsNotExist(err) {
+				t.Errorf("Expected photo1.jpg not to be extracted, got %v", err)
+			}
+		})
+	}
+}
+
+func TestVerifyUnsigned(t *testing.T) {
+	a := createArchive(t, Config{
+		SourcePath: "testdata/source",
+		OutputPath: filepath.Join(t.TempDir(), "plain.tar.gz"),
+		Recursive:  true,
+		FilterMode: FilterPhotos,
+	})
+	if err := a.Verify(); !errors.Is(err, ErrNotSigned) {
+		t.Errorf("Expected ErrNotSigned, got %v", err)
+	}
+}
+
+// rewriteTar rewrites the uncompressed tar at path, passing every entry
+// through edit, which may change the header and returns the new content
+func rewriteTar(t *testing.T, path string, edit func(header *tar.Header, content []byte) []byte) {
+	t.Helper()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(bytes.NewReader(data))
+	out, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	tw := tar.NewWriter(out)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		content = edit(header, content)
+		header.Size = int64(len(content))
+		if err := tw.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,204 @@
+package archiver
+
+import (
+	"encoding/json"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// BackupLevel selects which files a backup run archives
+type BackupLevel string
+
+const (
+	LevelFull         BackupLevel = "full"         // Everything, resets the chain
+	LevelDifferential BackupLevel = "differential" // Changes since the last full backup
+	LevelIncremental  BackupLevel = "incremental"  // Changes since the last backup of any level
+)
+
+// backupEntry is the metadata entry describing a backup archive
+const backupEntry = metaPrefix + "backup.json"
+
+// SnapshotEntry records the state of a file when it was last backed up
+type SnapshotEntry struct {
+	Path    string    `json:"path"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mtime"`
+	Inode   uint64    `json:"inode,omitempty"`
+	SHA256  string    `json:"sha256,omitempty"`
+}
+
+// Snapshot is the state file carried between the runs of a backup chain.
+// Entries are keyed by archive entry name.
+type Snapshot struct {
+	Time     time.Time                `json:"time"`
+	Level    BackupLevel              `json:"level"`
+	Entries  map[string]SnapshotEntry `json:"entries"`
+	FullTime time.Time                `json:"full_time"`
+	Full     map[string]SnapshotEntry `json:"full"`
+}
+
+// BackupInfo is stored in every backup archive so that a chain can be
+// restored without the snapshot file
+type BackupInfo struct {
+	Level      BackupLevel `json:"level"`
+	Time       time.Time   `json:"time"`
+	BaseTime   time.Time   `json:"base_time,omitempty"`
+	Tombstones []string    `json:"tombstones,omitempty"`
+}
+
+// LoadSnapshot reads a snapshot file
+func LoadSnapshot(path string) (*Snapshot, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var snap Snapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return nil, err
+	}
+	return &snap, nil
+}
+
+// Save writes the snapshot file atomically
+func (s *Snapshot) Save(path string) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// backupRun tracks a backup while Create runs
+type backupRun struct {
+	info BackupInfo
+	prev *Snapshot
+	base map[string]SnapshotEntry
+
+	mu    sync.Mutex
+	state map[string]SnapshotEntry
+	// pending holds the state of changed files until they are archived
+	pending map[string]SnapshotEntry
+}
+
+// startBackup prepares a backup run, or returns nil when no backup level is
+// configured. Without a previous snapshot the run falls back to a full
+// backup.
+func (a *Archiver) startBackup() (*backupRun, error) {
+	if a.config.BackupLevel == "" {
+		return nil, nil
+	}
+
+	run := &backupRun{
+		info: BackupInfo{
+			Level: a.config.BackupLevel,
+			Time:  time.Now().UTC(),
+		},
+		state:   make(map[string]SnapshotEntry),
+		pending: make(map[string]SnapshotEntry),
+	}
+
+	if a.config.BackupLevel != LevelFull {
+		prev, err := LoadSnapshot(a.config.SnapshotPath)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		run.prev = prev
+	}
+
+	switch {
+	case run.prev == nil:
+		run.info.Level = LevelFull
+	case a.config.BackupLevel == LevelDifferential:
+		run.base = run.prev.Full
+		run.info.BaseTime = run.prev.FullTime
+	default:
+		run.base = run.prev.Entries
+		run.info.BaseTime = run.prev.Time
+	}
+	return run, nil
+}
+
+// changed reports whether a file is new or differs from the base snapshot,
+// and so must be archived. The state of a changed file is only recorded once
+// archived; until then the base entry stands, so a file that fails is still
+// seen as changed by the next run.
+func (b *backupRun) changed(name string, info FileInfo) bool {
+	current := SnapshotEntry{
+		Path:    info.Path,
+		Size:    info.Size,
+		ModTime: info.ModTime,
+		Inode:   info.Inode,
+	}
+
+	base, ok := b.base[name]
+	unchanged := ok &&
+		base.Size == current.Size &&
+		base.ModTime.Equal(current.ModTime) &&
+		base.Inode == current.Inode
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if unchanged {
+		current.SHA256 = base.SHA256
+		b.state[name] = current
+		return false
+	}
+	if ok {
+		b.state[name] = base
+	}
+	b.pending[name] = current
+	return true
+}
+
+// archived records the content hash of an archived file
+func (b *backupRun) archived(entry ManifestEntry) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	current, ok := b.pending[entry.Name]
+	if !ok {
+		return
+	}
+	current.SHA256 = entry.SHA256
+	b.state[entry.Name] = current
+	delete(b.pending, entry.Name)
+}
+
+// finish computes the tombstones for files that disappeared since the base
+// snapshot and returns the archive metadata
+func (b *backupRun) finish() BackupInfo {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.info.Tombstones = nil
+	for name := range b.base {
+		if _, ok := b.state[name]; !ok {
+			b.info.Tombstones = append(b.info.Tombstones, name)
+		}
+	}
+	sort.Strings(b.info.Tombstones)
+	return b.info
+}
+
+// save writes the snapshot for the next run of the chain
+func (b *backupRun) save(path string) error {
+	snap := &Snapshot{
+		Time:    b.info.Time,
+		Level:   b.info.Level,
+		Entries: b.state,
+	}
+	if b.info.Level == LevelFull {
+		snap.Full = b.state
+		snap.FullTime = b.info.Time
+	} else {
+		snap.Full = b.prev.Full
+		snap.FullTime = b.prev.FullTime
+	}
+	return snap.Save(path)
+}
@@ -0,0 +1,175 @@
+package archiver
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ErrSourceCollision is returned when two source roots map files to the
+// same archive path
+var ErrSourceCollision = errors.New("source roots collide")
+
+// Source is one root of a multi-root scan. Its files are stored under
+// Prefix, keeping their path relative to the root.
+type Source struct {
+	Path       string     // Directory to scan, relative to FS when FS is set
+	FS         fs.FS      // Read the root from this file system instead of the disk
+	Prefix     string     // Archive directory the files are stored under
+	Recursive  bool
+	FilterMode FilterMode // Config.FilterMode when empty
+	FileTypes  []string
+	Ignore     []string // path.Match patterns for base names or root-relative paths
+}
+
+// scanRoot is a source root prepared for scanning. Roots built from
+// Config.Sources have index > 0; the legacy single root stores files under
+// their base name.
+type scanRoot struct {
+	Source
+	index int
+}
+
+// The source helpers read scanned files from the file system of their root
+// and from the local disk otherwise. With a file system, FileInfo.Path holds
+// a slash-separated path within it.
+
+// roots returns the roots Scan walks: Config.Sources, or SourcePath and
+// SourceFS when no sources are configured
+func (a *Archiver) roots() ([]scanRoot, error) {
+	if len(a.config.Sources) == 0 {
+		root := scanRoot{Source: Source{
+			Path:      a.config.SourcePath,
+			FS:        a.config.SourceFS,
+			Recursive: a.config.Recursive,
+		}}
+		if root.FS != nil && root.Path == "" {
+			root.Path = "."
+		}
+		return []scanRoot{root}, nil
+	}
+
+	roots := make([]scanRoot, len(a.config.Sources))
+	for i, source := range a.config.Sources {
+		source.Prefix = strings.TrimPrefix(path.Clean("/"+filepath.ToSlash(source.Prefix)), "/")
+		if source.FS != nil && source.Path == "" {
+			source.Path = "."
+		}
+		for _, pattern := range source.Ignore {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("%s: %w", source.Path, err)
+			}
+		}
+		roots[i] = scanRoot{Source: source, index: i + 1}
+	}
+	return roots, nil
+}
+
+// rootOf returns the root a scanned file was found under
+func (a *Archiver) rootOf(info FileInfo) scanRoot {
+	if info.root > 0 && info.root <= len(a.config.Sources) {
+		return scanRoot{Source: a.config.Sources[info.root-1], index: info.root}
+	}
+	return scanRoot{Source: Source{FS: a.config.SourceFS}}
+}
+
+// filterFor returns the filter settings of the root a file was found under
+func (a *Archiver) filterFor(info FileInfo) (FilterMode, []string) {
+	root := a.rootOf(info)
+	if root.index > 0 && root.FilterMode != "" {
+		return root.FilterMode, root.FileTypes
+	}
+	return a.config.FilterMode, a.config.FileTypes
+}
+
+// openSource opens a scanned file
+func (a *Archiver) openSource(info FileInfo) (fs.File, error) {
+	return a.rootOf(info).open(info.Path)
+}
+
+// statSource returns the metadata of a scanned file
+func (a *Archiver) statSource(info FileInfo) (fs.FileInfo, error) {
+	return a.rootOf(info).stat(info.Path)
+}
+
+func (r scanRoot) open(name string) (fs.File, error) {
+	if r.FS != nil {
+		return r.FS.Open(name)
+	}
+	return os.Open(name)
+}
+
+func (r scanRoot) stat(name string) (fs.FileInfo, error) {
+	if r.FS != nil {
+		return fs.Stat(r.FS, name)
+	}
+	return os.Stat(name)
+}
+
+// readDir lists a directory below the root
+func (r scanRoot) readDir(name string) ([]fs.DirEntry, error) {
+	if r.FS != nil {
+		return fs.ReadDir(r.FS, name)
+	}
+	return os.ReadDir(name)
+}
+
+// join joins a directory and a name found in it
+func (r scanRoot) join(dir, name string) string {
+	if r.FS != nil {
+		return path.Join(dir, name)
+	}
+	return filepath.Join(dir, name)
+}
+
+// relative returns p relative to the root, slash-separated
+func (r scanRoot) relative(p string) string {
+	if r.FS != nil {
+		if r.Path == "." {
+			return p
+		}
+		return strings.TrimPrefix(p, r.Path+"/")
+	}
+	rel, err := filepath.Rel(r.Path, p)
+	if err != nil {
+		return filepath.ToSlash(filepath.Base(p))
+	}
+	return filepath.ToSlash(rel)
+}
+
+// entryName returns the archive name of a file found under the root, or an
+// empty string for the legacy root, whose files keep their base name
+func (r scanRoot) entryName(p string) string {
+	if r.index == 0 {
+		return ""
+	}
+	return path.Join(r.Prefix, r.relative(p))
+}
+
+// ignored reports whether p matches one of the ignore patterns of the root
+func (r scanRoot) ignored(p string) bool {
+	rel, base := r.relative(p), path.Base(filepath.ToSlash(p))
+	for _, pattern := range r.Ignore {
+		if ok, _ := path.Match(pattern, base); ok {
+			return true
+		}
+		if ok, _ := path.Match(pattern, rel); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// sampler returns f as an io.ReaderAt for compression sampling, or nil when
+// the file cannot be read at random
+func sampler(f fs.File) io.ReaderAt {
+	if r, ok := f.(io.ReaderAt); ok {
+		return r
+	}
+	return nil
+}
@@ -0,0 +1,253 @@
+package archiver
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestScanSourceFS(t *testing.T) {
+	modTime := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	source := fstest.MapFS{
+		"notes.txt":            {Data: []byte("in memory"), ModTime: modTime},
+		"album/beach.jpg":      {Data: []byte("jpeg bytes"), ModTime: modTime},
+		"album/trip/clip.mp4":  {Data: []byte("mp4 bytes"), ModTime: modTime},
+		"album/trip/plan.docx": {Data: []byte("docx bytes"), ModTime: modTime},
+	}
+
+	// An in-memory tree is archived without touching the disk
+	first := createArchive(t, Config{
+		SourceFS:   source,
+		OutputPath: filepath.Join(t.TempDir(), "memory.tar.gz"),
+		Recursive:  true,
+		FilterMode: FilterAll,
+	})
+	want := map[string]string{
+		"notes.txt": "in memory",
+		"beach.jpg": "jpeg bytes",
+		"clip.mp4":  "mp4 bytes",
+		"plan.docx": "docx bytes",
+	}
+	if got := mergedContents(t, first); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+
+	// SourcePath selects a directory within the file system
+	sub := createArchive(t, Config{
+		SourceFS:   source,
+		SourcePath: "album/trip",
+		OutputPath: filepath.Join(t.TempDir(), "trip.tar.gz"),
+		FilterMode: FilterAll,
+	})
+	want = map[string]string{"clip.mp4": "mp4 bytes", "plan.docx": "docx bytes"}
+	if got := mergedContents(t, sub); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+
+	// An existing archive is re-archived with new filters
+	fsys, err := first.FS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	photos := createArchive(t, Config{
+		SourceFS:   fsys,
+		OutputPath: filepath.Join(t.TempDir(), "photos.zip"),
+		Recursive:  true,
+		FilterMode: FilterPhotos,
+	})
+	want = map[string]string{"beach.jpg": "jpeg bytes"}
+	if got := mergedContents(t, photos); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+	info, err := photos.GetFileInfo("beach.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.ModTime.Equal(modTime) {
+		t.Errorf("Expected the source mtime %v, got %v", modTime, info.ModTime)
+	}
+
+	if _, err := New(Config{SourceFS: source, SourcePath: "missing"}).Scan(); err == nil {
+		t.Error("Expected an error for a missing source directory")
+	}
+}
+
+// linkFS lists its links as symbolic links but, like most file systems
+// other than the disk, cannot read their targets; Open and Stat follow them
+type linkFS struct {
+	fstest.MapFS
+	links map[string]string
+}
+
+func (l linkFS) Open(name string) (fs.File, error) {
+	if target, ok := l.links[name]; ok {
+		name = target
+	}
+	return l.MapFS.Open(name)
+}
+
+func (l linkFS) Stat(name string) (fs.FileInfo, error) {
+	if target, ok := l.links[name]; ok {
+		name = target
+	}
+	return l.MapFS.Stat(name)
+}
+
+func TestScanSourceFSLinks(t *testing.T) {
+	source := linkFS{
+		MapFS: fstest.MapFS{
+			"a.txt":    {Data: []byte("a")},
+			"link.txt": {Mode: fs.ModeSymlink},
+			"dangling": {Mode: fs.ModeSymlink},
+		},
+		links: map[string]string{"link.txt": "a.txt", "dangling": "missing.txt"},
+	}
+
+	// Links that cannot be stored are followed, or skipped without a target
+	a := createArchive(t, Config{
+		SourceFS:      source,
+		OutputPath:    filepath.Join(t.TempDir(), "links.tar.gz"),
+		FilterMode:    FilterAll,
+		SymlinkPolicy: SymlinkStore,
+	})
+	want := map[string]string{"a.txt": "a", "link.txt": "a"}
+	if got := mergedContents(t, a); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+}
+
+func TestScanSources(t *testing.T) {
+	modTime := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	pictures := t.TempDir()
+	for _, dir := range []string{"2024", ".cache"} {
+		if err := os.Mkdir(filepath.Join(pictures, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeSourceFile(t, filepath.Join(pictures, "a.jpg"), "a", modTime)
+	writeSourceFile(t, filepath.Join(pictures, "2024", "b.jpg"), "b", modTime)
+	writeSourceFile(t, filepath.Join(pictures, "2024", "notes.txt"), "notes", modTime)
+	writeSourceFile(t, filepath.Join(pictures, ".cache", "thumb.jpg"), "thumb", modTime)
+	camera := fstest.MapFS{
+		"DCIM/c.jpg": {Data: []byte("c"), ModTime: modTime},
+		"DCIM/d.mp4": {Data: []byte("d"), ModTime: modTime},
+	}
+
+	a := createArchive(t, Config{
+		OutputPath: filepath.Join(t.TempDir(), "backup.tar.gz"),
+		FilterMode: FilterAll,
+		Sources: []Source{
+			{Path: pictures, Prefix: "pictures", Recursive: true, FilterMode: FilterPhotos, Ignore: []string{".cache"}},
+			{Path: "DCIM", FS: camera, Prefix: "/camera/"},
+		},
+	})
+	names, err := a.ListFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(names)
+	want := []string{"camera/c.jpg", "camera/d.mp4", "pictures/2024/b.jpg", "pictures/a.jpg"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("Expected entries %v, got %v", want, names)
+	}
+
+	// Roots may share a prefix as long as their files do not collide
+	shared := createArchive(t, Config{
+		OutputPath: filepath.Join(t.TempDir(), "shared.tar.gz"),
+		FilterMode: FilterAll,
+		Sources: []Source{
+			{FS: fstest.MapFS{"a.txt": {Data: []byte("a"), ModTime: modTime}}},
+			{FS: fstest.MapFS{"b.txt": {Data: []byte("b"), ModTime: modTime}}},
+		},
+	})
+	names, err = shared.ListFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(names)
+	if want := []string{"a.txt", "b.txt"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("Expected entries %v, got %v", want, names)
+	}
+
+	// Nested prefixes collide on individual files
+	scanner := New(Config{Recursive: true, Sources: []Source{
+		{Path: pictures, Prefix: "x", Recursive: true},
+		{Path: ".", FS: fstest.MapFS{"b.jpg": {Data: []byte("b")}}, Prefix: "x/2024"},
+	}})
+	results, err := scanner.Scan()
+	if err != nil {
+		t.Fatal(err)
+	}
+	collisions := 0
+	for result := range results {
+		if errors.Is(result.Error, ErrSourceCollision) {
+			collisions++
+		}
+	}
+	if collisions != 1 {
+		t.Errorf("Expected one colliding file, got %d", collisions)
+	}
+}
+
+func TestFilesFrom(t *testing.T) {
+	paths, err := ReadPathList(strings.NewReader("a.txt\r\n\nb c.txt\n"), false)
+	if err != nil || !reflect.DeepEqual(paths, []string{"a.txt", "b c.txt"}) {
+		t.Errorf("Expected newline-delimited paths, got %q (%v)", paths, err)
+	}
+	paths, err = ReadPathList(strings.NewReader("line\nbreak.txt\x00d.txt"), true)
+	if err != nil || !reflect.DeepEqual(paths, []string{"line\nbreak.txt", "d.txt"}) {
+		t.Errorf("Expected NUL-delimited paths, got %q (%v)", paths, err)
+	}
+
+	source := "testdata/source"
+	list := strings.Join([]string{
+		filepath.Join(source, "documents", "doc1.txt"),
+		filepath.Join(source, "images", "photo1.jpg"),
+		filepath.Join(source, "videos"),
+	}, "\x00")
+	listPath := filepath.Join(t.TempDir(), "files.lst")
+	if err := os.WriteFile(listPath, []byte(list), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Listed directories are walked and the filters still apply
+	outputPath := filepath.Join(t.TempDir(), "listed.tar.gz")
+	a := createArchive(t, Config{
+		OutputPath:    outputPath,
+		Recursive:     true,
+		FilterMode:    FilterAll,
+		FileTypes:     []string{"jpg", "mp4", "txt"},
+		FilesFrom:     listPath,
+		FilesFromNull: true,
+		Modifiable:    true,
+	})
+	names, err := a.ListFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(names)
+	want := []string{"doc1.txt", "photo1.jpg", "video1.mp4"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("Expected entries %v, got %v", want, names)
+	}
+
+	// The batch operations read the same format
+	removeList := filepath.Join(t.TempDir(), "remove.lst")
+	if err := os.WriteFile(removeList, []byte("doc1.txt\nvideo1.mp4\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	result := a.BatchRemoveFilesFrom(removeList, false, 10, CompressionDefault)
+	if result.Failed != 0 {
+		t.Fatalf("Expected removals to succeed: %v", result.Errors)
+	}
+	if names, _ := a.ListFiles(); !reflect.DeepEqual(names, []string{"photo1.jpg"}) {
+		t.Errorf("Expected only photo1.jpg to remain, got %v", names)
+	}
+}
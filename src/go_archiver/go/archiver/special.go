@@ -0,0 +1,197 @@
+package archiver
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// SymlinkPolicy decides how Scan handles symbolic links
+type SymlinkPolicy string
+
+const (
+	SymlinkStore  SymlinkPolicy = "store"  // Archive the link itself; the default
+	SymlinkFollow SymlinkPolicy = "follow" // Archive the target, walking each linked directory once
+	SymlinkSkip   SymlinkPolicy = "skip"   // Leave links out
+)
+
+// SpecialPolicy decides how Scan handles FIFOs, sockets and device nodes
+type SpecialPolicy string
+
+const (
+	SpecialSkip  SpecialPolicy = "skip"  // Leave special files out; the default
+	SpecialStore SpecialPolicy = "store" // Archive FIFOs and device nodes; sockets cannot be archived and are skipped
+	SpecialError SpecialPolicy = "error" // Report special files as scan errors
+)
+
+var (
+	// ErrSpecialFile is reported for special files under SpecialError
+	ErrSpecialFile = errors.New("special file")
+	// ErrSymlinkCycle is reported when a followed link leads back to a
+	// directory that is already being archived
+	ErrSymlinkCycle = errors.New("symlink cycle")
+)
+
+const specialModes = fs.ModeNamedPipe | fs.ModeSocket | fs.ModeDevice | fs.ModeCharDevice
+
+// emptySHA256 is the manifest hash of entries without content
+const emptySHA256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// dirKey identifies a directory across links and mounts
+type dirKey struct {
+	dev, ino uint64
+}
+
+// dirKeyOf returns the key of a directory. Directories without an inode
+// number cannot be told apart.
+func dirKeyOf(info fs.FileInfo) (dirKey, bool) {
+	ino := fileInode(info)
+	if ino == 0 {
+		return dirKey{}, false
+	}
+	dev, _ := fileDevice(info)
+	return dirKey{dev: dev, ino: ino}, true
+}
+
+// containsKey reports whether key is one of keys
+func containsKey(keys []dirKey, key dirKey) bool {
+	for _, k := range keys {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+// contains reports whether p resolves to a path within the root on the
+// disk. Links within other file systems cannot be resolved.
+func (r scanRoot) contains(p string) bool {
+	if r.FS != nil {
+		return false
+	}
+	ok, err := within(r.Path, p)
+	return err == nil && ok
+}
+
+// checkScanPolicies validates the symlink and special file policies
+func (a *Archiver) checkScanPolicies() error {
+	switch a.config.SymlinkPolicy {
+	case "", SymlinkStore, SymlinkFollow, SymlinkSkip:
+	default:
+		return fmt.Errorf("%w: symlinks %s", ErrUnknownPolicy, a.config.SymlinkPolicy)
+	}
+	switch a.config.SpecialFiles {
+	case "", SpecialSkip, SpecialStore, SpecialError:
+	default:
+		return fmt.Errorf("%w: special files %s", ErrUnknownPolicy, a.config.SpecialFiles)
+	}
+	return nil
+}
+
+// lstat returns the metadata of name without following a final link. File
+// systems other than the disk cannot tell links apart and follow them.
+func (r scanRoot) lstat(name string) (fs.FileInfo, error) {
+	if r.FS != nil {
+		return fs.Stat(r.FS, name)
+	}
+	return os.Lstat(name)
+}
+
+// readlink returns the target of a link on the disk
+func (r scanRoot) readlink(name string) (string, error) {
+	if r.FS != nil {
+		return "", fmt.Errorf("%s: %w", name, errors.ErrUnsupported)
+	}
+	target, err := os.Readlink(name)
+	return filepath.ToSlash(target), err
+}
+
+// typeflagFor returns the tar entry type for a file mode
+func typeflagFor(mode fs.FileMode) byte {
+	switch {
+	case mode&fs.ModeSymlink != 0:
+		return tar.TypeSymlink
+	case mode&fs.ModeNamedPipe != 0:
+		return tar.TypeFifo
+	case mode&fs.ModeCharDevice != 0:
+		return tar.TypeChar
+	case mode&fs.ModeDevice != 0:
+		return tar.TypeBlock
+	case mode.IsDir():
+		return tar.TypeDir
+	}
+	return tar.TypeReg
+}
+
+// typeMode returns the file mode type bits of a tar entry type
+func typeMode(typeflag byte) fs.FileMode {
+	switch typeflag {
+	case tar.TypeSymlink:
+		return fs.ModeSymlink
+	case tar.TypeFifo:
+		return fs.ModeNamedPipe
+	case tar.TypeChar:
+		return fs.ModeDevice | fs.ModeCharDevice
+	case tar.TypeBlock:
+		return fs.ModeDevice
+	case tar.TypeDir:
+		return fs.ModeDir
+	}
+	return 0
+}
+
+// specialHeader returns the header of a link or special file, or nil for
+// files with content
+func specialHeader(info FileInfo) *tar.Header {
+	typeflag := typeflagFor(info.Mode)
+	switch typeflag {
+	case tar.TypeSymlink, tar.TypeFifo, tar.TypeChar, tar.TypeBlock:
+	default:
+		return nil
+	}
+	hdr := &tar.Header{
+		Name:     entryName(info),
+		Typeflag: typeflag,
+		Linkname: info.Linkname,
+		Mode:     int64(info.Mode.Perm()),
+		ModTime:  info.ModTime,
+	}
+	if typeflag == tar.TypeChar || typeflag == tar.TypeBlock {
+		hdr.Devmajor, hdr.Devminor = deviceNumbers(info.Rdev)
+	}
+	return hdr
+}
+
+// extractSymlink recreates a symbolic link, refusing targets that point
+// outside dest so later entries cannot be written through it. The target
+// is checked as written and, once the link exists, as resolved through the
+// links extracted before it.
+func extractSymlink(header *tar.Header, target, dest string) error {
+	linkname := filepath.FromSlash(header.Linkname)
+	resolved := linkname
+	if !filepath.IsAbs(resolved) {
+		resolved = filepath.Join(filepath.Dir(target), resolved)
+	}
+	rel, err := filepath.Rel(dest, resolved)
+	if filepath.IsAbs(linkname) || err != nil || escapes(rel) {
+		return fmt.Errorf("%w: %s -> %s", ErrUnsafePath, header.Name, header.Linkname)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	if err := os.Remove(target); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Symlink(linkname, target); err != nil {
+		return err
+	}
+	if ok, err := within(dest, target); err == nil && !ok {
+		os.Remove(target)
+		return fmt.Errorf("%w: %s -> %s", ErrUnsafePath, header.Name, header.Linkname)
+	}
+	return nil
+}
@@ -0,0 +1,360 @@
+//go:build unix
+
+package archiver
+
+import (
+	"archive/tar"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// archiveHeaders returns the headers of the archive at OutputPath by name
+func archiveHeaders(t *testing.T, a *Archiver) map[string]*tar.Header {
+	t.Helper()
+
+	tr, err := a.openArchive(a.config.OutputPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tr.Close()
+
+	headers := make(map[string]*tar.Header)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return headers
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !isMetaEntry(hdr.Name) {
+			headers[hdr.Name] = hdr
+		}
+	}
+}
+
+// scanErrors runs the pipeline for config and returns the archiver and the
+// errors it reported
+func scanErrors(t *testing.T, config Config) (*Archiver, []error) {
+	t.Helper()
+
+	// Report every error and still finish the archive
+	config.ErrorPolicy = ErrorContinue
+	a := New(config)
+	scanResults, err := a.Scan()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var errs []error
+	for result := range a.Create(a.Filter(scanResults)) {
+		if result.Error != nil {
+			errs = append(errs, result.Error)
+		}
+	}
+	return a, errs
+}
+
+func TestSymlinkPolicies(t *testing.T) {
+	source := t.TempDir()
+	if err := os.Mkdir(filepath.Join(source, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string]string{"a.txt": "alpha", "sub/b.txt": "beta"} {
+		if err := os.WriteFile(filepath.Join(source, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink("a.txt", filepath.Join(source, "link.txt")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("..", filepath.Join(source, "sub", "loop")); err != nil {
+		t.Fatal(err)
+	}
+	if err := syscall.Mkfifo(filepath.Join(source, "pipe"), 0644); err != nil {
+		t.Skipf("mkfifo: %v", err)
+	}
+
+	// Entries keep their relative path, so extracted links resolve
+	config := Config{Sources: []Source{{Path: source, Recursive: true}}, FilterMode: FilterAll}
+	archive := func(name string) string { return filepath.Join(t.TempDir(), name) }
+
+	// Links are stored as links by default and special files are skipped
+	config.OutputPath = archive("store.tar.gz")
+	a := createArchive(t, config)
+	headers := archiveHeaders(t, a)
+	if hdr := headers["link.txt"]; hdr == nil || hdr.Typeflag != tar.TypeSymlink || hdr.Linkname != "a.txt" {
+		t.Errorf("Expected link.txt stored as a link to a.txt, got %+v", hdr)
+	}
+	if hdr := headers["sub/loop"]; hdr == nil || hdr.Typeflag != tar.TypeSymlink {
+		t.Errorf("Expected loop stored as a link, got %+v", hdr)
+	}
+	if _, ok := headers["pipe"]; ok {
+		t.Error("Expected the FIFO to be skipped")
+	}
+	dest := t.TempDir()
+	for result := range a.Extract(dest) {
+		if result.Error != nil {
+			t.Fatal(result.Error)
+		}
+	}
+	if target, err := os.Readlink(filepath.Join(dest, "link.txt")); err != nil || target != "a.txt" {
+		t.Errorf("Expected an extracted link to a.txt, got %q (%v)", target, err)
+	}
+
+	// Links are followed once; the loop back to the root is a cycle
+	config.OutputPath = archive("follow.tar.gz")
+	config.SymlinkPolicy = SymlinkFollow
+	a, errs := scanErrors(t, config)
+	if len(errs) != 1 || !errors.Is(errs[0], ErrSymlinkCycle) {
+		t.Errorf("Expected one ErrSymlinkCycle, got %v", errs)
+	}
+	headers = archiveHeaders(t, a)
+	if hdr := headers["link.txt"]; hdr == nil || hdr.Typeflag == tar.TypeSymlink || hdr.Size != 5 {
+		t.Errorf("Expected link.txt stored with the content of a.txt, got %+v", hdr)
+	}
+	if len(headers) != 3 {
+		t.Errorf("Expected a.txt, sub/b.txt and link.txt, got %d entries", len(headers))
+	}
+
+	config.OutputPath = archive("skip.tar.gz")
+	config.SymlinkPolicy = SymlinkSkip
+	headers = archiveHeaders(t, createArchive(t, config))
+	if _, ok := headers["link.txt"]; ok || len(headers) != 2 {
+		t.Errorf("Expected links to be skipped, got %d entries", len(headers))
+	}
+
+	// Special files are archived or reported on request
+	config.OutputPath = archive("special.tar")
+	config.SpecialFiles = SpecialStore
+	headers = archiveHeaders(t, createArchive(t, config))
+	if hdr := headers["pipe"]; hdr == nil || hdr.Typeflag != tar.TypeFifo {
+		t.Errorf("Expected pipe stored as a FIFO, got %+v", hdr)
+	}
+
+	config.OutputPath = archive("error.tar")
+	config.SpecialFiles = SpecialError
+	if _, errs := scanErrors(t, config); len(errs) != 1 || !errors.Is(errs[0], ErrSpecialFile) {
+		t.Errorf("Expected one ErrSpecialFile, got %v", errs)
+	}
+
+	config.SymlinkPolicy = "sometimes"
+	if _, err := New(config).Scan(); !errors.Is(err, ErrUnknownPolicy) {
+		t.Errorf("Expected ErrUnknownPolicy, got %v", err)
+	}
+}
+
+func TestSymlinkZipRoundTrip(t *testing.T) {
+	source := t.TempDir()
+	if err := os.WriteFile(filepath.Join(source, "a.txt"), []byte("alpha"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("a.txt", filepath.Join(source, "link.txt")); err != nil {
+		t.Fatal(err)
+	}
+
+	a := createArchive(t, Config{
+		SourcePath: source,
+		OutputPath: filepath.Join(t.TempDir(), "links.zip"),
+		FilterMode: FilterAll,
+	})
+	if hdr := archiveHeaders(t, a)["link.txt"]; hdr == nil || hdr.Typeflag != tar.TypeSymlink || hdr.Linkname != "a.txt" {
+		t.Errorf("Expected link.txt read back as a link to a.txt, got %+v", hdr)
+	}
+}
+
+func TestSymlinkTraversal(t *testing.T) {
+	base := t.TempDir()
+	dest := filepath.Join(base, "a", "b", "dest")
+	if err := os.MkdirAll(dest, 0755); err != nil {
+		t.Fatal(err)
+	}
+	outside := filepath.Join(base, "outside.txt")
+	if err := os.WriteFile(outside, []byte("keep"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// A link left over from an earlier extraction
+	if err := os.Symlink(outside, filepath.Join(dest, "stale.txt")); err != nil {
+		t.Fatal(err)
+	}
+
+	archivePath := filepath.Join(t.TempDir(), "evil.tar")
+	f, err := os.Create(archivePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tw := tar.NewWriter(f)
+	for _, hdr := range []*tar.Header{
+		{Name: "foo", Typeflag: tar.TypeSymlink, Linkname: "."},
+		{Name: "bar", Typeflag: tar.TypeSymlink, Linkname: "foo/foo/../.."},
+		{Name: "bar/evil.txt", Typeflag: tar.TypeReg, Size: 4, Mode: 0644},
+		{Name: "stale.txt", Typeflag: tar.TypeReg, Size: 4, Mode: 0644},
+	} {
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Size > 0 {
+			tw.Write([]byte("evil"))
+		}
+	}
+	tw.Close()
+	f.Close()
+
+	var unsafe []string
+	for result := range New(Config{OutputPath: archivePath}).Extract(dest) {
+		if errors.Is(result.Error, ErrUnsafePath) {
+			unsafe = append(unsafe, result.Path)
+		} else if result.Error != nil {
+			t.Fatal(result.Error)
+		}
+	}
+	if len(unsafe) == 0 {
+		t.Error("Expected the escaping link to be rejected")
+	}
+	for _, dir := range []string{base, filepath.Join(base, "a"), filepath.Join(base, "a", "b")} {
+		if _, err := os.Stat(filepath.Join(dir, "evil.txt")); err == nil {
+			t.Errorf("Expected nothing written to %s", dir)
+		}
+	}
+	if data, err := os.ReadFile(outside); err != nil || string(data) != "keep" {
+		t.Errorf("Expected the file behind the stale link untouched, got %q (%v)", data, err)
+	}
+	if info, err := os.Lstat(filepath.Join(dest, "stale.txt")); err != nil || !info.Mode().IsRegular() {
+		t.Errorf("Expected the stale link replaced by a file, got %v (%v)", info, err)
+	}
+}
+
+func TestDiffDirectoryState(t *testing.T) {
+	source := t.TempDir()
+	outside := filepath.Join(t.TempDir(), "target.txt")
+	base := time.Date(2024, 1, 1, 0, 0, 0, 700*int(time.Millisecond), time.UTC)
+	writeSourceFile(t, outside, "target", base)
+	writeSourceFile(t, filepath.Join(source, "late.txt"), "late", base)
+	writeSourceFile(t, filepath.Join(source, "private.txt"), "private", base)
+	if err := os.Chmod(filepath.Join(source, "private.txt"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(source, "link")); err != nil {
+		t.Fatal(err)
+	}
+	a := createArchive(t, Config{
+		SourcePath: source,
+		OutputPath: filepath.Join(t.TempDir(), "out.tar.gz"),
+		FilterMode: FilterAll,
+	})
+
+	// Rounded mtimes, stored modes and links match the directory
+	report, err := a.Diff(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Entries) != 0 {
+		t.Errorf("Expected no differences, got:\n%s", report)
+	}
+
+	// The target of a link is not part of the link
+	writeSourceFile(t, outside, "retargeted", base)
+	if err := os.Chmod(filepath.Join(source, "private.txt"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	report, err = a.Diff(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []DiffEntry{{Kind: DiffModified, Path: "private.txt", Changes: []string{"mode"}}}
+	if !reflect.DeepEqual(report.Entries, want) {
+		t.Errorf("Expected only the mode change, got:\n%s", report)
+	}
+}
+
+func TestSymlinkSecondRoute(t *testing.T) {
+	source := t.TempDir()
+	external := t.TempDir()
+	if err := os.Mkdir(filepath.Join(source, "photos"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeSourceFile(t, filepath.Join(source, "photos", "pic.jpg"), "pic", time.Now())
+	writeSourceFile(t, filepath.Join(external, "shared.txt"), "shared", time.Now())
+	if err := os.Symlink("photos", filepath.Join(source, "latest")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(external, filepath.Join(source, "external")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Another route to a directory of the root is no cycle, and the
+	// directory is archived once under its own path
+	for i := 0; i < 5; i++ {
+		a, errs := scanErrors(t, Config{
+			Sources:       []Source{{Path: source, Recursive: true}},
+			OutputPath:    filepath.Join(t.TempDir(), "out.tar.gz"),
+			FilterMode:    FilterAll,
+			SymlinkPolicy: SymlinkFollow,
+		})
+		if len(errs) != 0 {
+			t.Fatalf("Expected no errors, got %v", errs)
+		}
+		headers := archiveHeaders(t, a)
+		if _, ok := headers["photos/pic.jpg"]; !ok || len(headers) != 2 {
+			t.Errorf("Expected photos/pic.jpg and external/shared.txt, got %v", headers)
+		}
+		if _, ok := headers["external/shared.txt"]; !ok {
+			t.Errorf("Expected the linked directory outside the root to be walked, got %v", headers)
+		}
+	}
+}
+
+func TestVerifyRetargetedSymlink(t *testing.T) {
+	signingKey, publicKey, err := GenerateSigningKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	source := t.TempDir()
+	writeSourceFile(t, filepath.Join(source, "a.txt"), "alpha", time.Now())
+	if err := os.Symlink("a.txt", filepath.Join(source, "link")); err != nil {
+		t.Fatal(err)
+	}
+	a := createArchive(t, Config{
+		SourcePath:  source,
+		OutputPath:  filepath.Join(t.TempDir(), "signed.tar"),
+		FilterMode:  FilterAll,
+		SigningKey:  signingKey,
+		TrustedKeys: []string{publicKey},
+	})
+	if err := a.Verify(); err != nil {
+		t.Fatalf("Expected valid signature, got %v", err)
+	}
+
+	// Point the link elsewhere
+	rewriteTar(t, a.config.OutputPath, func(header *tar.Header, content []byte) []byte {
+		if header.Name == "link" {
+			header.Linkname = "b.txt"
+		}
+		return content
+	})
+	if err := a.Verify(); !errors.Is(err, ErrManifestMismatch) {
+		t.Errorf("Expected a retargeted link to fail verification, got %v", err)
+	}
+	for result := range a.Extract(t.TempDir()) {
+		if result.Error == nil {
+			t.Errorf("Expected extraction to be refused, got %s", result.Path)
+		}
+	}
+
+	// Loosen the permissions of a file
+	createArchive(t, a.config)
+	rewriteTar(t, a.config.OutputPath, func(header *tar.Header, content []byte) []byte {
+		if header.Name == "a.txt" {
+			header.Mode = 0777
+		}
+		return content
+	})
+	if err := a.Verify(); !errors.Is(err, ErrManifestMismatch) {
+		t.Errorf("Expected a changed mode to fail verification, got %v", err)
+	}
+}
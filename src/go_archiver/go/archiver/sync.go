@@ -0,0 +1,187 @@
+package archiver
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"sort"
+	"time"
+)
+
+// Sync mirrors SourcePath into the archive at OutputPath: files missing from
+// the archive are added, changed files are updated and entries without a
+// source file are removed, all in a single rewrite. Files are compared by
+// size and modification time, or by size and content hash when compareHash
+// is set.
+func (a *Archiver) Sync(compareHash bool, compression CompressionLevel) <-chan ModifyResult {
+	requests, err := a.SyncRequests(compareHash)
+	if err != nil {
+		out := make(chan ModifyResult, 1)
+		out <- ModifyResult{Error: err}
+		close(out)
+		return out
+	}
+	return a.Modify(requests, compression)
+}
+
+// SyncRequests returns the modifications Sync would apply, without changing
+// the archive
+func (a *Archiver) SyncRequests(compareHash bool) ([]ModifyRequest, error) {
+	archived, err := a.archivedEntries(compareHash)
+	if err != nil {
+		return nil, err
+	}
+
+	scanResults, err := a.Scan()
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		requests []ModifyRequest
+		seen     = make(map[string]bool)
+		firstErr error
+	)
+	for result := range a.Filter(scanResults) {
+		if result.Error != nil {
+			if firstErr == nil {
+				firstErr = result.Error
+			}
+			continue
+		}
+		if result.FileInfo.IsDir {
+			continue
+		}
+
+		info := result.FileInfo
+		name := entryName(info)
+		seen[name] = true
+
+		entry, ok := archived[name]
+		if !ok {
+			requests = append(requests, ModifyRequest{
+				Operation: OperationAdd,
+				Path:      name,
+				FileInfo:  info,
+			})
+			continue
+		}
+
+		changed, err := a.fileChanged(entry, info, compareHash)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		if changed {
+			requests = append(requests, ModifyRequest{
+				Operation: OperationUpdate,
+				Path:      name,
+				FileInfo:  info,
+			})
+		}
+	}
+	// A partial scan would remove entries for files that were not read
+	if firstErr != nil {
+		return nil, firstErr
+	}
+
+	var removed []string
+	for name := range archived {
+		if !seen[name] {
+			removed = append(removed, name)
+		}
+	}
+	sort.Strings(removed)
+	for _, name := range removed {
+		requests = append(requests, ModifyRequest{
+			Operation: OperationRemove,
+			Path:      name,
+		})
+	}
+	return requests, nil
+}
+
+// archivedEntry is the state of an archive entry as seen by Sync
+type archivedEntry struct {
+	size    int64
+	modTime time.Time
+	sha256  string
+}
+
+// archivedEntries indexes the archive at OutputPath, hashing the entries
+// when withHash is set. A missing archive has no entries.
+func (a *Archiver) archivedEntries(withHash bool) (map[string]archivedEntry, error) {
+	entries := make(map[string]archivedEntry)
+
+	tr, err := a.openArchive(a.config.OutputPath)
+	if os.IsNotExist(err) {
+		return entries, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer tr.Close()
+
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if isMetaEntry(header.Name) {
+			continue
+		}
+
+		entry := archivedEntry{size: header.Size, modTime: header.ModTime}
+		if withHash {
+			h := sha256.New()
+			if _, err := io.Copy(h, tr); err != nil {
+				return nil, err
+			}
+			entry.sha256 = hex.EncodeToString(h.Sum(nil))
+		}
+		entries[header.Name] = entry
+	}
+}
+
+// fileChanged reports whether the source file differs from its entry.
+// Archive timestamps are rounded to whole seconds.
+func (a *Archiver) fileChanged(entry archivedEntry, info FileInfo, compareHash bool) (bool, error) {
+	if specialHeader(info) != nil {
+		// Links and special files are archived without content
+		compareHash = false
+		info.Size = 0
+	}
+	if entry.size != info.Size {
+		return true, nil
+	}
+	if !compareHash {
+		return !entry.modTime.Round(time.Second).Equal(info.ModTime.Round(time.Second)), nil
+	}
+
+	sum, err := a.sourceSHA256(info)
+	if err != nil {
+		return false, err
+	}
+	return sum != entry.sha256, nil
+}
+
+// sourceSHA256 hashes the contents of a scanned file
+func (a *Archiver) sourceSHA256(info FileInfo) (string, error) {
+	f, err := a.openSource(info)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
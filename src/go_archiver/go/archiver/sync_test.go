@@ -0,0 +1,164 @@
+package archiver
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestModifySinglePass(t *testing.T) {
+	source := t.TempDir()
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, name := range []string{"a.txt", "b.txt", "c.txt"} {
+		writeSourceFile(t, filepath.Join(source, name), name, base)
+	}
+	output := filepath.Join(t.TempDir(), "archive.tar.gz")
+	a := createArchive(t, Config{
+		SourcePath: source,
+		OutputPath: output,
+		FilterMode: FilterAll,
+		Modifiable: true,
+	})
+
+	extra := filepath.Join(t.TempDir(), "d.txt")
+	writeSourceFile(t, extra, "d", base)
+	writeSourceFile(t, filepath.Join(source, "b.txt"), "new b", base)
+
+	requests := []ModifyRequest{
+		{Operation: OperationRemove, Path: "a.txt"},
+		{Operation: OperationUpdate, Path: "b.txt", FileInfo: FileInfo{Path: filepath.Join(source, "b.txt")}},
+		{Operation: OperationAdd, Path: "d.txt", FileInfo: FileInfo{Path: extra}},
+		{Operation: OperationRemove, Path: "missing.txt"},
+	}
+	var failed []string
+	for result := range a.Modify(requests, CompressionDefault) {
+		if result.Error != nil {
+			failed = append(failed, result.Path)
+		}
+	}
+	if !reflect.DeepEqual(failed, []string{"missing.txt"}) {
+		t.Errorf("Expected only missing.txt to fail, got %v", failed)
+	}
+
+	files, err := a.ListFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(files)
+	if !reflect.DeepEqual(files, []string{"b.txt", "c.txt", "d.txt"}) {
+		t.Errorf("Unexpected archive contents: %v", files)
+	}
+	if entry, err := a.GetFileInfo("b.txt"); err != nil || entry.Size != int64(len("new b")) {
+		t.Errorf("Expected b.txt to be updated, got %+v (%v)", entry, err)
+	}
+}
+
+func TestSync(t *testing.T) {
+	source := t.TempDir()
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, name := range []string{"a.txt", "b.txt", "c.txt"} {
+		writeSourceFile(t, filepath.Join(source, name), name, base)
+	}
+	a := createArchive(t, Config{
+		SourcePath: source,
+		OutputPath: filepath.Join(t.TempDir(), "archive.zip"),
+		FilterMode: FilterAll,
+		Modifiable: true,
+	})
+
+	for _, compareHash := range []bool{false, true} {
+		requests, err := a.SyncRequests(compareHash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(requests) != 0 {
+			t.Errorf("Expected a fresh archive to be in sync, got %+v", requests)
+		}
+	}
+
+	// Same size and content with a new mtime only counts without hashing
+	writeSourceFile(t, filepath.Join(source, "a.txt"), "a.txt", base.Add(time.Hour))
+	writeSourceFile(t, filepath.Join(source, "b.txt"), "B.TXT", base)
+	if err := os.Remove(filepath.Join(source, "c.txt")); err != nil {
+		t.Fatal(err)
+	}
+	writeSourceFile(t, filepath.Join(source, "d.txt"), "d.txt", base)
+
+	requests, err := a.SyncRequests(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string]ModifyOperation)
+	for _, req := range requests {
+		got[req.Path] = req.Operation
+	}
+	want := map[string]ModifyOperation{
+		"b.txt": OperationUpdate,
+		"c.txt": OperationRemove,
+		"d.txt": OperationAdd,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+
+	for result := range a.Sync(true, CompressionDefault) {
+		if result.Error != nil {
+			t.Fatalf("Sync failed for %s: %v", result.Path, result.Error)
+		}
+	}
+	requests, err = a.SyncRequests(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(requests) != 0 {
+		t.Errorf("Expected archive to mirror the source after Sync, got %+v", requests)
+	}
+	// The mtime of a.txt was never synced
+	requests, err = a.SyncRequests(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(requests) != 1 || requests[0].Path != "a.txt" {
+		t.Errorf("Expected a.txt to differ by mtime, got %+v", requests)
+	}
+
+	dest := t.TempDir()
+	for result := range a.Extract(dest) {
+		if result.Error != nil {
+			t.Fatal(result.Error)
+		}
+	}
+	data, err := os.ReadFile(filepath.Join(dest, "b.txt"))
+	if err != nil || string(data) != "B.TXT" {
+		t.Errorf("Expected updated b.txt, got %q (%v)", data, err)
+	}
+}
+
+func TestSyncSubsecondModTimes(t *testing.T) {
+	source := t.TempDir()
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	writeSourceFile(t, filepath.Join(source, "early.txt"), "early", base.Add(200*time.Millisecond))
+	writeSourceFile(t, filepath.Join(source, "late.txt"), "late", base.Add(700*time.Millisecond))
+
+	for _, output := range []string{"archive.tar.gz", "archive.zip"} {
+		for _, reproducible := range []bool{false, true} {
+			a := createArchive(t, Config{
+				SourcePath:   source,
+				OutputPath:   filepath.Join(t.TempDir(), output),
+				FilterMode:   FilterAll,
+				Modifiable:   true,
+				Reproducible: reproducible,
+			})
+			requests, err := a.SyncRequests(false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(requests) != 0 {
+				t.Errorf("%s reproducible=%v: Expected a fresh archive to be in sync, got %+v", output, reproducible, requests)
+			}
+		}
+	}
+}
@@ -1,6 +1,8 @@
 package archiver
 
 import (
+	"errors"
+	"io/fs"
 	"sync"
 	"time"
 )
@@ -20,11 +22,55 @@ type FileType struct {
 
 type Config struct {
 	SourcePath  string
+	SourceFS    fs.FS    // Scan this file system instead of the disk; SourcePath is then relative to it
+	Sources     []Source // Scan several roots into one archive instead of SourcePath
 	OutputPath  string
 	Recursive   bool
 	FilterMode  FilterMode
 	FileTypes   []string
 	Modifiable  bool
+	Recipients  []string // X25519 public keys the archive is encrypted to
+	Identities  []string // X25519 private keys used to open encrypted archives
+
+	Format          OutputFormat // Output container, inferred from OutputPath when empty
+	StoreExtensions []string     // Extensions stored without compression in zip archives
+
+	Codec            Codec            // Compression of tar output, implied by Format when empty
+	CompressionLevel CompressionLevel // Zero selects CompressionDefault
+	VolumeSize       int64            // Split output into volumes of this many bytes, zero for one file
+	Seekable         bool             // Compress each tar.gz entry separately and append an index
+	SplitBy          SplitMode        // Route files into one archive per category or month
+
+	BackupLevel  BackupLevel // Full, differential or incremental backup; empty archives everything
+	SnapshotPath string      // State file shared by the runs of a backup chain
+
+	CompressionPolicy CompressionPolicy // Per-entry compression policy, PolicyDeflate when empty
+	StoreThreshold    float64           // Sampled ratio above which PolicyAuto stores an entry
+
+	SigningKey        string   // Ed25519 private key used to sign new archives
+	DetachedSignature bool     // Write the signature to OutputPath.sig instead of embedding it
+	TrustedKeys       []string // Ed25519 public keys accepted by Verify and Extract
+
+	FilesFrom     string // Archive the paths listed in this file, "-" for stdin, instead of scanning
+	FilesFromNull bool   // FilesFrom is NUL-delimited rather than newline-delimited
+
+	SymlinkPolicy SymlinkPolicy // How Scan handles symbolic links, SymlinkStore when empty
+	SpecialFiles  SpecialPolicy // How Scan handles FIFOs, sockets and devices, SpecialSkip when empty
+	OneFileSystem bool          // Do not descend into directories on other devices
+
+	Reproducible    bool      // Sort entries and normalise metadata so identical trees give identical archives
+	SourceDateEpoch time.Time // Clamp mtimes of reproducible archives; $SOURCE_DATE_EPOCH when zero
+
+	ChangedFiles  ChangePolicy // What Create does with files that change while read, ChangeFail when empty
+	ChangeRetries int          // Rereads under ChangeRetry, DefaultChangeRetries when zero
+
+	ErrorPolicy  ErrorPolicy   // When Create gives up after files fail, ErrorFailFast when empty
+	MaxErrors    int           // Failed files tolerated under ErrorMaxErrors
+	IORetries    int           // Retries of transient IO errors, DefaultIORetries when zero, none when negative
+	IORetryDelay time.Duration // First retry delay, doubled per retry; DefaultIORetryDelay when zero
+
+	CheckpointPath  string // Checkpoint Create to this file so Resume can continue it; empty disables
+	CheckpointEvery int64  // Bytes archived between checkpoints, DefaultCheckpointEvery when zero
 }
 
 type FileInfo struct {
@@ -32,6 +78,14 @@ type FileInfo struct {
 	MimeType string
 	Size     int64
 	IsDir    bool
+	ModTime  time.Time
+	Inode    uint64
+	Name     string // Archive name for multi-root scans; the base name of Path when empty
+	Mode     fs.FileMode
+	Linkname string // Target of a symbolic link stored as a link
+	Rdev     uint64 // Device represented by a device node
+
+	root int // 1-based index into Config.Sources
 }
 
 // Supported formats
@@ -70,7 +124,8 @@ type Result struct {
 	TypeCounts    FileTypeCount
 	Progress      float64 // 0-100
 	TotalFiles    int64  // For progress calculation
-	Error         error
+	Error         error  // First error reported
+	Errors        []error // Every error reported, in order
 }
 
 // Archiver handles the archiving process
@@ -78,6 +133,13 @@ type Archiver struct {
 	config Config
 	mu     sync.RWMutex
 	result Result
+
+	// index caches the listing of the archive at OutputPath
+	indexMu sync.Mutex
+	index   *cachedIndex
+
+	// tracker counts failed files across the archives of one Route
+	tracker *errorTracker
 }
 
 // New creates a new Archiver instance
@@ -101,7 +163,10 @@ func (a *Archiver) UpdateResult(filesProcessed, totalSize int64, fileExt string,
 	defer a.mu.Unlock()
 	
 	if err != nil {
-		a.result.Error = err
+		if a.result.Error == nil {
+			a.result.Error = err
+		}
+		a.result.Errors = append(a.result.Errors, err)
 		return
 	}
 	
@@ -123,6 +188,14 @@ func (a *Archiver) UpdateResult(filesProcessed, totalSize int64, fileExt string,
 	}
 }
 
+// GetErrors returns every error reported so far joined into one, which
+// errors.Is and errors.As search; nil when there were none
+func (a *Archiver) GetErrors() error {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return errors.Join(a.result.Errors...)
+}
+
 // SetTotalFiles sets the total number of files for progress calculation
 func (a *Archiver) SetTotalFiles(total int64) {
 	a.mu.Lock()
@@ -0,0 +1,380 @@
+package archiver
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Volume errors
+var (
+	ErrMissingVolume  = errors.New("archive volume is missing")
+	ErrBadVolume      = errors.New("archive volume is invalid")
+	ErrVolumeTooSmall = errors.New("volume size is too small")
+)
+
+const (
+	volumeMagic = "GARCVOL1"
+	// volumeHeaderSize covers magic, set id, index, count and volume size
+	volumeHeaderSize  = len(volumeMagic) + 16 + 4 + 4 + 8
+	volumeCountOffset = len(volumeMagic) + 16 + 4
+)
+
+// volumeName returns the file name of the n-th volume, counting from 1
+func volumeName(base string, n int) string {
+	return fmt.Sprintf("%s.%03d", base, n)
+}
+
+// volumeHeader starts every volume of a set. The count is written as zero
+// and filled in once the set is complete.
+type volumeHeader struct {
+	setID [16]byte
+	index uint32
+	count uint32
+	size  int64
+}
+
+func (h volumeHeader) marshal() []byte {
+	buf := make([]byte, 0, volumeHeaderSize)
+	buf = append(buf, volumeMagic...)
+	buf = append(buf, h.setID[:]...)
+	buf = binary.BigEndian.AppendUint32(buf, h.index)
+	buf = binary.BigEndian.AppendUint32(buf, h.count)
+	buf = binary.BigEndian.AppendUint64(buf, uint64(h.size))
+	return buf
+}
+
+func readVolumeHeader(r io.Reader, name string) (volumeHeader, error) {
+	var h volumeHeader
+	buf := make([]byte, volumeHeaderSize)
+	if _, err := io.ReadFull(r, buf); err != nil || !bytes.HasPrefix(buf, []byte(volumeMagic)) {
+		return h, fmt.Errorf("%w: %s has no volume header", ErrBadVolume, name)
+	}
+	rest := buf[len(volumeMagic):]
+	copy(h.setID[:], rest[:16])
+	h.index = binary.BigEndian.Uint32(rest[16:20])
+	h.count = binary.BigEndian.Uint32(rest[20:24])
+	h.size = int64(binary.BigEndian.Uint64(rest[24:32]))
+	return h, nil
+}
+
+// volumeWriter splits a byte stream into numbered volumes of a fixed size.
+// Since the stream is split at byte level, entries that cross a boundary
+// simply continue in the next volume.
+type volumeWriter struct {
+	base   string
+	header volumeHeader
+	names  []string
+	cur    *os.File
+	left   int64
+}
+
+func newVolumeWriter(base string, size int64) (*volumeWriter, error) {
+	if size <= int64(volumeHeaderSize) {
+		return nil, fmt.Errorf("%w: must exceed %d bytes", ErrVolumeTooSmall, volumeHeaderSize)
+	}
+	vw := &volumeWriter{
+		base:   base,
+		header: volumeHeader{size: size},
+	}
+	if _, err := rand.Read(vw.header.setID[:]); err != nil {
+		return nil, err
+	}
+	return vw, nil
+}
+
+// rotate closes the current volume and starts the next one
+func (v *volumeWriter) rotate() error {
+	if v.cur != nil {
+		if err := v.cur.Close(); err != nil {
+			return err
+		}
+	}
+
+	name := volumeName(v.base, len(v.names)+1)
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	v.names = append(v.names, name)
+	v.cur = f
+
+	h := v.header
+	h.index = uint32(len(v.names))
+	if _, err := f.Write(h.marshal()); err != nil {
+		return err
+	}
+	v.left = v.header.size - int64(volumeHeaderSize)
+	return nil
+}
+
+func (v *volumeWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		// Volumes are only started once there is data for them
+		if v.cur == nil || v.left == 0 {
+			if err := v.rotate(); err != nil {
+				return written, err
+			}
+		}
+		chunk := p
+		if int64(len(chunk)) > v.left {
+			chunk = chunk[:v.left]
+		}
+		n, err := v.cur.Write(chunk)
+		written += n
+		v.left -= int64(n)
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// Close finishes the set by recording the volume count in every volume and
+// removes volumes left over from an earlier, longer set.
+func (v *volumeWriter) Close() error {
+	if v.cur == nil {
+		if err := v.rotate(); err != nil {
+			return err
+		}
+	}
+	if err := v.cur.Close(); err != nil {
+		return err
+	}
+
+	count := make([]byte, 4)
+	binary.BigEndian.PutUint32(count, uint32(len(v.names)))
+	for _, name := range v.names {
+		f, err := os.OpenFile(name, os.O_WRONLY, 0)
+		if err != nil {
+			return err
+		}
+		_, err = f.WriteAt(count, int64(volumeCountOffset))
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	removeVolumes(v.base, len(v.names)+1)
+	return nil
+}
+
+// removeVolumes deletes consecutive volumes of base starting at from
+func removeVolumes(base string, from int) {
+	for n := from; os.Remove(volumeName(base, n)) == nil; n++ {
+	}
+}
+
+// volumeReader joins the volumes of a set back into one stream, checking
+// that each volume belongs to the set and is in sequence.
+type volumeReader struct {
+	base   string
+	header volumeHeader
+	next   uint32
+	cur    *os.File
+}
+
+// openVolumes opens the volume set stored under base
+func openVolumes(base string) (*volumeReader, error) {
+	first := volumeName(base, 1)
+	f, err := os.Open(first)
+	if os.IsNotExist(err) {
+		if _, serr := os.Stat(volumeName(base, 2)); serr == nil {
+			return nil, fmt.Errorf("%w: %s", ErrMissingVolume, first)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	h, err := readVolumeHeader(f, first)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if h.index != 1 {
+		f.Close()
+		return nil, fmt.Errorf("%w: %s is volume %d", ErrBadVolume, first, h.index)
+	}
+	if h.count == 0 {
+		f.Close()
+		return nil, fmt.Errorf("%w: %s belongs to an unfinished set", ErrBadVolume, first)
+	}
+
+	vr := &volumeReader{base: base, header: h, next: 2, cur: f}
+	if err := vr.checkSize(f, first, 1); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return vr, nil
+}
+
+// checkSize rejects truncated volumes; all but the last are full-sized
+func (v *volumeReader) checkSize(f *os.File, name string, index uint32) error {
+	if index == v.header.count {
+		return nil
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() != v.header.size {
+		return fmt.Errorf("%w: %s is truncated", ErrBadVolume, name)
+	}
+	return nil
+}
+
+// advance opens the next volume of the set
+func (v *volumeReader) advance() error {
+	name := volumeName(v.base, int(v.next))
+	f, err := os.Open(name)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("%w: %s", ErrMissingVolume, name)
+	}
+	if err != nil {
+		return err
+	}
+
+	h, err := readVolumeHeader(f, name)
+	if err == nil && (h.setID != v.header.setID || h.index != v.next) {
+		err = fmt.Errorf("%w: %s does not belong to this set", ErrBadVolume, name)
+	}
+	if err == nil {
+		err = v.checkSize(f, name, v.next)
+	}
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	v.cur = f
+	v.next++
+	return nil
+}
+
+func (v *volumeReader) Read(p []byte) (int, error) {
+	for {
+		if v.cur == nil {
+			if v.next > v.header.count {
+				return 0, io.EOF
+			}
+			if err := v.advance(); err != nil {
+				return 0, err
+			}
+		}
+		n, err := v.cur.Read(p)
+		if err == io.EOF {
+			v.cur.Close()
+			v.cur = nil
+			if n > 0 {
+				return n, nil
+			}
+			continue
+		}
+		return n, err
+	}
+}
+
+// Close releases the current volume
+func (v *volumeReader) Close() error {
+	if v.cur == nil {
+		return nil
+	}
+	err := v.cur.Close()
+	v.cur = nil
+	return err
+}
+
+// rawArchive is the stored byte stream of an archive, with volumes joined
+type rawArchive struct {
+	io.ReadCloser
+	// file is set for single-file archives and allows random access
+	file       *os.File
+	volumeSize int64
+}
+
+// openRaw opens the archive at path, either a single file or a volume set
+// named path.001, path.002, ...
+func openRaw(path string) (*rawArchive, error) {
+	f, err := os.Open(path)
+	if err == nil {
+		return &rawArchive{ReadCloser: f, file: f}, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	vr, verr := openVolumes(path)
+	if verr != nil {
+		if os.IsNotExist(verr) {
+			return nil, err
+		}
+		return nil, verr
+	}
+	return &rawArchive{ReadCloser: vr, volumeSize: vr.header.size}, nil
+}
+
+// createOutput creates a new archive at path, split into volumes when
+// volumeSize is set. Volumes or a single file left over from a previous
+// archive at the same path are replaced.
+func createOutput(path string, volumeSize int64) (io.WriteCloser, error) {
+	if volumeSize > 0 {
+		vw, err := newVolumeWriter(path, volumeSize)
+		if err != nil {
+			return nil, err
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		return vw, nil
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	removeVolumes(path, 1)
+	return f, nil
+}
+
+// commitOutput moves an archive written to tmp into place at path
+func commitOutput(tmp, path string, volumeSize int64) error {
+	if volumeSize == 0 {
+		if err := os.Rename(tmp, path); err != nil {
+			return err
+		}
+		removeVolumes(path, 1)
+		return nil
+	}
+
+	n := 1
+	for ; ; n++ {
+		err := os.Rename(volumeName(tmp, n), volumeName(path, n))
+		if os.IsNotExist(err) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	removeVolumes(path, n)
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// removeOutput deletes an archive written to tmp, including any volumes
+func removeOutput(tmp string) {
+	os.Remove(tmp)
+	removeVolumes(tmp, 1)
+}
@@ -0,0 +1,107 @@
+package archiver
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestVolumeSet(t *testing.T) {
+	outputPath := filepath.Join(t.TempDir(), "photos.tar")
+	config := Config{
+		SourcePath: "testdata/source",
+		OutputPath: outputPath,
+		Recursive:  true,
+		FilterMode: FilterAll,
+		Modifiable: true,
+		VolumeSize: 1500,
+	}
+	a := createArchive(t, config)
+
+	if _, err := os.Stat(outputPath); !os.IsNotExist(err) {
+		t.Errorf("Expected no single-file archive, got %v", err)
+	}
+	volumes, _ := filepath.Glob(outputPath + ".*")
+	if len(volumes) < 3 {
+		t.Fatalf("Expected several volumes, got %v", volumes)
+	}
+	for _, v := range volumes[:len(volumes)-1] {
+		if info, err := os.Stat(v); err != nil || info.Size() != config.VolumeSize {
+			t.Errorf("Expected %s to be a full volume", v)
+		}
+	}
+
+	files, err := a.ListFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 7 {
+		t.Errorf("Expected 7 files, got %v", files)
+	}
+
+	dest := t.TempDir()
+	for result := range a.Extract(dest) {
+		if result.Error != nil {
+			t.Fatal(result.Error)
+		}
+	}
+	if data, err := os.ReadFile(filepath.Join(dest, "doc1.txt")); err != nil || string(data) != "This is a test document" {
+		t.Errorf("Expected doc1.txt to be restored, got %q (%v)", data, err)
+	}
+
+	for result := range a.Modify([]ModifyRequest{{Operation: OperationRemove, Path: "doc1.txt"}}, CompressionDefault) {
+		if result.Error != nil {
+			t.Fatal(result.Error)
+		}
+	}
+	if files, err = a.ListFiles(); err != nil || len(files) != 6 {
+		t.Errorf("Expected 6 files after removal, got %v (%v)", files, err)
+	}
+	if _, err := os.Stat(outputPath); !os.IsNotExist(err) {
+		t.Errorf("Expected Modify to keep the volume set, got %v", err)
+	}
+
+	missing := volumeName(outputPath, 2)
+	if err := os.Remove(missing); err != nil {
+		t.Fatal(err)
+	}
+	_, err = a.ListFiles()
+	if !errors.Is(err, ErrMissingVolume) || !strings.Contains(err.Error(), missing) {
+		t.Errorf("Expected missing volume error naming %s, got %v", missing, err)
+	}
+}
+
+func TestEncryptedVolumeSet(t *testing.T) {
+	id, recipient, err := GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, other, err := GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	outputPath := filepath.Join(t.TempDir(), "photos.zip")
+	a := createArchive(t, Config{
+		SourcePath: "testdata/source",
+		OutputPath: outputPath,
+		Recursive:  true,
+		FilterMode: FilterAll,
+		Recipients: []string{recipient},
+		Identities: []string{id},
+		VolumeSize: 512,
+	})
+
+	if err := a.AddRecipients([]string{other}); err != nil {
+		t.Fatal(err)
+	}
+	files, err := a.ListFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 7 {
+		t.Errorf("Expected 7 files, got %v", files)
+	}
+}
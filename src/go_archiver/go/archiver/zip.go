@@ -0,0 +1,180 @@
+package archiver
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/flate"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// zipEntryReader iterates the entries of a zip archive
+type zipEntryReader struct {
+	files []*zip.File
+	next  int
+	rc    io.ReadCloser
+}
+
+func newZipEntryReader(ra io.ReaderAt) (*zipEntryReader, error) {
+	size, err := readerAtSize(ra)
+	if err != nil {
+		return nil, err
+	}
+	zr, err := zip.NewReader(ra, size)
+	if err != nil {
+		return nil, err
+	}
+	return &zipEntryReader{files: zr.File}, nil
+}
+
+// readerAtSize returns the size of a file backing ra
+func readerAtSize(ra io.ReaderAt) (int64, error) {
+	type stater interface {
+		Stat() (os.FileInfo, error)
+	}
+	info, err := ra.(stater).Stat()
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+// Next advances to the next entry and returns its header in tar form
+func (z *zipEntryReader) Next() (*tar.Header, error) {
+	if err := z.closeEntry(); err != nil {
+		return nil, err
+	}
+	if z.next >= len(z.files) {
+		return nil, io.EOF
+	}
+
+	f := z.files[z.next]
+	z.next++
+
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	z.rc = rc
+
+	hdr := zipToTarHeader(&f.FileHeader)
+	if hdr.Typeflag == tar.TypeSymlink {
+		target, err := io.ReadAll(io.LimitReader(rc, 4096))
+		if err != nil {
+			return nil, err
+		}
+		hdr.Linkname, hdr.Size = string(target), 0
+	}
+	return hdr, nil
+}
+
+// offset returns the position of the current entry's data in the archive
+func (z *zipEntryReader) offset() (int64, error) {
+	return z.files[z.next-1].DataOffset()
+}
+
+// Read reads the content of the current entry
+func (z *zipEntryReader) Read(p []byte) (int, error) {
+	if z.rc == nil {
+		return 0, io.EOF
+	}
+	return z.rc.Read(p)
+}
+
+func (z *zipEntryReader) closeEntry() error {
+	if z.rc == nil {
+		return nil
+	}
+	err := z.rc.Close()
+	z.rc = nil
+	return err
+}
+
+// Close releases the current entry
+func (z *zipEntryReader) Close() error {
+	return z.closeEntry()
+}
+
+// zipToTarHeader converts zip entry metadata to the tar header used
+// throughout the archiver
+func zipToTarHeader(fh *zip.FileHeader) *tar.Header {
+	hdr := &tar.Header{
+		Name:     fh.Name,
+		Size:     int64(fh.UncompressedSize64),
+		Mode:     int64(fh.Mode().Perm()),
+		ModTime:  fh.Modified,
+		Typeflag: tar.TypeReg,
+	}
+	if strings.HasSuffix(fh.Name, "/") {
+		hdr.Typeflag = tar.TypeDir
+		hdr.Size = 0
+	} else if typeflag := typeflagFor(fh.Mode()); typeflag != tar.TypeDir {
+		hdr.Typeflag = typeflag
+	}
+	return hdr
+}
+
+// zipEntryWriter writes entries to a zip archive, choosing Store or Deflate
+// per entry. The standard library takes care of ZIP64 records for large
+// entries and counts, the UTF-8 name flag and extended timestamps.
+type zipEntryWriter struct {
+	zw    *zip.Writer
+	w     io.Writer
+	store bool // store every entry, for CompressionNone
+}
+
+func newZipEntryWriter(w io.Writer, compression CompressionLevel) *zipEntryWriter {
+	zw := zip.NewWriter(w)
+	zw.RegisterCompressor(zip.Deflate, func(out io.Writer) (io.WriteCloser, error) {
+		return flate.NewWriter(out, compression.flateLevel())
+	})
+	return &zipEntryWriter{zw: zw, store: compression == CompressionNone}
+}
+
+// WriteHeader starts a new deflated zip entry from a tar header
+func (z *zipEntryWriter) WriteHeader(hdr *tar.Header) error {
+	return z.WriteHeaderMethod(hdr, false)
+}
+
+// WriteHeaderMethod starts a new zip entry, stored uncompressed if store is
+// set
+func (z *zipEntryWriter) WriteHeaderMethod(hdr *tar.Header, store bool) error {
+	fh := &zip.FileHeader{
+		Name:     hdr.Name,
+		Method:   zip.Deflate,
+		Modified: hdr.ModTime.Round(time.Second), // As tar rounds it
+	}
+	mode := os.FileMode(hdr.Mode).Perm() | typeMode(hdr.Typeflag)
+	if hdr.Typeflag == tar.TypeDir && !strings.HasSuffix(fh.Name, "/") {
+		fh.Name += "/"
+	}
+	fh.SetMode(mode)
+	if mode.Type() != 0 || store || z.store {
+		fh.Method = zip.Store
+	}
+
+	w, err := z.zw.CreateHeader(fh)
+	if err != nil {
+		return err
+	}
+	z.w = w
+
+	// Zip stores the target of a symbolic link as its content
+	if hdr.Typeflag == tar.TypeSymlink {
+		_, err = io.WriteString(w, hdr.Linkname)
+	}
+	return err
+}
+
+// Write writes content to the current entry
+func (z *zipEntryWriter) Write(p []byte) (int, error) {
+	return z.w.Write(p)
+}
+
+// Close writes the central directory. It does not close the underlying
+// writer.
+func (z *zipEntryWriter) Close() error {
+	return z.zw.Close()
+}
@@ -0,0 +1,171 @@
+package archiver
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/rand"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+func TestZipArchive(t *testing.T) {
+	outputPath := filepath.Join(t.TempDir(), "photos.zip")
+	a := createArchive(t, Config{
+		SourcePath:      "testdata/source",
+		OutputPath:      outputPath,
+		Recursive:       true,
+		FilterMode:      FilterAll,
+		Modifiable:      true,
+		StoreExtensions: []string{"jpg", "mp4"},
+	})
+
+	zr, err := zip.OpenReader(outputPath)
+	if err != nil {
+		t.Fatalf("Expected a valid zip file: %v", err)
+	}
+	for _, f := range zr.File {
+		want := zip.Deflate
+		if f.Name == "photo1.jpg" || f.Name == "video1.mp4" {
+			want = zip.Store
+		}
+		if f.Method != want {
+			t.Errorf("Expected method %d for %s, got %d", want, f.Name, f.Method)
+		}
+		if f.Modified.IsZero() {
+			t.Errorf("Expected timestamp for %s", f.Name)
+		}
+	}
+	zr.Close()
+
+	files, err := a.ListFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 7 {
+		t.Errorf("Expected 7 files, got %v", files)
+	}
+
+	entry, err := a.GetFileInfo("doc1.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.Size != int64(len("This is a test document")) {
+		t.Errorf("Expected size of doc1.txt, got %d", entry.Size)
+	}
+
+	dest := t.TempDir()
+	for result := range a.Extract(dest) {
+		if result.Error != nil {
+			t.Errorf("Unexpected extract error: %v", result.Error)
+		}
+	}
+	data, err := os.ReadFile(filepath.Join(dest, "doc1.txt"))
+	if err != nil || string(data) != "This is a test document" {
+		t.Errorf("Expected extracted doc1.txt content, got %q (%v)", data, err)
+	}
+
+	for result := range a.Modify([]ModifyRequest{{Operation: OperationRemove, Path: "doc1.txt"}}, CompressionDefault) {
+		if result.Error != nil {
+			t.Fatal(result.Error)
+		}
+	}
+	if _, err := zip.OpenReader(outputPath); err != nil {
+		t.Errorf("Expected Modify to keep the zip container: %v", err)
+	}
+	files, err = a.ListFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 6 {
+		t.Errorf("Expected 6 files after removal, got %v", files)
+	}
+}
+
+func TestPlainTarArchive(t *testing.T) {
+	a := createArchive(t, Config{
+		SourcePath: "testdata/source",
+		OutputPath: filepath.Join(t.TempDir(), "photos.tar"),
+		Recursive:  true,
+		FilterMode: FilterPhotos,
+	})
+
+	files, err := a.ListFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(files)
+	if len(files) != 2 || files[0] != "photo1.jpg" || files[1] != "photo2.png" {
+		t.Errorf("Expected photo1.jpg and photo2.png, got %v", files)
+	}
+}
+
+func TestCompressionPolicyAuto(t *testing.T) {
+	sourceDir := t.TempDir()
+	random := make([]byte, 32*1024)
+	rand.Read(random)
+	files := map[string][]byte{
+		"notes.txt":  bytes.Repeat([]byte("compressible text "), 2048),
+		"random.bin": random,
+		"photo.jpg":  bytes.Repeat([]byte("not really a jpeg "), 2048),
+		"data.csv":   bytes.Repeat([]byte("1,2,3,4\n"), 4096),
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(sourceDir, name), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	zipPath := filepath.Join(t.TempDir(), "auto.zip")
+	createArchive(t, Config{
+		SourcePath:        sourceDir,
+		OutputPath:        zipPath,
+		FilterMode:        FilterAll,
+		CompressionPolicy: PolicyAuto,
+	})
+
+	zr, err := zip.OpenReader(zipPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zr.Close()
+	for _, f := range zr.File {
+		stored := f.Method == zip.Store
+		wantStored := f.Name == "random.bin" || f.Name == "photo.jpg"
+		if stored != wantStored {
+			t.Errorf("Expected %s stored=%v, got method %d", f.Name, wantStored, f.Method)
+		}
+	}
+
+	// tar.gz output groups compressible files ahead of compressed media
+	tarPath := filepath.Join(t.TempDir(), "auto.tar.gz")
+	a := createArchive(t, Config{
+		SourcePath:        sourceDir,
+		OutputPath:        tarPath,
+		FilterMode:        FilterAll,
+		CompressionPolicy: PolicyAuto,
+	})
+	tr, err := a.openArchive(tarPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tr.Close()
+	var order []string
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !isMetaEntry(header.Name) {
+			order = append(order, header.Name)
+		}
+	}
+	if len(order) != 4 || order[len(order)-1] != "photo.jpg" {
+		t.Errorf("Expected photo.jpg to be written last, got %v", order)
+	}
+}
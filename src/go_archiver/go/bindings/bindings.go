@@ -1,12 +1,16 @@
 package bindings
 
 import (
+	"path/filepath"
+	"time"
+
 	"go-archiver/archiver"
 )
 
 // PyArchiver wraps the Go Archiver for Python
 type PyArchiver struct {
-    arch *archiver.Archiver
+    config archiver.Config
+    arch   *archiver.Archiver
 }
 
 // NewArchiver creates a new PyArchiver instance
@@ -18,28 +22,467 @@ func NewArchiver(sourcePath, outputPath string, recursive bool, filterMode strin
         FilterMode:  archiver.FilterMode(filterMode),
         Modifiable:  true,
     }
-    
+
     return &PyArchiver{
-        arch: archiver.New(config),
+        config: config,
+        arch:   archiver.New(config),
     }
 }
 
+// reconfigure rebuilds the wrapped Archiver after a config change
+func (p *PyArchiver) reconfigure() {
+    p.arch = archiver.New(p.config)
+}
+
 // Archive processes files and creates the archive
 func (p *PyArchiver) Archive() error {
+    return p.archive(false)
+}
+
+// Resume continues an interrupted Archive from its checkpoint
+func (p *PyArchiver) Resume() error {
+    return p.archive(true)
+}
+
+func (p *PyArchiver) archive(resume bool) error {
     scanResults, err := p.arch.Scan()
     if err != nil {
         return err
     }
 
     filterResults := p.arch.Filter(scanResults)
-    createResults := p.arch.Create(filterResults)
+    if p.config.SplitBy != archiver.SplitNone {
+        if resume {
+            return archiver.ErrNotResumable
+        }
+        report := archiver.CollectRoutes(p.arch.Route(filterResults))
+        var runErr error
+        for _, err := range report.Errors {
+            if fileErr, ok := err.(*archiver.FileError); ok {
+                p.arch.UpdateResult(0, 0, "", fileErr)
+                continue
+            }
+            if runErr == nil {
+                runErr = err
+            }
+        }
+        return runErr
+    }
+    create := p.arch.Create
+    if resume {
+        create = p.arch.Resume
+    }
+
+    // Wait for completion; failed files are recorded and the error policy
+    // decides whether the run fails
+    var runErr error
+    for result := range create(filterResults) {
+        if fileErr, ok := result.Error.(*archiver.FileError); ok {
+            p.arch.UpdateResult(0, 0, "", fileErr)
+            continue
+        }
+        if result.Error != nil && runErr == nil {
+            runErr = result.Error
+        }
+    }
+
+    return runErr
+}
+
+// FailedFiles describes every file the last Archive could not archive
+func (p *PyArchiver) FailedFiles() []string {
+    var failed []string
+    if joined, ok := p.arch.GetErrors().(interface{ Unwrap() []error }); ok {
+        for _, err := range joined.Unwrap() {
+            failed = append(failed, err.Error())
+        }
+    }
+    return failed
+}
+
+// SetRecipients sets the X25519 public keys new archives are encrypted to
+func (p *PyArchiver) SetRecipients(recipients []string) {
+    p.config.Recipients = recipients
+    p.reconfigure()
+}
+
+// SetIdentities sets the X25519 private keys used to open encrypted archives
+func (p *PyArchiver) SetIdentities(identities []string) {
+    p.config.Identities = identities
+    p.reconfigure()
+}
+
+// AddRecipients grants more recipients access to the encrypted archive
+func (p *PyArchiver) AddRecipients(recipients []string) error {
+    return p.arch.AddRecipients(recipients)
+}
+
+// RemoveRecipients revokes recipients from the encrypted archive
+func (p *PyArchiver) RemoveRecipients(recipients []string) error {
+    return p.arch.RemoveRecipients(recipients)
+}
+
+// GenerateIdentity returns a new X25519 private key; use RecipientFor to
+// derive the public key to share
+func GenerateIdentity() (string, error) {
+    identity, _, err := archiver.GenerateX25519Identity()
+    return identity, err
+}
+
+// RecipientFor returns the public key for an identity
+func RecipientFor(identity string) (string, error) {
+    return archiver.X25519Recipient(identity)
+}
+
+// SetSigningKey sets the Ed25519 private key used to sign new archives
+func (p *PyArchiver) SetSigningKey(signingKey string, detached bool) {
+    p.config.SigningKey = signingKey
+    p.config.DetachedSignature = detached
+    p.reconfigure()
+}
+
+// SetTrustedKeys sets the Ed25519 public keys accepted when verifying
+func (p *PyArchiver) SetTrustedKeys(trustedKeys []string) {
+    p.config.TrustedKeys = trustedKeys
+    p.reconfigure()
+}
+
+// Verify checks the archive signature against the trusted keys
+func (p *PyArchiver) Verify() error {
+    return p.arch.Verify()
+}
+
+// Extract restores the archive into dest, verifying it first when trusted
+// keys are set
+func (p *PyArchiver) Extract(dest string) error {
+    var firstErr error
+    for result := range p.arch.Extract(dest) {
+        if result.Error != nil && firstErr == nil {
+            firstErr = result.Error
+        }
+    }
+    return firstErr
+}
 
-    // Wait for completion
-    for result := range createResults {
+// SetFormat selects the output container: "tar", "tar.gz" or "zip"
+func (p *PyArchiver) SetFormat(format string, storeExtensions []string) {
+    p.config.Format = archiver.OutputFormat(format)
+    p.config.StoreExtensions = storeExtensions
+    p.reconfigure()
+}
+
+// SetCompressionPolicy selects the per-entry compression policy ("deflate"
+// or "auto") and the sampled ratio above which "auto" stores an entry
+func (p *PyArchiver) SetCompressionPolicy(policy string, storeThreshold float64) {
+    p.config.CompressionPolicy = archiver.CompressionPolicy(policy)
+    p.config.StoreThreshold = storeThreshold
+    p.reconfigure()
+}
+
+// SetCodec selects the compression codec for tar output ("none", "gzip",
+// "zlib", "deflate" or "pgzip") and the compression level (0 for default,
+// -3 to store entries without compression)
+func (p *PyArchiver) SetCodec(codec string, level int) {
+    p.config.Codec = archiver.Codec(codec)
+    p.config.CompressionLevel = archiver.CompressionLevel(level)
+    p.reconfigure()
+}
+
+// SetVolumeSize splits new archives into volumes of volumeSize bytes
+// (output.001, output.002, ...); zero writes a single file
+func (p *PyArchiver) SetVolumeSize(volumeSize int64) {
+    p.config.VolumeSize = volumeSize
+    p.reconfigure()
+}
+
+// SetSplitBy routes files into one archive per "category" or "month";
+// OutputPath may contain a {key} placeholder for the route key
+func (p *PyArchiver) SetSplitBy(splitBy string) {
+    p.config.SplitBy = archiver.SplitMode(splitBy)
+    p.reconfigure()
+}
+
+// SetBackup selects the backup level ("full", "differential" or
+// "incremental") and the snapshot file that carries state between runs;
+// an empty level disables backups
+func (p *PyArchiver) SetBackup(level string, snapshotPath string) {
+    p.config.BackupLevel = archiver.BackupLevel(level)
+    p.config.SnapshotPath = snapshotPath
+    p.reconfigure()
+}
+
+// AddSource adds a source root stored under prefix in the archive. Once a
+// root is added, SourcePath is no longer scanned.
+func (p *PyArchiver) AddSource(path string, prefix string, recursive bool, filterMode string, ignore []string) {
+    p.config.Sources = append(p.config.Sources, archiver.Source{
+        Path:       path,
+        Prefix:     prefix,
+        Recursive:  recursive,
+        FilterMode: archiver.FilterMode(filterMode),
+        Ignore:     ignore,
+    })
+    p.reconfigure()
+}
+
+// ClearSources goes back to scanning SourcePath
+func (p *PyArchiver) ClearSources() {
+    p.config.Sources = nil
+    p.reconfigure()
+}
+
+// PlanRestore returns the restore plan for the backup archives in dir as a
+// dry run; target is an RFC 3339 timestamp, empty for the latest state
+func (p *PyArchiver) PlanRestore(dir string, target string) (string, error) {
+    plan, err := p.planRestore(dir, target)
+    if err != nil {
+        return "", err
+    }
+    return plan.String(), nil
+}
+
+// Restore extracts the state of the backup chain in dir at target into dest
+func (p *PyArchiver) Restore(dir string, target string, dest string) error {
+    plan, err := p.planRestore(dir, target)
+    if err != nil {
+        return err
+    }
+
+    var firstErr error
+    for result := range p.arch.Restore(plan, dest) {
+        if result.Error != nil && firstErr == nil {
+            firstErr = result.Error
+        }
+    }
+    return firstErr
+}
+
+func (p *PyArchiver) planRestore(dir string, target string) (*archiver.RestorePlan, error) {
+    var at time.Time
+    if target != "" {
+        var err error
+        if at, err = time.Parse(time.RFC3339, target); err != nil {
+            return nil, err
+        }
+    }
+    return p.arch.PlanRestore(dir, at)
+}
+
+// Sync mirrors the source directory into the existing archive, comparing
+// files by size and mtime, or by size and content hash when compareHash is
+// set
+func (p *PyArchiver) Sync(compareHash bool) error {
+    var firstErr error
+    for result := range p.arch.Sync(compareHash, archiver.CompressionUnset) {
+        if result.Error != nil && firstErr == nil {
+            firstErr = result.Error
+        }
+    }
+    return firstErr
+}
+
+// SetScanPolicies sets how symbolic links ("store", "follow" or "skip") and
+// special files ("skip", "store" or "error") are scanned, and whether the
+// scan stays on one file system
+func (p *PyArchiver) SetScanPolicies(symlinks string, specialFiles string, oneFileSystem bool) {
+    p.config.SymlinkPolicy = archiver.SymlinkPolicy(symlinks)
+    p.config.SpecialFiles = archiver.SpecialPolicy(specialFiles)
+    p.config.OneFileSystem = oneFileSystem
+    p.reconfigure()
+}
+
+// SetReproducible makes archives byte-identical across runs over the same
+// tree; sourceDateEpoch clamps mtimes as Unix seconds, zero to use
+// $SOURCE_DATE_EPOCH
+func (p *PyArchiver) SetReproducible(reproducible bool, sourceDateEpoch int64) {
+    p.config.Reproducible = reproducible
+    p.config.SourceDateEpoch = time.Time{}
+    if sourceDateEpoch != 0 {
+        p.config.SourceDateEpoch = time.Unix(sourceDateEpoch, 0)
+    }
+    p.reconfigure()
+}
+
+// SetChangePolicy sets what happens to files that change while they are
+// archived: "fail", "retry", "snapshot" or "mark"
+func (p *PyArchiver) SetChangePolicy(policy string, retries int) {
+    p.config.ChangedFiles = archiver.ChangePolicy(policy)
+    p.config.ChangeRetries = retries
+    p.reconfigure()
+}
+
+// SetErrorPolicy sets when archiving gives up after files fail:
+// "fail-fast", "continue" or "max-errors" with maxErrors tolerated, and how
+// often transient IO errors are retried
+func (p *PyArchiver) SetErrorPolicy(policy string, maxErrors int, ioRetries int) {
+    p.config.ErrorPolicy = archiver.ErrorPolicy(policy)
+    p.config.MaxErrors = maxErrors
+    p.config.IORetries = ioRetries
+    p.reconfigure()
+}
+
+// SetCheckpoint checkpoints archiving to path every so many bytes, zero for
+// the default, so that Resume can continue an interrupted run
+func (p *PyArchiver) SetCheckpoint(path string, every int64) {
+    p.config.CheckpointPath = path
+    p.config.CheckpointEvery = every
+    p.reconfigure()
+}
+
+// SetFilesFrom archives the paths listed in a file, "-" for stdin, instead
+// of scanning; nul selects NUL-delimited lists such as find -print0 writes
+func (p *PyArchiver) SetFilesFrom(list string, nul bool) {
+    p.config.FilesFrom = list
+    p.config.FilesFromNull = nul
+    p.reconfigure()
+}
+
+// AddFilesFrom adds the files named in a path list to the archive
+func (p *PyArchiver) AddFilesFrom(list string, nul bool) error {
+    result := p.arch.BatchAddFilesFrom(list, nul, 0, archiver.CompressionUnset)
+    if len(result.Errors) > 0 {
+        return result.Errors[0]
+    }
+    return nil
+}
+
+// RemoveFilesFrom removes the entries named in a path list from the archive
+func (p *PyArchiver) RemoveFilesFrom(list string, nul bool) error {
+    result := p.arch.BatchRemoveFilesFrom(list, nul, 0, archiver.CompressionUnset)
+    if len(result.Errors) > 0 {
+        return result.Errors[0]
+    }
+    return nil
+}
+
+// Diff compares the archive with another archive or a directory and returns
+// the report as JSON or as human-readable text
+func (p *PyArchiver) Diff(other string, asJSON bool) (string, error) {
+    report, err := p.arch.Diff(other)
+    if err != nil {
+        return "", err
+    }
+    if asJSON {
+        data, err := report.JSON()
+        return string(data), err
+    }
+    return report.String(), nil
+}
+
+// Plan reports what Archive would write, without writing anything, as
+// JSON or as human-readable text
+func (p *PyArchiver) Plan(asJSON bool) (string, error) {
+    plan, err := p.arch.Plan()
+    if err != nil {
+        return "", err
+    }
+    if asJSON {
+        data, err := plan.JSON()
+        return string(data), err
+    }
+    return plan.String(), nil
+}
+
+// PlanModify reports which entries adding and removing the given paths
+// would match, without rewriting the archive
+func (p *PyArchiver) PlanModify(add []string, remove []string, asJSON bool) (string, error) {
+    var requests []archiver.ModifyRequest
+    for _, path := range add {
+        requests = append(requests, archiver.ModifyRequest{
+            Operation: archiver.OperationAdd,
+            Path:      path,
+            FileInfo:  archiver.FileInfo{Path: path},
+        })
+    }
+    for _, path := range remove {
+        requests = append(requests, archiver.ModifyRequest{
+            Operation: archiver.OperationRemove,
+            Path:      path,
+        })
+    }
+
+    plan, err := p.arch.PlanModify(requests)
+    if err != nil {
+        return "", err
+    }
+    if asJSON {
+        data, err := plan.JSON()
+        return string(data), err
+    }
+    return plan.String(), nil
+}
+
+// Estimation predicts the archive Archive would write
+type Estimation struct {
+    Files      int64
+    InputSize  int64
+    OutputSize int64   // Predicted archive size in bytes
+    Seconds    float64 // Predicted runtime
+    FreeSpace  int64   // Bytes free at the destination, -1 when unknown
+}
+
+// Estimate samples the source to predict the archive size and runtime, and
+// reports the free space at the destination
+func (p *PyArchiver) Estimate() (*Estimation, error) {
+    estimate, err := p.arch.Estimate()
+    if err != nil {
+        return nil, err
+    }
+    free, err := archiver.FreeSpace(filepath.Dir(p.config.OutputPath))
+    if err != nil {
+        free = -1
+    }
+    return &Estimation{
+        Files:      estimate.Files,
+        InputSize:  estimate.InputSize,
+        OutputSize: estimate.OutputSize,
+        Seconds:    estimate.Duration.Seconds(),
+        FreeSpace:  free,
+    }, nil
+}
+
+// Merge combines the input archives into a new archive at the output path.
+// conflictPolicy is "first", "newest", "largest" or "keep-both"; dedup
+// stores repeated content once
+func (p *PyArchiver) Merge(inputs []string, conflictPolicy string, dedup bool) error {
+    for result := range p.arch.Merge(inputs, archiver.ConflictPolicy(conflictPolicy), dedup) {
         if result.Error != nil {
             return result.Error
         }
     }
-
     return nil
-}
\ No newline at end of file
+}
+
+// SetSeekable writes tar.gz archives with one gzip member per entry and a
+// trailing index, so single files can be listed and extracted by seeking
+func (p *PyArchiver) SetSeekable(seekable bool) {
+    p.config.Seekable = seekable
+    p.reconfigure()
+}
+
+// ExtractFile restores a single entry of the archive into dest
+func (p *PyArchiver) ExtractFile(name string, dest string) error {
+    return p.arch.ExtractFile(name, dest)
+}
+
+// ListFiles returns one page of entry names, filtered by name prefix and
+// base name pattern and sorted by "name", "size" or "mtime"; a zero limit
+// returns every match
+func (p *PyArchiver) ListFiles(prefix string, pattern string, sortBy string, descending bool, offset int, limit int) ([]string, error) {
+    page, err := p.arch.ListFilesPage(archiver.ListOptions{
+        Prefix:     prefix,
+        Pattern:    pattern,
+        SortBy:     archiver.ListSort(sortBy),
+        Descending: descending,
+        Offset:     offset,
+        Limit:      limit,
+    })
+    if err != nil {
+        return nil, err
+    }
+
+    names := make([]string, len(page.Entries))
+    for i, entry := range page.Entries {
+        names[i] = entry.Header.Name
+    }
+    return names, nil
+}